@@ -0,0 +1,32 @@
+package main
+
+import (
+	"chat-ai-backend/pkg/fakellm"
+	"chat-ai-backend/utils"
+	"flag"
+	"net/http"
+	"os"
+)
+
+func main() {
+	addr := flag.String("addr", ":8090", "Address to listen on")
+	fixturePath := flag.String("fixture", os.Getenv("FAKELLM_FIXTURE"), "JSON fixture scripting the answers (echoes questions when empty)")
+	flag.Parse()
+
+	fixture := &fakellm.Fixture{}
+	if *fixturePath != "" {
+		loaded, err := fakellm.LoadFixture(*fixturePath)
+		if err != nil {
+			utils.Logger.Error("Failed to load fixture: %v", err)
+			os.Exit(1)
+		}
+		fixture = loaded
+		utils.Logger.Info("Loaded %d scenarios from %s", len(fixture.Scenarios), *fixturePath)
+	}
+
+	utils.Logger.Info("Fake LLM server listening on %s", *addr)
+	if err := http.ListenAndServe(*addr, fakellm.NewServer(fixture)); err != nil {
+		utils.Logger.Error("Fake LLM server stopped: %v", err)
+		os.Exit(1)
+	}
+}
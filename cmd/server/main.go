@@ -21,9 +21,11 @@ func main() {
 	// Load configuration
 	config.LoadConfig()
 
-	// Initialize Milvus
-	// database.InitMilvus()
-	// defer database.CloseMilvus()
+	// Initialize Milvus when documents are stored there
+	if config.AppConfig.VectorStore == "milvus" {
+		database.InitMilvus()
+		defer database.CloseMilvus()
+	}
 
 	// Initialize MongoDB
 	database.InitMongo(config.AppConfig.MongoURI)
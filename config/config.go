@@ -6,27 +6,96 @@ import (
 	"log"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 )
 
 type Config struct {
-	MongoURI             string
-	JWTSecretKey         string
-	OpenAIUrl            string
-	OpenAIKey            string
-	RedisHost            string
-	RedisPort            string
-	RedisPassword        string
-	RedisDB              int
-	RedisChatDB          int
-	MilvusHost           string
-	MilvusPort           int
-	AccessTokenDuration  time.Duration
-	RefreshTokenDuration time.Duration
+	MongoURI               string
+	JWTSecretKey           string
+	LLMProvider            string // Which LLM backend to use: openai, ollama or anthropic
+	LLMModel               string
+	LLMEmbeddingModel      string
+	LLMMaxTokens           int // Used when a provider requires max_tokens and none was requested
+	OpenAIUrl              string
+	OpenAIKey              string
+	OllamaUrl              string
+	AnthropicUrl           string
+	AnthropicKey           string
+	LLMMaxRetries          int
+	LLMRetryBaseDelay      time.Duration
+	LLMRetryMaxDelay       time.Duration
+	LLMBreakerFailures     int
+	LLMBreakerOpenDuration time.Duration
+	LLMToolsEnabled        bool
+	LLMMaxToolRounds       int    // How many tool call round trips one answer may take
+	DailyTokenQuota        int    // Tokens per user per day, 0 = unlimited
+	DailyRequestQuota      int    // Requests per user per day, 0 = unlimited
+	AutoTitleEnabled       bool   // Default for users who have not set the auto_title preference
+	TitleModel             string // Model used for conversation titles, empty = LLMModel
+	SummaryTriggerTokens   int    // Unsummarized history size that triggers a summary, 0 = disabled
+	SummaryKeepTurns       int    // Most recent turns always sent verbatim
+	SummaryMaxTokens       int    // Length limit of a generated summary
+	SummaryModel           string // Model used for summaries, empty = LLMModel
+	RedisHost              string
+	RedisPort              string
+	RedisPassword          string
+	RedisDB                int
+	RedisChatDB            int
+	MilvusHost             string
+	MilvusPort             int
+	VectorStore            string   // Where document chunks are stored: memory or milvus
+	DevMode                bool     // Single local instance: allows stores kept in process memory
+	RAGTopK                int      // Chunks injected into the prompt, 0 = retrieval disabled
+	RAGMinScore            float64  // Minimum cosine similarity of an injected chunk
+	RAGChunkTokens         int      // Target size of a document chunk
+	RAGChunkOverlapTokens  int      // Text repeated at the start of the next chunk
+	DocumentMaxBytes       int64    // Largest accepted upload
+	SearchIndexEnabled     bool     // Embed flushed messages for semantic search
+	SearchBackfillOnStart  bool     // Index messages stored before indexing was enabled at startup
+	ModerationEnabled      bool     // Screen gateway requests before they reach the provider
+	ModerationOutput       bool     // Screen gateway answers too
+	ModerationModel        string   // Model of the provider's moderation endpoint
+	ModerationBlocklist    []string // Phrases that are always flagged, also with providers without moderation
+	AttachmentStore        string   // Where attachments are kept: local or s3
+	AttachmentDir          string   // Directory of the local attachment store
+	AttachmentMaxBytes     int64    // Largest accepted attachment
+	AttachmentTypes        []string // MIME types accepted as attachments
+	AttachmentMaxPerMsg    int      // Attachments one question may carry
+	S3Endpoint             string
+	S3Region               string
+	S3Bucket               string
+	S3AccessKey            string
+	S3SecretKey            string
+	S3PathStyle            bool          // Address the bucket in the path, as MinIO expects
+	LLMVisionModels        []string      // Model name prefixes that accept images
+	WSLegacyProtocol       bool          // Serve WebSocket clients that do not negotiate the envelope protocol
+	AnswerStreamTTL        time.Duration // How long a finished answer can still be replayed by a reconnecting client
+	AllowedOrigins         []string      // Browser origins allowed by CORS and the WebSocket handshake
+	WSPingInterval         time.Duration
+	WSPongTimeout          time.Duration // A connection without a pong (or frame) for this long is dropped
+	WSWriteTimeout         time.Duration
+	WSIdleTimeout          time.Duration // A connection without client frames or an answer in flight for this long is closed
+	WSMaxMessageBytes      int64
+	WSMaxConnsPerUser      int           // 0 disables the limit
+	WSMaxConns             int           // Across all instances, 0 disables the limit
+	ConversationLockLease  time.Duration // Lease of the per-conversation answer lock, renewed while answering
+	ConversationQueueMax   int           // Questions that may wait per conversation, 0 disables the limit
+	RedisMessageWindow     int           // Newest messages kept in Redis per conversation, 0 keeps all
+	HistoryPageSize        int           // Messages per history page, also sent on connect
+	AccessTokenDuration    time.Duration
+	RefreshTokenDuration   time.Duration
+	SystemPrompt           string
+	ContextTokenBudget     int            // Default context size in tokens for models without their own entry
+	ModelContextBudgets    map[string]int // Per-model context size in tokens, e.g. "gpt-4:8192,gpt-4o:128000"
+	CompletionReserve      int            // Tokens of the context kept free for the answer when no max_tokens is set
 }
 
 var AppConfig *Config
 
+// MaxHistoryPageSize caps the messages returned per history page
+const MaxHistoryPageSize = 200
+
 func LoadConfig() {
 	// Load the .env file , if use Dockerfile then I cannot use this
 	// err := godotenv.Load()
@@ -51,21 +120,118 @@ func LoadConfig() {
 		log.Printf("Invalid MILVUS_PORT value, must be an integer: %v", err)
 	}
 
+	contextTokenBudget, err := strconv.Atoi(getEnv("LLM_CONTEXT_TOKENS", "4096"))
+	if err != nil {
+		log.Printf("Invalid LLM_CONTEXT_TOKENS value, must be an integer: %v", err)
+		contextTokenBudget = 4096
+	}
+
+	llmMaxTokens, err := strconv.Atoi(getEnv("LLM_MAX_TOKENS", "1024"))
+	if err != nil {
+		log.Printf("Invalid LLM_MAX_TOKENS value, must be an integer: %v", err)
+		llmMaxTokens = 1024
+	}
+
 	// Initialize the AppConfig with values from the environment
 	AppConfig = &Config{
-		MongoURI:             getEnv("MONGO_URI", ""),
-		JWTSecretKey:         getEnv("JWT_SECRET_KEY", "default_jwt_secret"),
-		OpenAIUrl:            getEnv("OPENAI_URL", "http://localhost:8090"),
-		OpenAIKey:            getEnv("OPENAI_API_KEY", ""),
-		RedisHost:            getEnv("REDIS_HOST", "localhost"),
-		RedisPort:            getEnv("REDIS_PORT", "6379"),
-		RedisPassword:        getEnv("REDIS_PASSWORD", ""),
-		RedisDB:              redisDB,
-		RedisChatDB:          redisChatDB,
-		MilvusHost:           getEnv("MILVUS_HOST", "127.0.0.1"),
-		MilvusPort:           milvusPort,
-		AccessTokenDuration:  600 * time.Second,
-		RefreshTokenDuration: 7 * 24 * time.Hour,
+		MongoURI:               getEnv("MONGO_URI", ""),
+		JWTSecretKey:           getEnv("JWT_SECRET_KEY", "default_jwt_secret"),
+		LLMProvider:            getEnv("LLM_PROVIDER", "openai"),
+		LLMModel:               getEnv("LLM_MODEL", "gpt-4"),
+		LLMEmbeddingModel:      getEnv("LLM_EMBEDDING_MODEL", "text-embedding-3-small"),
+		LLMMaxTokens:           llmMaxTokens,
+		OpenAIUrl:              getEnv("OPENAI_URL", "http://localhost:8090"),
+		OpenAIKey:              getEnv("OPENAI_API_KEY", ""),
+		OllamaUrl:              getEnv("OLLAMA_URL", "http://localhost:11434"),
+		AnthropicUrl:           getEnv("ANTHROPIC_URL", "https://api.anthropic.com"),
+		AnthropicKey:           getEnv("ANTHROPIC_API_KEY", ""),
+		LLMMaxRetries:          getEnvInt("LLM_MAX_RETRIES", 3),
+		LLMRetryBaseDelay:      time.Duration(getEnvInt("LLM_RETRY_BASE_DELAY_MS", 500)) * time.Millisecond,
+		LLMRetryMaxDelay:       time.Duration(getEnvInt("LLM_RETRY_MAX_DELAY_MS", 10000)) * time.Millisecond,
+		LLMBreakerFailures:     getEnvInt("LLM_BREAKER_FAILURES", 5),
+		LLMBreakerOpenDuration: time.Duration(getEnvInt("LLM_BREAKER_OPEN_SECONDS", 30)) * time.Second,
+		LLMToolsEnabled:        getEnvBool("LLM_TOOLS_ENABLED", true),
+		LLMMaxToolRounds:       getEnvInt("LLM_MAX_TOOL_ROUNDS", 5),
+		DailyTokenQuota:        getEnvInt("USER_DAILY_TOKEN_QUOTA", 0),
+		DailyRequestQuota:      getEnvInt("USER_DAILY_REQUEST_QUOTA", 0),
+		AutoTitleEnabled:       getEnvBool("AUTO_TITLE_ENABLED", true),
+		TitleModel:             getEnv("LLM_TITLE_MODEL", ""),
+		SummaryTriggerTokens:   getEnvInt("SUMMARY_TRIGGER_TOKENS", 3000),
+		SummaryKeepTurns:       getEnvInt("SUMMARY_KEEP_TURNS", 4),
+		SummaryMaxTokens:       getEnvInt("SUMMARY_MAX_TOKENS", 400),
+		SummaryModel:           getEnv("LLM_SUMMARY_MODEL", ""),
+		RedisHost:              getEnv("REDIS_HOST", "localhost"),
+		RedisPort:              getEnv("REDIS_PORT", "6379"),
+		RedisPassword:          getEnv("REDIS_PASSWORD", ""),
+		RedisDB:                redisDB,
+		RedisChatDB:            redisChatDB,
+		MilvusHost:             getEnv("MILVUS_HOST", "127.0.0.1"),
+		MilvusPort:             milvusPort,
+		VectorStore:            getEnv("VECTOR_STORE", "memory"),
+		DevMode:                getEnvBool("DEV_MODE", false),
+		RAGTopK:                getEnvInt("RAG_TOP_K", 4),
+		RAGMinScore:            getEnvFloat("RAG_MIN_SCORE", 0.3),
+		RAGChunkTokens:         getEnvInt("RAG_CHUNK_TOKENS", 300),
+		RAGChunkOverlapTokens:  getEnvInt("RAG_CHUNK_OVERLAP_TOKENS", 50),
+		DocumentMaxBytes:       int64(getEnvInt("DOCUMENT_MAX_BYTES", 5<<20)),
+		SearchIndexEnabled:     getEnvBool("SEARCH_INDEX_ENABLED", true),
+		SearchBackfillOnStart:  getEnvBool("SEARCH_BACKFILL_ON_START", false),
+		ModerationEnabled:      getEnvBool("MODERATION_ENABLED", false),
+		ModerationOutput:       getEnvBool("MODERATION_CHECK_OUTPUT", false),
+		ModerationModel:        getEnv("MODERATION_MODEL", "omni-moderation-latest"),
+		ModerationBlocklist:    parseList(getEnv("MODERATION_BLOCKLIST", "")),
+		AttachmentStore:        getEnv("ATTACHMENT_STORE", "local"),
+		AttachmentDir:          getEnv("ATTACHMENT_DIR", "./data/attachments"),
+		AttachmentMaxBytes:     int64(getEnvInt("ATTACHMENT_MAX_BYTES", 10<<20)),
+		AttachmentTypes:        parseList(getEnv("ATTACHMENT_TYPES", "image/png,image/jpeg,image/gif,image/webp,text/plain")),
+		AttachmentMaxPerMsg:    getEnvInt("ATTACHMENT_MAX_PER_MESSAGE", 4),
+		S3Endpoint:             getEnv("S3_ENDPOINT", "https://s3.amazonaws.com"),
+		S3Region:               getEnv("S3_REGION", "us-east-1"),
+		S3Bucket:               getEnv("S3_BUCKET", ""),
+		S3AccessKey:            getEnv("S3_ACCESS_KEY", ""),
+		S3SecretKey:            getEnv("S3_SECRET_KEY", ""),
+		S3PathStyle:            getEnvBool("S3_PATH_STYLE", false),
+		LLMVisionModels:        parseList(getEnv("LLM_VISION_MODELS", "gpt-4o,gpt-4.1,gpt-4-turbo,claude-3,claude-sonnet,claude-opus,claude-haiku,llava,llama3.2-vision")),
+		WSLegacyProtocol:       getEnvBool("WS_LEGACY_PROTOCOL", true),
+		AnswerStreamTTL:        time.Duration(getEnvInt("ANSWER_STREAM_TTL_SECONDS", 600)) * time.Second,
+		AllowedOrigins:         parseList(getEnv("ALLOWED_ORIGINS", "http://localhost:3000")),
+		WSPingInterval:         time.Duration(getEnvInt("WS_PING_INTERVAL_SECONDS", 30)) * time.Second,
+		WSPongTimeout:          time.Duration(getEnvInt("WS_PONG_TIMEOUT_SECONDS", 75)) * time.Second,
+		WSWriteTimeout:         time.Duration(getEnvInt("WS_WRITE_TIMEOUT_SECONDS", 10)) * time.Second,
+		WSIdleTimeout:          time.Duration(getEnvInt("WS_IDLE_TIMEOUT_SECONDS", 900)) * time.Second,
+		WSMaxMessageBytes:      int64(getEnvInt("WS_MAX_MESSAGE_BYTES", 64<<10)),
+		WSMaxConnsPerUser:      getEnvInt("WS_MAX_CONNECTIONS_PER_USER", 5),
+		WSMaxConns:             getEnvInt("WS_MAX_CONNECTIONS", 10000),
+		ConversationLockLease:  time.Duration(getEnvInt("CONVERSATION_LOCK_LEASE_SECONDS", 30)) * time.Second,
+		ConversationQueueMax:   getEnvInt("CONVERSATION_QUEUE_MAX_DEPTH", 5),
+		RedisMessageWindow:     getEnvInt("REDIS_MESSAGE_WINDOW", 200),
+		HistoryPageSize:        getEnvInt("HISTORY_PAGE_SIZE", 50),
+		AccessTokenDuration:    600 * time.Second,
+		RefreshTokenDuration:   7 * 24 * time.Hour,
+		SystemPrompt:           getEnv("SYSTEM_PROMPT", "You are a helpful assistant."),
+		ContextTokenBudget:     contextTokenBudget,
+		ModelContextBudgets:    parseModelBudgets(getEnv("LLM_MODEL_CONTEXT_TOKENS", "gpt-4:8192,gpt-4o:128000")),
+		CompletionReserve:      getEnvInt("LLM_COMPLETION_RESERVE_TOKENS", llmMaxTokens),
+	}
+	if AppConfig.CompletionReserve < 0 {
+		log.Printf("LLM_COMPLETION_RESERVE_TOKENS must not be negative, using %d", llmMaxTokens)
+		AppConfig.CompletionReserve = llmMaxTokens
+	}
+	if AppConfig.HistoryPageSize <= 0 || AppConfig.HistoryPageSize > MaxHistoryPageSize {
+		log.Printf("HISTORY_PAGE_SIZE must be between 1 and %d, using 50", MaxHistoryPageSize)
+		AppConfig.HistoryPageSize = 50
+	}
+	if AppConfig.WSPingInterval <= 0 {
+		log.Printf("WS_PING_INTERVAL_SECONDS must be positive, using 30")
+		AppConfig.WSPingInterval = 30 * time.Second
+	}
+	if AppConfig.WSPongTimeout <= AppConfig.WSPingInterval {
+		log.Printf("WS_PONG_TIMEOUT_SECONDS must exceed WS_PING_INTERVAL_SECONDS, using twice the ping interval")
+		AppConfig.WSPongTimeout = 2 * AppConfig.WSPingInterval
+	}
+	if AppConfig.ConversationLockLease < 3*time.Second {
+		log.Printf("CONVERSATION_LOCK_LEASE_SECONDS must be at least 3, using 30")
+		AppConfig.ConversationLockLease = 30 * time.Second
 	}
 	log.Printf("Configuration loaded successfully!")
 }
@@ -78,3 +244,107 @@ func getEnv(key, defaultValue string) string {
 	}
 	return value
 }
+
+// Helper to get an integer environment variable or a default value
+func getEnvInt(key string, defaultValue int) int {
+	value, exists := os.LookupEnv(key)
+	if !exists {
+		return defaultValue
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		log.Printf("Invalid %s value, must be an integer: %v", key, err)
+		return defaultValue
+	}
+	return parsed
+}
+
+// Helper to get a boolean environment variable or a default value
+func getEnvBool(key string, defaultValue bool) bool {
+	value, exists := os.LookupEnv(key)
+	if !exists {
+		return defaultValue
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Printf("Invalid %s value, must be a boolean: %v", key, err)
+		return defaultValue
+	}
+	return parsed
+}
+
+// Helper to get a float environment variable or a default value
+func getEnvFloat(key string, defaultValue float64) float64 {
+	value, exists := os.LookupEnv(key)
+	if !exists {
+		return defaultValue
+	}
+	parsed, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		log.Printf("Invalid %s value, must be a number: %v", key, err)
+		return defaultValue
+	}
+	return parsed
+}
+
+// Helper to parse a "model:tokens,model:tokens" list into a map
+func parseModelBudgets(value string) map[string]int {
+	budgets := make(map[string]int)
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		idx := strings.LastIndex(entry, ":")
+		if idx <= 0 {
+			log.Printf("Invalid LLM_MODEL_CONTEXT_TOKENS entry %q, expected model:tokens", entry)
+			continue
+		}
+		tokens, err := strconv.Atoi(entry[idx+1:])
+		if err != nil || tokens <= 0 {
+			log.Printf("Invalid token budget in LLM_MODEL_CONTEXT_TOKENS entry %q", entry)
+			continue
+		}
+		budgets[entry[:idx]] = tokens
+	}
+	return budgets
+}
+
+// Helper to parse a comma separated list, skipping empty entries
+func parseList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// SupportsVision reports whether the model accepts images, by prefix of LLMVisionModels
+func (c *Config) SupportsVision(model string) bool {
+	for _, prefix := range c.LLMVisionModels {
+		if strings.HasPrefix(model, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// ContextBudgetFor returns the prompt token budget for the given model: its context size minus
+// the tokens reserved for the answer. maxTokens is the requested answer limit, 0 uses the default reserve.
+func (c *Config) ContextBudgetFor(model string, maxTokens int) int {
+	size, ok := c.ModelContextBudgets[model]
+	if !ok {
+		size = c.ContextTokenBudget
+	}
+
+	reserve := c.CompletionReserve
+	if maxTokens > 0 {
+		reserve = maxTokens
+	}
+	if reserve >= size {
+		return 0
+	}
+	return size - reserve
+}
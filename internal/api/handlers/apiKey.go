@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"chat-ai-backend/internal/repositories"
+	"chat-ai-backend/internal/services"
+	"chat-ai-backend/utils"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type APIKeyHandler struct {
+	APIKeyService *services.APIKeyService
+}
+
+func NewAPIKeyHandler(service *services.APIKeyService) *APIKeyHandler {
+	return &APIKeyHandler{APIKeyService: service}
+}
+
+// CreateAPIKey issues a key for the OpenAI-compatible API. The key is only shown in this response.
+func (h *APIKeyHandler) CreateAPIKey(c *gin.Context) {
+	// Extract userID from context
+	userID, ok := utils.GetUserIDFromContext(c)
+	if !ok {
+		return // Response already written in util
+	}
+
+	var req struct {
+		Name string `json:"name"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	plain, key, err := h.APIKeyService.CreateAPIKey(userID, req.Name)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidAPIKeyName) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create API key"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"key": plain, "api_key": key})
+}
+
+// ListAPIKeys returns the caller's API keys without their secrets
+func (h *APIKeyHandler) ListAPIKeys(c *gin.Context) {
+	// Extract userID from context
+	userID, ok := utils.GetUserIDFromContext(c)
+	if !ok {
+		return // Response already written in util
+	}
+
+	keys, err := h.APIKeyService.ListAPIKeys(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list API keys"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"api_keys": keys})
+}
+
+// DeleteAPIKey revokes one of the caller's API keys
+func (h *APIKeyHandler) DeleteAPIKey(c *gin.Context) {
+	// Extract userID from context
+	userID, ok := utils.GetUserIDFromContext(c)
+	if !ok {
+		return // Response already written in util
+	}
+
+	if err := h.APIKeyService.DeleteAPIKey(userID, c.Param("id")); err != nil {
+		if errors.Is(err, repositories.ErrAPIKeyNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "API key not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete API key"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "API key deleted successfully"})
+}
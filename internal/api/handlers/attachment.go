@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"chat-ai-backend/config"
+	"chat-ai-backend/internal/repositories"
+	"chat-ai-backend/internal/services"
+	"chat-ai-backend/utils"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+
+	"github.com/gin-gonic/gin"
+)
+
+type AttachmentHandler struct {
+	AttachmentService *services.AttachmentService
+}
+
+func NewAttachmentHandler(service *services.AttachmentService) *AttachmentHandler {
+	return &AttachmentHandler{AttachmentService: service}
+}
+
+// UploadAttachment stores an image or text file sent as the multipart field "file"
+func (h *AttachmentHandler) UploadAttachment(c *gin.Context) {
+	// Extract userID from context
+	userID, ok := utils.GetUserIDFromContext(c)
+	if !ok {
+		return // Response already written in util
+	}
+
+	maxBytes := config.AppConfig.AttachmentMaxBytes
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxBytes+1<<20) // room for the multipart framing
+
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing file"})
+		return
+	}
+	if fileHeader.Size > maxBytes {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("File is larger than %d bytes", maxBytes)})
+		return
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read file"})
+		return
+	}
+	defer file.Close()
+	data, err := io.ReadAll(io.LimitReader(file, maxBytes))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read file"})
+		return
+	}
+
+	attachment, err := h.AttachmentService.Upload(c.Request.Context(), userID, filepath.Base(fileHeader.Filename), data)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrAttachmentTooLarge):
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": err.Error()})
+		case errors.Is(err, services.ErrUnsupportedAttachment):
+			c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store attachment"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"attachment": attachment})
+}
+
+// ListAttachments returns the caller's attachments
+func (h *AttachmentHandler) ListAttachments(c *gin.Context) {
+	// Extract userID from context
+	userID, ok := utils.GetUserIDFromContext(c)
+	if !ok {
+		return // Response already written in util
+	}
+
+	attachments, err := h.AttachmentService.ListAttachments(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list attachments"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"attachments": attachments})
+}
+
+// GetAttachmentContent serves the bytes of one of the caller's attachments
+func (h *AttachmentHandler) GetAttachmentContent(c *gin.Context) {
+	// Extract userID from context
+	userID, ok := utils.GetUserIDFromContext(c)
+	if !ok {
+		return // Response already written in util
+	}
+
+	attachment, data, err := h.AttachmentService.OpenAttachment(c.Request.Context(), userID, c.Param("id"))
+	if err != nil {
+		if errors.Is(err, repositories.ErrAttachmentNotFound) || errors.Is(err, repositories.ErrBlobNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Attachment not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read attachment"})
+		return
+	}
+
+	// Uploads are user content: never let the browser guess a more dangerous type
+	c.Header("X-Content-Type-Options", "nosniff")
+	c.Header("Content-Disposition", mime.FormatMediaType("inline", map[string]string{"filename": attachment.Filename}))
+	c.Header("Cache-Control", "private, max-age=86400")
+	c.Data(http.StatusOK, attachment.ContentType, data)
+}
+
+// DeleteAttachment removes an attachment and its bytes
+func (h *AttachmentHandler) DeleteAttachment(c *gin.Context) {
+	// Extract userID from context
+	userID, ok := utils.GetUserIDFromContext(c)
+	if !ok {
+		return // Response already written in util
+	}
+
+	err := h.AttachmentService.DeleteAttachment(c.Request.Context(), userID, c.Param("id"))
+	if err != nil {
+		if errors.Is(err, repositories.ErrAttachmentNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Attachment not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete attachment"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Attachment deleted successfully"})
+}
@@ -0,0 +1,327 @@
+package handlers
+
+import (
+	"chat-ai-backend/config"
+	"chat-ai-backend/internal/models"
+	"chat-ai-backend/internal/services"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/gin-contrib/sse"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// ChatProtocolV1 is the WebSocket subprotocol of the typed envelope protocol
+const ChatProtocolV1 = "chat-ai.v1"
+
+// Envelope types sent by the server in the v1 protocol
+const (
+	envelopeHistory      = "history"
+	envelopeHistoryPage  = "history_page"
+	envelopeMessageStart = "message_start"
+	envelopeDelta        = "delta"
+	envelopeMessageEnd   = "message_end"
+	envelopeError        = "error"
+	envelopeEvent        = "event"
+)
+
+// envelope is one server frame of the v1 protocol
+type envelope struct {
+	Version        int         `json:"v"`
+	Type           string      `json:"type"`
+	ConversationID string      `json:"conversation_id,omitempty"`
+	MessageID      string      `json:"message_id,omitempty"`
+	Seq            int64       `json:"seq,omitempty"` // Position in the answer stream, passed as after= when resuming
+	Data           interface{} `json:"data,omitempty"`
+}
+
+// messageView is a stored message as clients of the v1 protocol see it
+type messageView struct {
+	MessageID       string                  `json:"message_id"`
+	ParentMessageID *string                 `json:"parent_message_id"`
+	VersionIndex    int                     `json:"version_index"`
+	Question        string                  `json:"question"`
+	Answer          string                  `json:"answer"`
+	Status          string                  `json:"status,omitempty"`
+	Usage           *models.Usage           `json:"usage,omitempty"`
+	ToolInvocations []models.ToolInvocation `json:"tool_invocations,omitempty"`
+	DroppedTurns    []string                `json:"dropped_turns,omitempty"` // History left out of the prompt
+	Attachments     []models.Attachment     `json:"attachments,omitempty"`
+	Metadata        map[string]string       `json:"metadata,omitempty"`
+	Feedback        *string                 `json:"feedback,omitempty"`
+	ThumbUp         int                     `json:"thumb_up"`
+	CreatedAt       time.Time               `json:"created_at"`
+}
+
+// messageViewFields are the JSON fields of messageView that can be asked for by name
+var messageViewFields = map[string]bool{
+	"message_id": true, "parent_message_id": true, "version_index": true, "question": true, "answer": true,
+	"status": true, "usage": true, "tool_invocations": true, "dropped_turns": true, "attachments": true,
+	"metadata": true, "feedback": true, "thumb_up": true, "created_at": true,
+}
+
+// projectMessageView keeps only the given fields of a message, and always its ID
+func projectMessageView(view messageView, fields []string) (map[string]json.RawMessage, error) {
+	data, err := json.Marshal(view)
+	if err != nil {
+		return nil, err
+	}
+	var all map[string]json.RawMessage
+	if err := json.Unmarshal(data, &all); err != nil {
+		return nil, err
+	}
+	projected := map[string]json.RawMessage{"message_id": all["message_id"]}
+	for _, field := range fields {
+		if value, ok := all[field]; ok {
+			projected[field] = value
+		}
+	}
+	return projected, nil
+}
+
+func newMessageViews(messages []models.Message) []messageView {
+	views := make([]messageView, 0, len(messages))
+	for _, msg := range messages {
+		views = append(views, newMessageView(msg))
+	}
+	return views
+}
+
+func newMessageView(msg models.Message) messageView {
+	return messageView{
+		MessageID:       msg.MessageID,
+		ParentMessageID: msg.ParentMessageID,
+		VersionIndex:    msg.VersionIndex,
+		Question:        msg.Question,
+		Answer:          msg.Answer,
+		Status:          msg.Status,
+		Usage:           msg.Usage,
+		ToolInvocations: msg.ToolInvocations,
+		DroppedTurns:    msg.DroppedTurns,
+		Attachments:     msg.Attachments,
+		Metadata:        msg.Metadata,
+		Feedback:        msg.Feedback,
+		ThumbUp:         msg.ThumbUp,
+		CreatedAt:       msg.CreatedAt,
+	}
+}
+
+// chatWriter writes the server side of a chat connection in one of the protocol versions.
+// Frames of an answer carry the sequence number of their chunk in the answer stream.
+// Like the socket it wraps, it must only be used by one goroutine.
+type chatWriter interface {
+	History(page *services.HistoryPage, activeMessageID string) error
+	HistoryPage(page *services.HistoryPage) error
+	Queued(seq int64, messageID string, position int) error
+	Started(seq int64, messageID string) error
+	MessageStart(seq int64, msg models.Message) error
+	Delta(seq int64, messageID, content string) error
+	ToolInvocation(seq int64, messageID string, invocation models.ToolInvocation) error
+	MessageEnd(seq int64, msg models.Message) error
+	Error(messageID, code, message string) error
+	LLMError(seq int64, messageID string, llmErr *services.LLMError) error
+	Event(name string, data gin.H) error
+}
+
+// newChatWriter picks the writer for the subprotocol negotiated during the upgrade
+func newChatWriter(conn *websocket.Conn, conversationID string) chatWriter {
+	timed := timedConn{conn}
+	if conn.Subprotocol() == ChatProtocolV1 {
+		return &envelopeWriter{send: func(e envelope) error { return timed.WriteJSON(e) }, conversationID: conversationID}
+	}
+	return &legacyWriter{conn: timed}
+}
+
+// timedConn bounds every write, so a client that stops reading cannot block the connection loop
+type timedConn struct {
+	*websocket.Conn
+}
+
+func (c timedConn) WriteJSON(v interface{}) error {
+	c.SetWriteDeadline(time.Now().Add(config.AppConfig.WSWriteTimeout))
+	return c.Conn.WriteJSON(v)
+}
+
+func (c timedConn) WriteMessage(messageType int, data []byte) error {
+	c.SetWriteDeadline(time.Now().Add(config.AppConfig.WSWriteTimeout))
+	return c.Conn.WriteMessage(messageType, data)
+}
+
+// newSSEWriter writes v1 envelopes as Server-Sent Events named after the envelope type, with seq as the event ID
+func newSSEWriter(c *gin.Context, conversationID string) chatWriter {
+	return &envelopeWriter{conversationID: conversationID, send: func(e envelope) error {
+		event := sse.Event{Event: e.Type, Data: e}
+		if e.Seq > 0 {
+			event.Id = strconv.FormatInt(e.Seq, 10)
+		}
+		if err := sse.Encode(c.Writer, event); err != nil {
+			return err
+		}
+		c.Writer.Flush()
+		return nil
+	}}
+}
+
+// envelopeWriter speaks the v1 protocol: every frame is a JSON envelope
+type envelopeWriter struct {
+	send           func(envelope) error
+	conversationID string
+}
+
+func (w *envelopeWriter) write(envelopeType, messageID string, seq int64, data interface{}) error {
+	return w.send(envelope{
+		Version:        1,
+		Type:           envelopeType,
+		ConversationID: w.conversationID,
+		MessageID:      messageID,
+		Seq:            seq,
+		Data:           data,
+	})
+}
+
+func (w *envelopeWriter) History(page *services.HistoryPage, activeMessageID string) error {
+	return w.write(envelopeHistory, "", 0, gin.H{
+		"messages":          newMessageViews(page.Messages),
+		"active_message_id": activeMessageID,
+		"cursor":            page.NextCursor,
+		"has_more":          page.HasMore,
+	})
+}
+
+func (w *envelopeWriter) HistoryPage(page *services.HistoryPage) error {
+	return w.write(envelopeHistoryPage, "", 0, gin.H{
+		"messages": newMessageViews(page.Messages),
+		"cursor":   page.NextCursor,
+		"has_more": page.HasMore,
+	})
+}
+
+func (w *envelopeWriter) Queued(seq int64, messageID string, position int) error {
+	return w.write(envelopeEvent, messageID, seq, gin.H{"name": "queued", "position": position})
+}
+
+func (w *envelopeWriter) Started(seq int64, messageID string) error {
+	return w.write(envelopeEvent, messageID, seq, gin.H{"name": "started"})
+}
+
+func (w *envelopeWriter) MessageStart(seq int64, msg models.Message) error {
+	return w.write(envelopeMessageStart, msg.MessageID, seq, gin.H{
+		"parent_message_id": msg.ParentMessageID,
+		"version_index":     msg.VersionIndex,
+		"question":          msg.Question,
+		"attachments":       msg.Attachments,
+		"metadata":          msg.Metadata,
+	})
+}
+
+func (w *envelopeWriter) Delta(seq int64, messageID, content string) error {
+	return w.write(envelopeDelta, messageID, seq, gin.H{"content": content})
+}
+
+func (w *envelopeWriter) ToolInvocation(seq int64, messageID string, invocation models.ToolInvocation) error {
+	return w.write(envelopeEvent, messageID, seq, gin.H{"name": "tool_invocation", "invocation": invocation})
+}
+
+func (w *envelopeWriter) MessageEnd(seq int64, msg models.Message) error {
+	return w.write(envelopeMessageEnd, msg.MessageID, seq, gin.H{"status": msg.Status, "usage": msg.Usage, "message": newMessageView(msg)})
+}
+
+func (w *envelopeWriter) Error(messageID, code, message string) error {
+	return w.write(envelopeError, messageID, 0, gin.H{"code": code, "message": message, "retryable": false})
+}
+
+func (w *envelopeWriter) LLMError(seq int64, messageID string, llmErr *services.LLMError) error {
+	return w.write(envelopeError, messageID, seq, llmErrorData(llmErr))
+}
+
+func (w *envelopeWriter) Event(name string, data gin.H) error {
+	payload := gin.H{"name": name}
+	for key, value := range data {
+		payload[key] = value
+	}
+	return w.write(envelopeEvent, "", 0, payload)
+}
+
+// legacyWriter keeps the original protocol for clients that negotiate no subprotocol: the whole history
+// as marshalled messages, answers as raw text fragments and failures as a plain "Error: ..." text frame.
+// Everything the original protocol did not send is dropped, so legacy clients see the same byte stream.
+type legacyWriter struct {
+	conn timedConn
+}
+
+// History sends the messages one per frame
+func (w *legacyWriter) History(page *services.HistoryPage, activeMessageID string) error {
+	for _, msg := range page.Messages {
+		msgBytes, err := json.Marshal(msg)
+		if err != nil {
+			continue
+		}
+		if err := w.conn.WriteMessage(websocket.TextMessage, msgBytes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// HistoryPage is not part of the legacy protocol, legacy clients get the whole history on connect
+func (w *legacyWriter) HistoryPage(page *services.HistoryPage) error {
+	return nil
+}
+
+// Queued is not part of the legacy protocol
+func (w *legacyWriter) Queued(seq int64, messageID string, position int) error {
+	return nil
+}
+
+// Started is not part of the legacy protocol
+func (w *legacyWriter) Started(seq int64, messageID string) error {
+	return nil
+}
+
+// MessageStart is not part of the legacy protocol
+func (w *legacyWriter) MessageStart(seq int64, msg models.Message) error {
+	return nil
+}
+
+func (w *legacyWriter) Delta(seq int64, messageID, content string) error {
+	return w.conn.WriteMessage(websocket.TextMessage, []byte(content))
+}
+
+// ToolInvocation is not part of the legacy protocol, invocations are only stored
+func (w *legacyWriter) ToolInvocation(seq int64, messageID string, invocation models.ToolInvocation) error {
+	return nil
+}
+
+// MessageEnd is not part of the legacy protocol, the end of an answer is not announced
+func (w *legacyWriter) MessageEnd(seq int64, msg models.Message) error {
+	return nil
+}
+
+// Error is sent as text the way the original protocol reported failures
+func (w *legacyWriter) Error(messageID, code, message string) error {
+	return w.conn.WriteMessage(websocket.TextMessage, []byte("Error: "+message))
+}
+
+func (w *legacyWriter) LLMError(seq int64, messageID string, llmErr *services.LLMError) error {
+	return w.Error(messageID, llmErr.Code, llmErr.Message)
+}
+
+// Event is not part of the legacy protocol
+func (w *legacyWriter) Event(name string, data gin.H) error {
+	return nil
+}
+
+// llmErrorData describes a typed LLM error for the client
+func llmErrorData(llmErr *services.LLMError) gin.H {
+	data := gin.H{
+		"code":      llmErr.Code,
+		"message":   llmErr.Message,
+		"retryable": llmErr.Retryable,
+	}
+	if llmErr.RetryAfter > 0 {
+		data["retry_after"] = int(llmErr.RetryAfter.Seconds() + 0.5)
+	}
+	return data
+}
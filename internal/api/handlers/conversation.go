@@ -3,9 +3,15 @@
 package handlers
 
 import (
+	"chat-ai-backend/internal/models"
+	"chat-ai-backend/internal/repositories"
 	"chat-ai-backend/internal/services"
 	"chat-ai-backend/utils"
+	"errors"
+	"fmt"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
@@ -18,6 +24,129 @@ func NewConversationHandler(service *services.ConversationService) *Conversation
 	return &ConversationHandler{ConversationService: service}
 }
 
+// conversationView is a conversation as the conversation list shows it
+type conversationView struct {
+	ID              string     `json:"id"`
+	Title           string     `json:"title"`
+	Tags            []string   `json:"tags"`
+	Pinned          bool       `json:"pinned"`
+	Archived        bool       `json:"archived"`
+	MessageCount    int        `json:"message_count"`
+	LastMessage     string     `json:"last_message_preview"`
+	LastMessageAt   *time.Time `json:"last_message_at"`
+	ActiveMessageID string     `json:"active_message_id,omitempty"`
+	CreatedAt       time.Time  `json:"created_at"`
+	UpdatedAt       *time.Time `json:"updated_at"`
+}
+
+func newConversationView(convo models.Conversation) conversationView {
+	tags := convo.Tags
+	if tags == nil {
+		tags = []string{}
+	}
+	return conversationView{
+		ID:              convo.ID,
+		Title:           convo.Title,
+		Tags:            tags,
+		Pinned:          convo.Pinned,
+		Archived:        convo.Archived,
+		MessageCount:    convo.MessageCount,
+		LastMessage:     convo.LastMessage,
+		LastMessageAt:   convo.LastMessageAt,
+		ActiveMessageID: convo.ActiveMessageID,
+		CreatedAt:       convo.CreatedAt,
+		UpdatedAt:       convo.UpdatedAt,
+	}
+}
+
+// ListConversationsHandler returns a page of the caller's conversations, newest updated first by default
+func (h *ConversationHandler) ListConversationsHandler(c *gin.Context) {
+	// Extract userID from context
+	userID, ok := utils.GetUserIDFromContext(c)
+	if !ok {
+		return // Response already written in util
+	}
+
+	query, err := parseConversationQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	page, err := h.ConversationService.ListConversations(userID, query)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidConversationQuery) || errors.Is(err, services.ErrInvalidCursor) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list conversations"})
+		return
+	}
+
+	views := make([]conversationView, 0, len(page.Conversations))
+	for _, convo := range page.Conversations {
+		views = append(views, newConversationView(convo))
+	}
+	c.JSON(http.StatusOK, gin.H{"conversations": views, "next_cursor": page.NextCursor, "has_more": page.HasMore})
+}
+
+// parseConversationQuery reads the sort order, filters and page of the conversation list
+func parseConversationQuery(c *gin.Context) (services.ConversationQuery, error) {
+	query := services.ConversationQuery{
+		Sort:   c.Query("sort"),
+		Cursor: c.Query("cursor"),
+		Filter: repositories.ConversationFilter{
+			Title: c.Query("q"),
+			Tag:   c.Query("tag"),
+		},
+	}
+
+	switch c.DefaultQuery("order", "desc") {
+	case "desc":
+	case "asc":
+		query.Ascending = true
+	default:
+		return query, errors.New("order must be asc or desc")
+	}
+
+	if raw := c.Query("limit"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit <= 0 || limit > services.MaxConversationPageSize {
+			return query, fmt.Errorf("limit must be between 1 and %d", services.MaxConversationPageSize)
+		}
+		query.Limit = limit
+	}
+
+	// Archived conversations are hidden unless asked for
+	switch raw := c.DefaultQuery("archived", "false"); raw {
+	case "any":
+	case "true", "false":
+		archived := raw == "true"
+		query.Filter.Archived = &archived
+	default:
+		return query, errors.New("archived must be true, false or any")
+	}
+
+	if raw := c.Query("pinned"); raw != "" {
+		pinned, err := strconv.ParseBool(raw)
+		if err != nil {
+			return query, errors.New("pinned must be true or false")
+		}
+		query.Filter.Pinned = &pinned
+	}
+
+	for name, target := range map[string]**time.Time{"from": &query.Filter.From, "to": &query.Filter.To} {
+		if raw := c.Query(name); raw != "" {
+			at, err := time.Parse(time.RFC3339, raw)
+			if err != nil {
+				return query, fmt.Errorf("%s must be an RFC 3339 time", name)
+			}
+			*target = &at
+		}
+	}
+	return query, nil
+}
+
 // Create a new conversation
 func (h *ConversationHandler) CreateConversationHandler(c *gin.Context) {
 	// Extract userID from context
@@ -40,7 +169,7 @@ func (h *ConversationHandler) CreateConversationHandler(c *gin.Context) {
 	// Set default title if not provided
 	title := input.Title
 	if title == "" {
-		title = "New Conversation"
+		title = services.DefaultConversationTitle
 	}
 
 	conversationID, err := h.ConversationService.CreateOrFetchConversation(userID, title)
@@ -55,7 +184,7 @@ func (h *ConversationHandler) CreateConversationHandler(c *gin.Context) {
 // DeleteConversationHandler handles the deletion of a conversation
 func (h *ConversationHandler) DeleteConversationHandler(c *gin.Context) {
 	// Extract userID from context
-	_, ok := utils.GetUserIDFromContext(c)
+	userID, ok := utils.GetUserIDFromContext(c)
 	if !ok {
 		return // Response already written in util
 	}
@@ -67,8 +196,12 @@ func (h *ConversationHandler) DeleteConversationHandler(c *gin.Context) {
 		return
 	}
 
-	err := h.ConversationService.DeleteConversation(conversationID)
+	err := h.ConversationService.DeleteConversation(userID, conversationID)
 	if err != nil {
+		if errors.Is(err, repositories.ErrConversationNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Conversation not found"})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -76,9 +209,10 @@ func (h *ConversationHandler) DeleteConversationHandler(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "Conversation and associated messages deleted successfully"})
 }
 
+// UpdateConversationHandler changes the title, tags, pinned or archived state of a conversation
 func (h *ConversationHandler) UpdateConversationHandler(c *gin.Context) {
 	// Extract userID from context
-	_, ok := utils.GetUserIDFromContext(c)
+	userID, ok := utils.GetUserIDFromContext(c)
 	if !ok {
 		return // Response already written in util
 	}
@@ -88,20 +222,134 @@ func (h *ConversationHandler) UpdateConversationHandler(c *gin.Context) {
 
 	// Parse JSON body
 	var input struct {
-		Title string `json:"title" binding:"required"`
+		Title    *string   `json:"title"`
+		Tags     *[]string `json:"tags"`
+		Pinned   *bool     `json:"pinned"`
+		Archived *bool     `json:"archived"`
 	}
 
 	if err := c.ShouldBindJSON(&input); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if input.Title == nil && input.Tags == nil && input.Pinned == nil && input.Archived == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Nothing to update"})
+		return
+	}
+	if input.Title != nil && *input.Title == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Title must not be empty"})
+		return
+	}
+
+	if input.Tags != nil || input.Pinned != nil || input.Archived != nil {
+		err := h.ConversationService.UpdateConversationOrganization(userID, conversationID, services.ConversationUpdate{
+			Tags:     input.Tags,
+			Pinned:   input.Pinned,
+			Archived: input.Archived,
+		})
+		if err != nil {
+			switch {
+			case errors.Is(err, services.ErrInvalidConversationUpdate):
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			case errors.Is(err, repositories.ErrConversationNotFound):
+				c.JSON(http.StatusNotFound, gin.H{"error": "Conversation not found"})
+			default:
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			}
+			return
+		}
+	}
 
 	// Call the service to update the title
-	err := h.ConversationService.UpdateConversationTitle(conversationID, input.Title)
+	if input.Title != nil {
+		err := h.ConversationService.UpdateConversationTitle(userID, conversationID, *input.Title)
+		if err != nil {
+			if errors.Is(err, repositories.ErrConversationNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Conversation not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Conversation updated successfully"})
+}
+
+// GetConversationSettingsHandler returns the model settings of a conversation
+func (h *ConversationHandler) GetConversationSettingsHandler(c *gin.Context) {
+	// Extract userID from context
+	userID, ok := utils.GetUserIDFromContext(c)
+	if !ok {
+		return // Response already written in util
+	}
+
+	conversationID := c.Param("id")
+
+	settings, err := h.ConversationService.GetConversationSettings(userID, conversationID)
 	if err != nil {
+		if errors.Is(err, repositories.ErrConversationNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Conversation not found"})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Conversation updated successfully"})
+	c.JSON(http.StatusOK, gin.H{"settings": settings})
+}
+
+// UpdateConversationSettingsHandler replaces the model settings of a conversation
+func (h *ConversationHandler) UpdateConversationSettingsHandler(c *gin.Context) {
+	// Extract userID from context
+	userID, ok := utils.GetUserIDFromContext(c)
+	if !ok {
+		return // Response already written in util
+	}
+
+	conversationID := c.Param("id")
+
+	var input models.ConversationSettings
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err := h.ConversationService.UpdateConversationSettings(userID, conversationID, input)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrInvalidSettings):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.Is(err, repositories.ErrConversationNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "Conversation not found"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Conversation settings updated successfully", "settings": input})
+}
+
+// GetConversationSummaryHandler returns the rolling summary of a conversation
+func (h *ConversationHandler) GetConversationSummaryHandler(c *gin.Context) {
+	// Extract userID from context
+	userID, ok := utils.GetUserIDFromContext(c)
+	if !ok {
+		return // Response already written in util
+	}
+
+	conversationID := c.Param("id")
+
+	summary, err := h.ConversationService.GetConversationSummary(userID, conversationID)
+	if err != nil {
+		if errors.Is(err, repositories.ErrConversationNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Conversation not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"summary": summary})
 }
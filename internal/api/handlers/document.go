@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"chat-ai-backend/config"
+	"chat-ai-backend/internal/repositories"
+	"chat-ai-backend/internal/services"
+	"chat-ai-backend/utils"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+
+	"github.com/gin-gonic/gin"
+)
+
+type DocumentHandler struct {
+	DocumentService *services.DocumentService
+}
+
+func NewDocumentHandler(service *services.DocumentService) *DocumentHandler {
+	return &DocumentHandler{DocumentService: service}
+}
+
+// UploadDocument ingests a text, Markdown or HTML file sent as the multipart field "file"
+func (h *DocumentHandler) UploadDocument(c *gin.Context) {
+	// Extract userID from context
+	userID, ok := utils.GetUserIDFromContext(c)
+	if !ok {
+		return // Response already written in util
+	}
+
+	maxBytes := config.AppConfig.DocumentMaxBytes
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxBytes+1<<20) // room for the multipart framing
+
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing file"})
+		return
+	}
+	if fileHeader.Size > maxBytes {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("File is larger than %d bytes", maxBytes)})
+		return
+	}
+
+	contentType, err := services.DetectContentType(fileHeader.Filename, fileHeader.Header.Get("Content-Type"))
+	if err != nil {
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": err.Error()})
+		return
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read file"})
+		return
+	}
+	defer file.Close()
+	data, err := io.ReadAll(io.LimitReader(file, maxBytes))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read file"})
+		return
+	}
+
+	doc, err := h.DocumentService.IngestDocument(c.Request.Context(), userID, filepath.Base(fileHeader.Filename), contentType, data)
+	if err != nil {
+		utils.Logger.Error("Failed to ingest document for user %s: %v", userID, err)
+		var llmErr *services.LLMError
+		switch {
+		case errors.Is(err, services.ErrEmptyDocument), errors.Is(err, services.ErrUnsupportedDocument):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.Is(err, services.ErrEmbeddingsNotSupported):
+			c.JSON(http.StatusNotImplemented, gin.H{"error": err.Error()})
+		case errors.As(err, &llmErr):
+			c.JSON(http.StatusBadGateway, gin.H{"error": llmErr.Message, "code": llmErr.Code})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to ingest document"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"document": doc})
+}
+
+// ListDocuments returns the caller's documents
+func (h *DocumentHandler) ListDocuments(c *gin.Context) {
+	// Extract userID from context
+	userID, ok := utils.GetUserIDFromContext(c)
+	if !ok {
+		return // Response already written in util
+	}
+
+	documents, err := h.DocumentService.ListDocuments(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list documents"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"documents": documents})
+}
+
+// DeleteDocument removes a document and its chunks
+func (h *DocumentHandler) DeleteDocument(c *gin.Context) {
+	// Extract userID from context
+	userID, ok := utils.GetUserIDFromContext(c)
+	if !ok {
+		return // Response already written in util
+	}
+
+	err := h.DocumentService.DeleteDocument(c.Request.Context(), userID, c.Param("id"))
+	if err != nil {
+		if errors.Is(err, repositories.ErrDocumentNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Document not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete document"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Document deleted successfully"})
+}
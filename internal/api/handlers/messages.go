@@ -3,34 +3,68 @@
 package handlers
 
 import (
+	"chat-ai-backend/config"
+	"chat-ai-backend/internal/models"
+	"chat-ai-backend/internal/repositories"
 	"chat-ai-backend/internal/services"
 	"chat-ai-backend/utils"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
 )
 
 var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool {
+	CheckOrigin:  checkOrigin,
+	Subprotocols: []string{ChatProtocolV1}, // Clients that offer none get the legacy protocol
+}
+
+// checkOrigin accepts browsers on an origin allowed by the CORS config, and clients such as mobile
+// apps that send no Origin header at all
+func checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
 		return true
-	},
+	}
+	for _, allowed := range config.AppConfig.AllowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+	utils.Logger.Warn("WebSocket handshake from disallowed origin %s", origin)
+	return false
 }
 
 type MessageHandler struct {
-	MessageService      *services.MessageService
-	RedisMessageService *services.RedisMessageService
-	ConversationService *services.ConversationService
-	OpenAIService       *services.OpenAIService
+	MessageService       *services.MessageService
+	RedisMessageService  *services.RedisMessageService
+	ConversationService  *services.ConversationService
+	ChatService          *services.ChatService
+	AnswerStreamService  *services.AnswerStreamService
+	ConnectionService    *services.ConnectionService
+	EventService         *services.ConversationEventService
+	AuthorizationService *services.AuthorizationService
 }
 
-func NewMessageHandler(messageSvc *services.MessageService, convoSvc *services.ConversationService, redisMsgSvc *services.RedisMessageService, mainLLMSvc *services.OpenAIService) *MessageHandler {
+func NewMessageHandler(messageSvc *services.MessageService, convoSvc *services.ConversationService, redisMsgSvc *services.RedisMessageService, chatSvc *services.ChatService, answerStreamSvc *services.AnswerStreamService, connectionSvc *services.ConnectionService, eventSvc *services.ConversationEventService, authorizationSvc *services.AuthorizationService) *MessageHandler {
 	return &MessageHandler{
-		MessageService:      messageSvc,
-		RedisMessageService: redisMsgSvc,
-		ConversationService: convoSvc,
-		OpenAIService:       mainLLMSvc,
+		MessageService:       messageSvc,
+		RedisMessageService:  redisMsgSvc,
+		ConversationService:  convoSvc,
+		ChatService:          chatSvc,
+		AnswerStreamService:  answerStreamSvc,
+		ConnectionService:    connectionSvc,
+		EventService:         eventSvc,
+		AuthorizationService: authorizationSvc,
 	}
 }
 
@@ -41,99 +75,835 @@ func (h *MessageHandler) Messages(c *gin.Context) {
 		return // Response already written in util
 	}
 
-	// Upgrade to WebSocket
-	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
-	if err != nil {
-		utils.Logger.Error("WebSocket upgrade failed: %v\n", err)
+	// Clients that do not speak the envelope protocol are only served while the legacy protocol is enabled
+	if !config.AppConfig.WSLegacyProtocol && !offersSubprotocol(c.Request, ChatProtocolV1) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "WebSocket subprotocol " + ChatProtocolV1 + " required"})
 		return
 	}
-	defer conn.Close()
-	utils.Logger.Info("User %s connected", userID)
 
-	// Create or fetch the active conversation
+	// A conversation to continue must be the user's, checked before the upgrade so the status reaches the client
 	conversationID := c.Query("conversationID")
+	if conversationID != "" {
+		if _, err := h.AuthorizationService.AuthorizeConversation(userID, conversationID); err != nil {
+			if errors.Is(err, repositories.ErrConversationNotFound) {
+				utils.Logger.Warn("User %s denied access to conversation %s", userID, conversationID)
+				c.JSON(http.StatusNotFound, gin.H{"error": "Conversation not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load conversation"})
+			return
+		}
+	}
+
+	// Cap the concurrent connections of the user and of the whole server
+	connectionID, err := h.ConnectionService.Acquire(userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, repositories.ErrUserConnectionLimit):
+			utils.Logger.Warn("User %s is at the connection limit", userID)
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many open connections"})
+		case errors.Is(err, repositories.ErrConnectionLimit):
+			utils.Logger.Warn("Server is at the connection limit, refusing user %s", userID)
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Server is at its connection limit"})
+		default:
+			utils.Logger.Error("Failed to register connection of user %s: %v", userID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to open connection"})
+		}
+		return
+	}
+	defer h.ConnectionService.Release(userID, connectionID)
+
+	// Everything that can still fail with an HTTP status happens before the upgrade
 	if conversationID == "" {
 		// If no conversationID is provided, create a new conversation
 
-		conversationID, err = h.ConversationService.CreateOrFetchConversation(userID, "New Conversation")
+		conversationID, err = h.ConversationService.CreateOrFetchConversation(userID, services.DefaultConversationTitle)
 		if err != nil {
 			utils.Logger.Error("Failed to create conversation for user %s: %v\n", userID, err)
-			c.JSON(500, gin.H{"error": "Failed to create conversation"})
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create conversation"})
 			return
 		}
 		utils.Logger.Info("New conversation %s created for user %s", conversationID, userID)
 	} else {
-		// Validate if the provided conversationID belongs to the user
-		valid, err := h.MessageService.ValidateConversationOwnership(userID, conversationID)
-		if err != nil || !valid {
-			utils.Logger.Error("Invalid or unauthorized conversationID: %s for user %s", conversationID, userID)
-			c.JSON(403, gin.H{"error": "Unauthorized access to conversation"})
+		utils.Logger.Warn("Existing conversation %s accessed by user %s", conversationID, userID)
+	}
+
+	// Events of the conversation from every device and replica, subscribed before the history is read
+	// so no answer started in between is missed
+	events, unsubscribe := h.EventService.Subscribe(conversationID)
+	defer unsubscribe()
+
+	// Load the newest messages into Redis from MongoDB
+	activeMessageID := ""
+	convo, err := h.ConversationService.GetConversation(userID, conversationID)
+	if err == nil {
+		tree, err := h.RedisMessageService.LoadMessageTree(conversationID, convo.ActiveMessageID)
+		if err != nil {
+			utils.Logger.Error("Failed to load messages into Redis: %v\n", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load messages"})
 			return
 		}
-		utils.Logger.Warn("Existing conversation %s accessed by user %s", conversationID, userID)
+		activeMessageID = tree.ActiveLeaf(convo.ActiveMessageID)
 	}
 
-	// Load into Redis from MongoDB
-	messages, err := h.RedisMessageService.LoadMsgIntoRedis(conversationID)
+	// The newest page of previous messages is sent on connect; older ones are asked with load_more.
+	// Legacy clients cannot page and get the whole history as before.
+	page := &services.HistoryPage{}
+	if offersSubprotocol(c.Request, ChatProtocolV1) {
+		page, err = h.RedisMessageService.GetHistoryPage(conversationID, "", 0)
+	} else {
+		page.Messages, err = h.RedisMessageService.GetFullHistory(conversationID)
+	}
+	if err != nil {
+		utils.Logger.Error("Failed to read history of conversation %s: %v", conversationID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load messages"})
+		return
+	}
+
+	// Upgrade to WebSocket
+	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
 	if err != nil {
-		utils.Logger.Error("Failed to load messages into Redis: %v\n", err)
-		c.JSON(500, gin.H{"error": "Failed to load messages"})
+		utils.Logger.Error("WebSocket upgrade failed: %v\n", err)
+		// The history was loaded into Redis for this connection
+		h.releaseConversation(conversationID)
 		return
 	}
+	h.ConnectionService.Join(conversationID, connectionID)
+	defer conn.Close()
+	conn.SetReadLimit(config.AppConfig.WSMaxMessageBytes)
+	utils.Logger.Info("User %s connected", userID)
+
+	writer := newChatWriter(conn, conversationID)
+	if err := writer.History(page, activeMessageID); err != nil {
+		utils.Logger.Error("Error sending message to client: %v\n", err)
+		return
+	}
+
+	// Schedule the deletion of messages from Redis
+	h.RedisMessageService.ScheduleRedisToMongoMigration(conversationID)
+
+	// Move data from redis to MongDB if connection close, or once an answer still being generated is stored
+	state := &connectionState{}
+	defer func() {
+		h.ConnectionService.Leave(conversationID, connectionID)
+		if state.close() {
+			h.releaseConversation(conversationID)
+		}
+	}()
+
+	// Read frames in the background so a stop frame can arrive while an answer is streaming
+	incoming := make(chan []byte)
+	done := make(chan struct{})
+	defer close(done)
+	var lastFrame atomic.Int64
+	lastFrame.Store(time.Now().UnixNano())
+	go readClientFrames(conn, incoming, done, &lastFrame)
+	go h.keepAlive(conn, userID, conversationID, connectionID, state, &lastFrame, done)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	session := &chatSession{
+		ctx:            ctx,
+		writer:         writer,
+		conversationID: conversationID,
+		connectionID:   connectionID,
+		incoming:       incoming,
+		events:         events,
+		remote:         make(chan remoteChunk),
+		strict:         conn.Subprotocol() == ChatProtocolV1,
+	}
 
-	// Send previous messages to the WebSocket client as JSON
-	for _, msg := range messages {
-		msgBytes, err := json.Marshal(msg)
+	// A reconnecting client picks up an answer where its previous connection left off
+	if resumeID := c.Query("resume"); resumeID != "" {
+		after, err := strconv.ParseInt(c.Query("after"), 10, 64)
+		if err != nil || after < 0 {
+			after = 0 // Replay the whole answer
+		}
+		if _, err := h.AuthorizationService.AuthorizeAnswer(context.Background(), userID, conversationID, resumeID); err != nil {
+			utils.Logger.Warn("User %s cannot resume answer %s: %v", userID, resumeID, err)
+			writeFrameErr(writer.Error(resumeID, "resume_unavailable", "Answer can no longer be resumed"))
+		} else if _, clientGone := h.followAnswer(session, resumeID, after); clientGone {
+			return
+		}
+	}
+
+frames:
+	for {
+		var message []byte
+		if len(session.pending) > 0 {
+			message, session.pending = session.pending[0], session.pending[1:]
+		} else {
+			select {
+			case frame, ok := <-incoming:
+				if !ok {
+					break frames
+				}
+				message = frame
+			case event := <-session.events:
+				h.handleConversationEvent(session, event)
+				continue
+			case remote := <-session.remote:
+				writeFrameErr(writeAnswerChunk(writer, remote.messageID, remote.chunk))
+				continue
+			}
+		}
+
+		frame, err := parseClientFrame(message, session.strict)
 		if err != nil {
-			utils.Logger.Error("Error marshalling message: %v\n", err)
+			writeFrameErr(writer.Error("", "invalid_frame", err.Error()))
+			continue
+		}
+		if frame.Type == frameStop {
+			// A stop frame with nothing in flight has nothing to cancel
+			continue
+		}
+		if frame.Type == frameLoadMore {
+			h.loadMore(session, frame)
+			continue
+		}
+
+		utils.Logger.Info("Message from user %s: %s", userID, message)
+
+		if frame.Type == frameSelectBranch {
+			leafID, err := h.ChatService.SelectBranch(conversationID, frame.MessageID)
+			if err != nil {
+				writeChatError(writer, err)
+				continue
+			}
+			writeFrameErr(writer.Event("branch_selected", gin.H{"message_id": leafID}))
 			continue
 		}
 
-		err = conn.WriteMessage(websocket.TextMessage, msgBytes)
+		// The answer is generated in the background and stored even if the client leaves before it ends
+		state.startAnswer()
+		stored, err := h.ChatService.Start(userID, conversationID, services.ChatTurnRequest{
+			Type:          frame.Type,
+			MessageID:     frame.MessageID,
+			Content:       frame.Content,
+			AttachmentIDs: frame.AttachmentIDs,
+			Metadata:      frame.Metadata,
+			Origin:        connectionID,
+		}, services.ChatHooks{
+			Done: func() {
+				if state.endAnswer() {
+					h.releaseConversation(conversationID)
+				}
+			},
+		})
 		if err != nil {
-			utils.Logger.Error("Error sending message to client: %v\n", err)
+			state.endAnswer()
+			writeChatError(writer, err)
+			continue
+		}
+
+		// Stream the answer to the WebSocket, queued first if another answer is in progress, until it
+		// ends or the client leaves
+		final, clientGone := h.followAnswer(session, stored.MessageID, 0)
+		if clientGone {
 			return
 		}
+		if final != nil && (frame.Type == frameRegenerate || frame.Type == frameEdit) {
+			writeFrameErr(writer.Event("branch_created", gin.H{
+				"message_id":        final.MessageID,
+				"parent_message_id": final.ParentMessageID,
+				"version_index":     final.VersionIndex,
+			}))
+		}
 	}
+}
 
-	// Schedule the deletion of messages from Redis
-	h.RedisMessageService.ScheduleRedisToMongoMigration(conversationID)
+// chatSession is the state of one client connection, used by its connection loop only
+type chatSession struct {
+	ctx            context.Context // Ends with the connection
+	writer         chatWriter
+	conversationID string
+	connectionID   string
+	incoming       <-chan []byte
+	events         <-chan services.ConversationEvent // Events of the conversation from every device and replica
+	remote         chan remoteChunk                  // Answers asked on other devices
+	strict         bool                              // The envelope protocol only accepts JSON frames; legacy clients may send plain text questions
+	pending        [][]byte                          // Frames received while an answer streamed, handled in order afterwards
+}
 
-	// Move data from redis to MongDB if connection close
-	defer func() {
-		err := h.RedisMessageService.MoveConversationToMongo(conversationID)
+// remoteChunk is a chunk of an answer asked on another device
+type remoteChunk struct {
+	messageID string
+	chunk     services.AnswerChunk
+}
+
+// followAnswer writes the chunks of an answer after seq after until its end. Frames arriving meanwhile
+// are queued in pending, except stop which stops the answer or withdraws a queued question and load_more
+// which is answered right away. clientGone reports a closed connection;
+// the answer is then finished and stored without a listener.
+func (h *MessageHandler) followAnswer(session *chatSession, messageID string, after int64) (final *models.Message, clientGone bool) {
+	ctx, cancel := context.WithCancel(session.ctx)
+	defer cancel()
+	chunks := h.AnswerStreamService.Follow(ctx, messageID, after)
+
+	// After a failed write the socket is broken and the reader is about to close incoming
+	writeFailed := false
+	write := func(err error) {
 		if err != nil {
-			utils.Logger.Error("Failed to move conversation %s to MongoDB: %v", conversationID, err)
+			utils.Logger.Error("Error writing message: %v\n", err)
+			writeFailed = true
 		}
-	}()
+	}
 
 	for {
-		// Read message from client
-		_, message, err := conn.ReadMessage()
+		select {
+		case chunk, ok := <-chunks:
+			if !ok {
+				if !writeFailed {
+					writeFrameErr(session.writer.Error(messageID, "stream_unavailable", "Answer stream is no longer available"))
+				}
+				return nil, false
+			}
+			if !writeFailed {
+				write(writeAnswerChunk(session.writer, messageID, chunk))
+			}
+			if chunk.Type == services.AnswerChunkEnd {
+				return chunk.Message, false
+			}
+			if chunk.Type == services.AnswerChunkRejected {
+				return nil, false
+			}
+		case frame, ok := <-session.incoming:
+			if !ok {
+				return nil, true
+			}
+			next, err := parseClientFrame(frame, session.strict)
+			if err == nil && next.Type == frameStop {
+				utils.Logger.Info("Answer %s stopped by the client", messageID)
+				h.AnswerStreamService.Stop(messageID)
+				continue
+			}
+			if err == nil && next.Type == frameLoadMore {
+				if !writeFailed {
+					h.loadMore(session, next)
+				}
+				continue
+			}
+			// Frames sent while streaming are handled afterwards, in order
+			session.pending = append(session.pending, frame)
+		case event := <-session.events:
+			if !writeFailed {
+				h.handleConversationEvent(session, event)
+			}
+		case remote := <-session.remote:
+			if !writeFailed {
+				write(writeAnswerChunk(session.writer, remote.messageID, remote.chunk))
+			}
+		}
+	}
+}
+
+// loadMore sends the page of history before the frame's cursor
+func (h *MessageHandler) loadMore(session *chatSession, frame clientFrame) {
+	page, err := h.RedisMessageService.GetHistoryPage(session.conversationID, frame.Cursor, frame.Limit)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidCursor) {
+			writeFrameErr(session.writer.Error("", "invalid_cursor", err.Error()))
+			return
+		}
+		writeFrameErr(session.writer.Error("", "history_unavailable", "Failed to load messages"))
+		return
+	}
+	writeFrameErr(session.writer.HistoryPage(page))
+}
+
+// writeAnswerChunk writes one chunk of an answer stream to the client
+func writeAnswerChunk(writer chatWriter, messageID string, chunk services.AnswerChunk) error {
+	switch chunk.Type {
+	case services.AnswerChunkQueued:
+		return writer.Queued(chunk.Seq, messageID, *chunk.Position)
+	case services.AnswerChunkStarted:
+		return writer.Started(chunk.Seq, messageID)
+	case services.AnswerChunkRejected:
+		return writer.Error(messageID, chunk.Error.Code, chunk.Error.Message)
+	case services.AnswerChunkStart:
+		return writer.MessageStart(chunk.Seq, *chunk.Message)
+	case services.AnswerChunkDelta:
+		return writer.Delta(chunk.Seq, messageID, chunk.Content)
+	case services.AnswerChunkToolInvocation:
+		return writer.ToolInvocation(chunk.Seq, messageID, *chunk.Invocation)
+	case services.AnswerChunkError:
+		return writer.LLMError(chunk.Seq, messageID, chunk.LLMError())
+	case services.AnswerChunkEnd:
+		return writer.MessageEnd(chunk.Seq, *chunk.Message)
+	}
+	return nil
+}
+
+// handleConversationEvent shows the client what happened on the conversation's other devices
+func (h *MessageHandler) handleConversationEvent(session *chatSession, event services.ConversationEvent) {
+	switch event.Type {
+	case services.ConversationEventAnswerStarted:
+		// The asking connection follows its answer itself; legacy frames cannot tell two answers apart
+		if event.Origin == session.connectionID || !session.strict {
+			return
+		}
+		go h.forwardRemoteAnswer(session, event.MessageID)
+	default:
+		writeFrameErr(session.writer.Event(event.Type, gin.H(event.Data)))
+	}
+}
+
+// forwardRemoteAnswer hands the chunks of an answer asked on another device to the connection loop
+func (h *MessageHandler) forwardRemoteAnswer(session *chatSession, messageID string) {
+	for chunk := range h.AnswerStreamService.Follow(session.ctx, messageID, 0) {
+		select {
+		case session.remote <- remoteChunk{messageID: messageID, chunk: chunk}:
+		case <-session.ctx.Done():
+			return
+		}
+	}
+}
+
+// keepAlive pings the client and keeps the connection counted. A client that sent no frame for the
+// idle timeout is disconnected, unless an answer is still streaming to it.
+func (h *MessageHandler) keepAlive(conn *websocket.Conn, userID, conversationID, connectionID string, state *connectionState, lastFrame *atomic.Int64, done <-chan struct{}) {
+	ticker := time.NewTicker(config.AppConfig.WSPingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			deadline := time.Now().Add(config.AppConfig.WSWriteTimeout)
+			idle := time.Since(time.Unix(0, lastFrame.Load()))
+			if config.AppConfig.WSIdleTimeout > 0 && idle > config.AppConfig.WSIdleTimeout && !state.busy() {
+				utils.Logger.Info("Closing connection of user %s after %s idle", userID, idle.Round(time.Second))
+				conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, "idle timeout"), deadline)
+				conn.Close() // Unblocks the reader, which ends the connection loop
+				return
+			}
+			// Control frames may be written concurrently with the connection loop's writes
+			if err := conn.WriteControl(websocket.PingMessage, nil, deadline); err != nil {
+				utils.Logger.Warn("Failed to ping user %s: %v", userID, err)
+				return
+			}
+			h.ConnectionService.Refresh(userID, conversationID, connectionID)
+		}
+	}
+}
+
+// moveConversationToMongo flushes the Redis cache of a conversation to MongoDB
+func (h *MessageHandler) moveConversationToMongo(conversationID string) {
+	if err := h.RedisMessageService.MoveConversationToMongo(conversationID); err != nil {
+		utils.Logger.Error("Failed to move conversation %s to MongoDB: %v", conversationID, err)
+	}
+}
+
+// releaseConversation flushes the conversation to MongoDB once no connection relies on its Redis cache
+func (h *MessageHandler) releaseConversation(conversationID string) {
+	if h.ConnectionService.Connected(conversationID) {
+		utils.Logger.Info("Conversation %s is still open on another connection, keeping it in Redis", conversationID)
+		return
+	}
+	h.moveConversationToMongo(conversationID)
+}
+
+// connectionState decides who flushes the conversation to MongoDB: the closing connection, or the
+// answer still being generated when it closed, so the flush never runs before the answer is stored
+type connectionState struct {
+	mu        sync.Mutex
+	closed    bool
+	answering bool
+}
+
+func (s *connectionState) startAnswer() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.answering = true
+}
+
+// endAnswer reports whether the connection closed while answering, leaving the flush to the answer
+func (s *connectionState) endAnswer() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.answering = false
+	return s.closed
+}
+
+// busy reports whether an answer of this connection is being generated
+func (s *connectionState) busy() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.answering
+}
+
+// close reports whether the connection may flush now
+func (s *connectionState) close() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	return !s.answering
+}
+
+// GetMessages returns a page of a conversation's messages across all branches, newest first or with
+// ?order=asc oldest first; ?cursor= continues after the previous page and ?limit= sets the page size.
+// ?fields= limits the fields of each message. Messages not yet flushed from Redis are included and
+// nothing is loaded into it, so read-only tools need no WebSocket.
+func (h *MessageHandler) GetMessages(c *gin.Context) {
+	// Extract userID from context
+	userID, ok := utils.GetUserIDFromContext(c)
+	if !ok {
+		return // Response already written in util
+	}
+
+	conversationID := c.Param("id")
+
+	if _, err := h.ConversationService.GetConversation(userID, conversationID); err != nil {
+		if errors.Is(err, repositories.ErrConversationNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Conversation not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ascending := false
+	switch c.DefaultQuery("order", "desc") {
+	case "desc":
+	case "asc":
+		ascending = true
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "order must be asc or desc"})
+		return
+	}
+
+	limit := 0
+	if raw := c.Query("limit"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed <= 0 || parsed > config.MaxHistoryPageSize {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("limit must be between 1 and %d", config.MaxHistoryPageSize)})
+			return
+		}
+		limit = parsed
+	}
+
+	var fields []string
+	if raw := c.Query("fields"); raw != "" {
+		for _, field := range strings.Split(raw, ",") {
+			field = strings.TrimSpace(field)
+			if !messageViewFields[field] {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("unknown field %q", field)})
+				return
+			}
+			fields = append(fields, field)
+		}
+	}
+
+	page, err := h.RedisMessageService.ListMessages(conversationID, c.Query("cursor"), ascending, limit)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidCursor) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load messages"})
+		return
+	}
+
+	views := newMessageViews(page.Messages)
+	if fields == nil {
+		c.JSON(http.StatusOK, gin.H{"messages": views, "next_cursor": page.NextCursor, "has_more": page.HasMore})
+		return
+	}
+	projected := make([]map[string]json.RawMessage, 0, len(views))
+	for _, view := range views {
+		item, err := projectMessageView(view, fields)
 		if err != nil {
-			utils.Logger.Error("Error reading message: %v\n", err)
-			break
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to encode messages"})
+			return
 		}
+		projected = append(projected, item)
+	}
+	c.JSON(http.StatusOK, gin.H{"messages": projected, "next_cursor": page.NextCursor, "has_more": page.HasMore})
+}
 
-		utils.Logger.Info("Message from user %s: %s", userID, message)
+// GetMessageTree returns the branches of a conversation, or only the active branch with ?view=path
+func (h *MessageHandler) GetMessageTree(c *gin.Context) {
+	// Extract userID from context
+	userID, ok := utils.GetUserIDFromContext(c)
+	if !ok {
+		return // Response already written in util
+	}
 
-		// Process the message and stream the response
-		responseChan := make(chan string)
-		go h.OpenAIService.GenerateAIResponse(string(message), conversationID, responseChan)
+	conversationID := c.Param("id")
 
-		// Stream the response to the WebSocket
-		var aiResponse string
-		for response := range responseChan {
-			err = conn.WriteMessage(websocket.TextMessage, []byte(response))
-			if err != nil {
-				utils.Logger.Error("Error writing message: %v\n", err)
-				break
+	convo, err := h.ConversationService.GetConversation(userID, conversationID)
+	if err != nil {
+		if errors.Is(err, repositories.ErrConversationNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Conversation not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	messages, err := h.RedisMessageService.GetConversationMessages(conversationID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load messages"})
+		return
+	}
+
+	tree := services.BuildMessageTree(messages)
+	activeID := tree.ActiveLeaf(convo.ActiveMessageID)
+
+	if c.Query("view") == "path" {
+		c.JSON(http.StatusOK, gin.H{"active_message_id": activeID, "messages": tree.PathNodes(activeID)})
+		return
+	}
+	roots := tree.Roots
+	if roots == nil {
+		roots = []*services.MessageNode{}
+	}
+	c.JSON(http.StatusOK, gin.H{"active_message_id": activeID, "roots": roots})
+}
+
+// postMessageRequest is the body of POST /conversations/:id/messages
+type postMessageRequest struct {
+	Type          string            `json:"type"` // question (default), regenerate or edit
+	MessageID     string            `json:"message_id"`
+	Content       string            `json:"content"`
+	AttachmentIDs []string          `json:"attachment_ids"`
+	Metadata      map[string]string `json:"metadata"`
+}
+
+// PostMessage asks a question over plain HTTP for clients that cannot use the WebSocket. With
+// Accept: text/event-stream the answer is streamed as Server-Sent Events carrying the envelopes of the
+// chat-ai.v1 protocol, otherwise the complete message is returned once the answer ends.
+func (h *MessageHandler) PostMessage(c *gin.Context) {
+	// Extract userID from context
+	userID, ok := utils.GetUserIDFromContext(c)
+	if !ok {
+		return // Response already written in util
+	}
+
+	conversationID := c.Param("id")
+
+	var req postMessageRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	if req.Type == "" {
+		req.Type = services.ChatQuestion
+	}
+	if req.Type != services.ChatQuestion && req.Type != services.ChatRegenerate && req.Type != services.ChatEdit {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Unknown message type %q", req.Type)})
+		return
+	}
+	if err := validateMetadata(req.Metadata); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	stored, err := h.ChatService.Start(userID, conversationID, services.ChatTurnRequest{
+		Type:          req.Type,
+		MessageID:     req.MessageID,
+		Content:       req.Content,
+		AttachmentIDs: req.AttachmentIDs,
+		Metadata:      req.Metadata,
+	}, services.ChatHooks{
+		// The conversation goes back to MongoDB with the answer, unless a WebSocket still follows it
+		Done: func() {
+			h.releaseConversation(conversationID)
+		},
+	})
+	if err != nil {
+		var chatErr *services.ChatError
+		if !errors.As(err, &chatErr) {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Request failed"})
+			return
+		}
+		c.JSON(chatErrorStatus(chatErr.Code), gin.H{"error": chatErr.Message, "code": chatErr.Code})
+		return
+	}
+
+	if strings.Contains(c.GetHeader("Accept"), "text/event-stream") {
+		c.Header("Content-Type", "text/event-stream")
+		c.Header("Cache-Control", "no-cache")
+		c.Header("X-Accel-Buffering", "no") // Keep reverse proxies from buffering the stream
+		c.Status(http.StatusOK)
+
+		// Clients of this transport send no frames; they are gone once the request ends
+		incoming := make(chan []byte)
+		go func() {
+			<-c.Request.Context().Done()
+			close(incoming)
+		}()
+		h.followAnswer(&chatSession{
+			ctx:      c.Request.Context(),
+			writer:   newSSEWriter(c, conversationID),
+			incoming: incoming,
+			strict:   true,
+		}, stored.MessageID, 0)
+		return
+	}
+
+	// Wait for the whole answer, queued first if another answer is in progress; it is stored even if the
+	// client gives up first
+	var llmErr *services.LLMError
+	for chunk := range h.AnswerStreamService.Follow(c.Request.Context(), stored.MessageID, 0) {
+		switch chunk.Type {
+		case services.AnswerChunkError:
+			llmErr = chunk.LLMError()
+		case services.AnswerChunkRejected:
+			// The question waited in the queue and was refused once its turn came
+			c.JSON(chatErrorStatus(chunk.Error.Code), gin.H{"error": chunk.Error.Message, "code": chunk.Error.Code})
+			return
+		case services.AnswerChunkEnd:
+			if llmErr != nil {
+				c.JSON(http.StatusBadGateway, gin.H{"error": llmErrorData(llmErr), "message": newMessageView(*chunk.Message)})
+				return
 			}
-			aiResponse += response
+			c.JSON(http.StatusOK, gin.H{"message": newMessageView(*chunk.Message)})
+			return
+		}
+	}
+	if c.Request.Context().Err() == nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Answer stream is no longer available", "message_id": stored.MessageID})
+	}
+}
+
+// chatErrorStatus maps the code of a refused chat request to an HTTP status
+func chatErrorStatus(code string) int {
+	switch code {
+	case "conversation_not_found", "message_not_found", "attachment_not_found":
+		return http.StatusNotFound
+	case "empty_question", "too_many_attachments":
+		return http.StatusBadRequest
+	case "quota_exceeded", "queue_full":
+		return http.StatusTooManyRequests
+	case "cancelled":
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// Client frame types. In the legacy protocol anything that is not a JSON control frame is a plain question.
+const (
+	frameQuestion     = services.ChatQuestion
+	frameStop         = "stop"
+	frameRegenerate   = services.ChatRegenerate
+	frameEdit         = services.ChatEdit
+	frameSelectBranch = "select_branch"
+	frameLoadMore     = "load_more"
+)
+
+// Limits of the metadata a client may attach to a question
+const (
+	maxMetadataKeys     = 16
+	maxMetadataKeyLen   = 64
+	maxMetadataValueLen = 1024
+)
+
+type clientFrame struct {
+	Type          string            `json:"type"`
+	MessageID     string            `json:"message_id"`
+	Content       string            `json:"content"`
+	AttachmentIDs []string          `json:"attachment_ids"` // Uploaded attachments sent with a question or edit
+	Metadata      map[string]string `json:"metadata"`       // Client data stored on the message and echoed in message_start
+	Cursor        string            `json:"cursor"`         // Older messages asked with load_more start before it
+	Limit         int               `json:"limit"`          // Messages per load_more page, the configured page size if 0
+}
+
+// parseClientFrame recognises JSON frames such as {"type":"stop"} or {"type":"question","content":"...",
+// "attachment_ids":[...],"metadata":{...}}. Unless strict, everything else is a plain question.
+func parseClientFrame(data []byte, strict bool) (clientFrame, error) {
+	var frame clientFrame
+	if err := json.Unmarshal(data, &frame); err != nil {
+		if strict {
+			return clientFrame{}, errors.New("frames must be JSON objects")
 		}
-		utils.Logger.Info("AI response for user %s: %s", userID, aiResponse)
+		return clientFrame{Type: frameQuestion, Content: string(data)}, nil
+	}
+
+	switch frame.Type {
+	case frameQuestion, frameStop, frameRegenerate, frameEdit, frameSelectBranch, frameLoadMore:
+	default:
+		if strict {
+			return clientFrame{}, fmt.Errorf("unknown frame type %q", frame.Type)
+		}
+		return clientFrame{Type: frameQuestion, Content: string(data)}, nil
+	}
+
+	if frame.Type == frameLoadMore && frame.Cursor == "" {
+		return clientFrame{}, errors.New("load_more needs the cursor of the previous page")
+	}
+	if err := validateMetadata(frame.Metadata); err != nil {
+		return clientFrame{}, err
+	}
+	return frame, nil
+}
+
+// validateMetadata enforces the limits of the metadata a client may attach to a question
+func validateMetadata(metadata map[string]string) error {
+	if len(metadata) > maxMetadataKeys {
+		return fmt.Errorf("metadata may have at most %d keys", maxMetadataKeys)
+	}
+	for key, value := range metadata {
+		if key == "" || len(key) > maxMetadataKeyLen || len(value) > maxMetadataValueLen {
+			return fmt.Errorf("metadata keys must be 1-%d bytes and values at most %d bytes", maxMetadataKeyLen, maxMetadataValueLen)
+		}
+	}
+	return nil
+}
 
-		// Store the message in Redis
-		h.RedisMessageService.StoreOneMsgInRedis(userID, conversationID, string(message), aiResponse)
+// writeFrameErr logs a failed frame write; the read loop notices the broken connection
+func writeFrameErr(err error) {
+	if err != nil {
+		utils.Logger.Error("Error writing message: %v\n", err)
+	}
+}
+
+// offersSubprotocol reports whether the client listed the subprotocol in its handshake
+func offersSubprotocol(r *http.Request, protocol string) bool {
+	for _, offered := range websocket.Subprotocols(r) {
+		if offered == protocol {
+			return true
+		}
+	}
+	return false
+}
+
+// writeChatError tells the client why a chat request was refused
+func writeChatError(writer chatWriter, err error) {
+	var chatErr *services.ChatError
+	if !errors.As(err, &chatErr) {
+		utils.Logger.Error("Chat request failed: %v", err)
+		writeFrameErr(writer.Error("", "internal_error", "Request failed"))
+		return
+	}
+	if chatErr.Err != nil {
+		utils.Logger.Error("Chat request refused: %v", chatErr)
+	}
+	writeFrameErr(writer.Error("", chatErr.Code, chatErr.Message))
+}
+
+// readClientFrames forwards every frame from the socket to incoming until the socket fails or done is closed.
+// A client that sends neither frames nor pongs within the pong timeout is dropped.
+func readClientFrames(conn *websocket.Conn, incoming chan<- []byte, done <-chan struct{}, lastFrame *atomic.Int64) {
+	defer close(incoming)
+	extendDeadline := func() error {
+		return conn.SetReadDeadline(time.Now().Add(config.AppConfig.WSPongTimeout))
+	}
+	extendDeadline()
+	conn.SetPongHandler(func(string) error { return extendDeadline() })
+	for {
+		_, message, err := conn.ReadMessage()
+		if err != nil {
+			utils.Logger.Error("Error reading message: %v\n", err)
+			return
+		}
+		extendDeadline()
+		lastFrame.Store(time.Now().UnixNano())
+		select {
+		case incoming <- message:
+		case <-done:
+			return
+		}
 	}
 }
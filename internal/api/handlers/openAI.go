@@ -0,0 +1,319 @@
+package handlers
+
+import (
+	"chat-ai-backend/internal/models"
+	"chat-ai-backend/internal/services"
+	"chat-ai-backend/utils"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// Header naming the conversation an exchange is recorded into; without it nothing is recorded
+const conversationTitleHeader = "X-Conversation-Title"
+
+// OpenAIHandler serves a subset of the OpenAI API so tools that speak it can use the gateway
+type OpenAIHandler struct {
+	GatewayService *services.GatewayService
+}
+
+func NewOpenAIHandler(gatewaySvc *services.GatewayService) *OpenAIHandler {
+	return &OpenAIHandler{GatewayService: gatewaySvc}
+}
+
+type openAIChatRequest struct {
+	Model               string              `json:"model"`
+	Messages            []openAIChatMessage `json:"messages"`
+	Stream              bool                `json:"stream"`
+	StreamOptions       *openAIStreamOpts   `json:"stream_options"`
+	MaxTokens           int                 `json:"max_tokens"`
+	MaxCompletionTokens int                 `json:"max_completion_tokens"`
+	Temperature         *float64            `json:"temperature"`
+	TopP                *float64            `json:"top_p"`
+	Stop                json.RawMessage     `json:"stop"` // A string or a list of strings
+	Tools               json.RawMessage     `json:"tools"`
+}
+
+type openAIStreamOpts struct {
+	IncludeUsage bool `json:"include_usage"`
+}
+
+type openAIChatMessage struct {
+	Role    string          `json:"role"`
+	Content json.RawMessage `json:"content"` // A string or a list of content parts
+}
+
+// ListModels answers GET /models with the models of the configured provider
+func (h *OpenAIHandler) ListModels(c *gin.Context) {
+	names, err := h.GatewayService.ListModels(c.Request.Context())
+	if err != nil {
+		utils.Logger.Error("Failed to list models: %v", err)
+		writeOpenAIError(c, http.StatusBadGateway, "api_error", "upstream_unavailable", "Failed to list models")
+		return
+	}
+
+	owner := h.GatewayService.ProviderName()
+	data := make([]gin.H, 0, len(names))
+	for _, name := range names {
+		data = append(data, gin.H{"id": name, "object": "model", "created": 0, "owned_by": owner})
+	}
+	c.JSON(http.StatusOK, gin.H{"object": "list", "data": data})
+}
+
+// ChatCompletions answers POST /chat/completions, streamed as Server-Sent Events when stream is true.
+// With the X-Conversation-Title header the exchange is also recorded into that conversation.
+func (h *OpenAIHandler) ChatCompletions(c *gin.Context) {
+	// Extract userID from context
+	userID, ok := utils.GetUserIDFromContext(c)
+	if !ok {
+		return // Response already written in util
+	}
+	apiKeyID := c.GetString("apiKeyID")
+
+	var body openAIChatRequest
+	if err := c.ShouldBindJSON(&body); err != nil {
+		writeOpenAIError(c, http.StatusBadRequest, "invalid_request_error", "invalid_body", "Invalid request body")
+		return
+	}
+	req, err := newGatewayRequest(body)
+	if err != nil {
+		writeOpenAIError(c, http.StatusBadRequest, "invalid_request_error", "invalid_request", err.Error())
+		return
+	}
+
+	if req.Model == "" {
+		req.Model = h.GatewayService.LLMService.Model
+	}
+	id := "chatcmpl-" + uuid.New().String()
+	created := time.Now().Unix()
+	model := req.Model
+
+	events := make(chan services.StreamEvent)
+	type result struct {
+		content string
+		usage   *models.Usage
+		err     error
+	}
+	done := make(chan result, 1)
+	go func() {
+		content, usage, err := h.GatewayService.Complete(c.Request.Context(), userID, apiKeyID, req, events)
+		close(events)
+		done <- result{content, usage, err}
+	}()
+
+	var res result
+	if body.Stream {
+		// Headers go out with the first delta, so failures before it still get a proper status
+		streaming := false
+		startStream := func() {
+			if streaming {
+				return
+			}
+			streaming = true
+			c.Header("Content-Type", "text/event-stream")
+			c.Header("Cache-Control", "no-cache")
+			c.Header("X-Accel-Buffering", "no") // Keep reverse proxies from buffering the stream
+			c.Status(http.StatusOK)
+			writeSSEData(c, openAIChunk(id, created, model, gin.H{"role": "assistant", "content": ""}, nil))
+		}
+		// Deltas are forwarded as they arrive, so with MODERATION_CHECK_OUTPUT the answer is only
+		// screened once the client has received all of it: a flagged answer ends with an error event
+		// but cannot be withheld. Only unstreamed answers are blocked before they are sent.
+		for event := range events {
+			if event.Type == services.StreamEventDelta {
+				startStream()
+				writeSSEData(c, openAIChunk(id, created, model, gin.H{"content": event.Content}, nil))
+			}
+		}
+		res = <-done
+		if res.err != nil {
+			if !streaming {
+				writeGatewayError(c, res.err)
+				return
+			}
+			_, errType, code, message := gatewayErrorDetails(res.err)
+			writeSSEData(c, gin.H{"error": gin.H{"message": message, "type": errType, "param": nil, "code": code}})
+			return
+		}
+		startStream()
+		stop := "stop"
+		writeSSEData(c, openAIChunk(id, created, model, gin.H{}, &stop))
+		if body.StreamOptions != nil && body.StreamOptions.IncludeUsage {
+			chunk := openAIChunk(id, created, model, nil, nil)
+			chunk["choices"] = []gin.H{}
+			chunk["usage"] = openAIUsage(res.usage)
+			writeSSEData(c, chunk)
+		}
+		fmt.Fprint(c.Writer, "data: [DONE]\n\n")
+		c.Writer.Flush()
+	} else {
+		for range events {
+			// The answer is returned whole
+		}
+		res = <-done
+		if res.err != nil {
+			writeGatewayError(c, res.err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"id":      id,
+			"object":  "chat.completion",
+			"created": created,
+			"model":   model,
+			"choices": []gin.H{{
+				"index":         0,
+				"message":       gin.H{"role": "assistant", "content": res.content},
+				"finish_reason": "stop",
+			}},
+			"usage": openAIUsage(res.usage),
+		})
+	}
+
+	if title := strings.TrimSpace(c.GetHeader(conversationTitleHeader)); title != "" {
+		h.GatewayService.RecordAsync(userID, title, lastUserMessage(req.Messages), res.content, res.usage)
+	}
+}
+
+// newGatewayRequest converts an OpenAI request. Only text conversations are supported.
+func newGatewayRequest(body openAIChatRequest) (services.ChatRequest, error) {
+	if len(body.Messages) == 0 {
+		return services.ChatRequest{}, errors.New("messages must not be empty")
+	}
+	if len(body.Tools) > 0 && string(body.Tools) != "null" && string(body.Tools) != "[]" {
+		return services.ChatRequest{}, errors.New("tools are not supported")
+	}
+
+	req := services.ChatRequest{
+		Model:       body.Model,
+		MaxTokens:   body.MaxTokens,
+		Temperature: body.Temperature,
+		TopP:        body.TopP,
+	}
+	if body.MaxCompletionTokens > 0 {
+		req.MaxTokens = body.MaxCompletionTokens
+	}
+	if len(body.Stop) > 0 && string(body.Stop) != "null" {
+		var stop string
+		if err := json.Unmarshal(body.Stop, &stop); err == nil {
+			req.Stop = []string{stop}
+		} else if err := json.Unmarshal(body.Stop, &req.Stop); err != nil {
+			return services.ChatRequest{}, errors.New("stop must be a string or a list of strings")
+		}
+	}
+
+	for i, message := range body.Messages {
+		switch message.Role {
+		case "system", "user", "assistant":
+		case "developer":
+			message.Role = "system"
+		default:
+			return services.ChatRequest{}, fmt.Errorf("messages[%d]: unsupported role %q", i, message.Role)
+		}
+		content, err := openAIMessageText(message.Content)
+		if err != nil {
+			return services.ChatRequest{}, fmt.Errorf("messages[%d]: %w", i, err)
+		}
+		req.Messages = append(req.Messages, services.ChatMessage{Role: message.Role, Content: content})
+	}
+	return req, nil
+}
+
+// openAIMessageText reads message content given as a string or as a list of text parts
+func openAIMessageText(raw json.RawMessage) (string, error) {
+	var text string
+	if err := json.Unmarshal(raw, &text); err == nil {
+		return text, nil
+	}
+	var parts []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	}
+	if err := json.Unmarshal(raw, &parts); err != nil {
+		return "", errors.New("content must be a string or a list of content parts")
+	}
+	var b strings.Builder
+	for _, part := range parts {
+		if part.Type != "text" {
+			return "", fmt.Errorf("content parts of type %q are not supported", part.Type)
+		}
+		b.WriteString(part.Text)
+	}
+	return b.String(), nil
+}
+
+// lastUserMessage is the question recorded for an exchange
+func lastUserMessage(messages []services.ChatMessage) string {
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Role == "user" {
+			return messages[i].Content
+		}
+	}
+	return ""
+}
+
+func openAIChunk(id string, created int64, model string, delta gin.H, finishReason *string) gin.H {
+	return gin.H{
+		"id":      id,
+		"object":  "chat.completion.chunk",
+		"created": created,
+		"model":   model,
+		"choices": []gin.H{{"index": 0, "delta": delta, "finish_reason": finishReason}},
+	}
+}
+
+func openAIUsage(usage *models.Usage) gin.H {
+	if usage == nil {
+		return gin.H{"prompt_tokens": 0, "completion_tokens": 0, "total_tokens": 0}
+	}
+	return gin.H{
+		"prompt_tokens":     usage.PromptTokens,
+		"completion_tokens": usage.CompletionTokens,
+		"total_tokens":      usage.TotalTokens,
+	}
+}
+
+// writeSSEData writes one "data:" event in the framing OpenAI clients parse
+func writeSSEData(c *gin.Context, payload gin.H) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(c.Writer, "data: %s\n\n", data)
+	c.Writer.Flush()
+}
+
+func writeOpenAIError(c *gin.Context, status int, errType, code, message string) {
+	c.JSON(status, gin.H{"error": gin.H{"message": message, "type": errType, "param": nil, "code": code}})
+}
+
+func writeGatewayError(c *gin.Context, err error) {
+	status, errType, code, message := gatewayErrorDetails(err)
+	writeOpenAIError(c, status, errType, code, message)
+}
+
+// gatewayErrorDetails maps quota, moderation and upstream failures to OpenAI error responses
+func gatewayErrorDetails(err error) (int, string, string, string) {
+	if errors.Is(err, services.ErrQuotaExceeded) {
+		return http.StatusTooManyRequests, "insufficient_quota", "quota_exceeded", "Daily usage quota exceeded"
+	}
+	if errors.Is(err, services.ErrContentFlagged) {
+		return http.StatusBadRequest, "invalid_request_error", "content_flagged", err.Error()
+	}
+	llmErr := services.AsLLMError(err)
+	switch llmErr.Code {
+	case services.LLMErrorRateLimited:
+		return http.StatusTooManyRequests, "rate_limit_error", llmErr.Code, llmErr.Message
+	case services.LLMErrorRejected:
+		return http.StatusBadRequest, "invalid_request_error", llmErr.Code, llmErr.Message
+	case services.LLMErrorUnavailable, services.LLMErrorCircuitOpen:
+		return http.StatusServiceUnavailable, "api_error", llmErr.Code, llmErr.Message
+	default:
+		return http.StatusBadGateway, "api_error", llmErr.Code, llmErr.Message
+	}
+}
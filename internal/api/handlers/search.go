@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"chat-ai-backend/internal/services"
+	"chat-ai-backend/utils"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+type SearchHandler struct {
+	SearchIndexService *services.SearchIndexService
+}
+
+func NewSearchHandler(service *services.SearchIndexService) *SearchHandler {
+	return &SearchHandler{SearchIndexService: service}
+}
+
+// Search returns the caller's past messages closest in meaning to ?q=
+func (h *SearchHandler) Search(c *gin.Context) {
+	// Extract userID from context
+	userID, ok := utils.GetUserIDFromContext(c)
+	if !ok {
+		return // Response already written in util
+	}
+
+	if h.SearchIndexService == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Search is disabled"})
+		return
+	}
+
+	query := strings.TrimSpace(c.Query("q"))
+	if query == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing query parameter q"})
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "10"))
+	if err != nil || limit < 1 || limit > 50 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 50"})
+		return
+	}
+
+	results, err := h.SearchIndexService.Search(c.Request.Context(), userID, query, limit)
+	if err != nil {
+		utils.Logger.Error("Search failed for user %s: %v", userID, err)
+		var llmErr *services.LLMError
+		switch {
+		case errors.Is(err, services.ErrEmbeddingsNotSupported):
+			c.JSON(http.StatusNotImplemented, gin.H{"error": err.Error()})
+		case errors.As(err, &llmErr):
+			c.JSON(http.StatusBadGateway, gin.H{"error": llmErr.Message, "code": llmErr.Code})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Search failed"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"query": query, "results": results})
+}
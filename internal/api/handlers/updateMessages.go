@@ -1,8 +1,10 @@
 package handlers
 
 import (
+	"chat-ai-backend/internal/repositories"
 	"chat-ai-backend/internal/services"
 	"chat-ai-backend/utils"
+	"errors"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
@@ -18,7 +20,7 @@ func NewUpdateMessageHandler(service *services.UpdateMessageService) *MessageUpd
 
 func (h *MessageUpdateHandler) UpdateMessage(c *gin.Context) {
 	// Extract userID from context
-	_, ok := utils.GetUserIDFromContext(c)
+	userID, ok := utils.GetUserIDFromContext(c)
 	if !ok {
 		return // Response already written in util
 	}
@@ -40,7 +42,11 @@ func (h *MessageUpdateHandler) UpdateMessage(c *gin.Context) {
 		return
 	}
 
-	err := h.Service.UpdateMessage(messageID, input.Feedback, input.ThumbUp)
+	err := h.Service.UpdateMessage(userID, messageID, input.Feedback, input.ThumbUp)
+	if errors.Is(err, repositories.ErrMessageNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Message not found"})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update message"})
 		return
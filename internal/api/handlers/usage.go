@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"chat-ai-backend/config"
+	"chat-ai-backend/internal/repositories"
+	"chat-ai-backend/internal/services"
+	"chat-ai-backend/utils"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type UsageHandler struct {
+	UsageService *services.UsageService
+}
+
+func NewUsageHandler(service *services.UsageService) *UsageHandler {
+	return &UsageHandler{UsageService: service}
+}
+
+// GetUsage returns the caller's token usage by day and by model
+func (h *UsageHandler) GetUsage(c *gin.Context) {
+	// Extract userID from context
+	userID, ok := utils.GetUserIDFromContext(c)
+	if !ok {
+		return // Response already written in util
+	}
+
+	days, err := strconv.Atoi(c.DefaultQuery("days", "30"))
+	if err != nil || days < 1 || days > 90 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "days must be between 1 and 90"})
+		return
+	}
+
+	daily, err := h.UsageService.GetUsage(userID, days)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load usage"})
+		return
+	}
+
+	// Sum up the per-model totals over the whole period
+	byModel := map[string]*repositories.ModelUsage{}
+	total := repositories.ModelUsage{}
+	for _, day := range daily {
+		total.Requests += day.Requests
+		total.PromptTokens += day.PromptTokens
+		total.CompletionTokens += day.CompletionTokens
+		total.TotalTokens += day.TotalTokens
+		for model, usage := range day.Models {
+			m, ok := byModel[model]
+			if !ok {
+				m = &repositories.ModelUsage{}
+				byModel[model] = m
+			}
+			m.Requests += usage.Requests
+			m.PromptTokens += usage.PromptTokens
+			m.CompletionTokens += usage.CompletionTokens
+			m.TotalTokens += usage.TotalTokens
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"days":     daily,
+		"by_model": byModel,
+		"total":    total,
+		"quota": gin.H{
+			"daily_tokens":   config.AppConfig.DailyTokenQuota,
+			"daily_requests": config.AppConfig.DailyRequestQuota,
+		},
+	})
+}
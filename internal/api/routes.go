@@ -9,6 +9,8 @@ import (
 	"chat-ai-backend/internal/services"
 	"chat-ai-backend/middleware"
 	"chat-ai-backend/pkg/database"
+	"context"
+	"log"
 	"time"
 
 	"github.com/gin-contrib/cors"
@@ -48,62 +50,248 @@ func SetupRouter(mongo *mongo.Client, redis *redis.Client) *gin.Engine {
 		database.RedisChatDB,
 	)
 
+	usageRepo := repositories.NewUsageRepository(database.RedisChatDB)
+
+	documentRepo := repositories.NewDocumentRepository(database.DocumentCollection)
+
+	attachmentRepo := repositories.NewAttachmentRepository(database.AttachmentCollection)
+
+	answerStreamRepo := repositories.NewAnswerStreamRepository(database.RedisChatDB)
+
+	connectionRepo := repositories.NewConnectionRepository(database.RedisChatDB)
+
+	conversationEventRepo := repositories.NewConversationEventRepository(database.RedisChatDB)
+
+	conversationLockRepo := repositories.NewConversationLockRepository(database.RedisChatDB)
+
+	apiKeyRepo := repositories.NewAPIKeyRepository(database.APIKeyCollection)
+
+	// Attachment bytes live on disk or in an S3-compatible bucket
+	var blobStore repositories.BlobStore
+	if config.AppConfig.AttachmentStore == "s3" {
+		blobStore = repositories.NewS3BlobStore(
+			config.AppConfig.S3Endpoint,
+			config.AppConfig.S3Region,
+			config.AppConfig.S3Bucket,
+			config.AppConfig.S3AccessKey,
+			config.AppConfig.S3SecretKey,
+			config.AppConfig.S3PathStyle,
+		)
+	} else {
+		localStore, err := repositories.NewLocalBlobStore(config.AppConfig.AttachmentDir)
+		if err != nil {
+			log.Fatalf("Failed to create attachment directory: %v", err)
+		}
+		blobStore = localStore
+	}
+
+	// Documents and chat messages are kept in separate collections
+	var documentStore, messageStore repositories.VectorStore
+	if config.AppConfig.VectorStore == "milvus" {
+		documentStore = repositories.NewMilvusVectorStore(database.VectorDB, repositories.MilvusDocumentsPrefix)
+		messageStore = repositories.NewMilvusVectorStore(database.VectorDB, repositories.MilvusMessagesPrefix)
+	} else {
+		// Every replica would keep and fill its own index, answering searches differently
+		if !config.AppConfig.DevMode {
+			log.Fatalf("VECTOR_STORE=%s keeps vectors in process memory; use VECTOR_STORE=milvus or set DEV_MODE=true for a single local instance", config.AppConfig.VectorStore)
+		}
+		documentStore = repositories.NewMemoryVectorStore()
+		messageStore = repositories.NewMemoryVectorStore()
+	}
+
 	// Services
 	authService := services.NewAuthService(userRepo)
-	convoService := services.NewConversationService(convoRepo)
+	conversationEventService := services.NewConversationEventService(conversationEventRepo)
+	convoService := services.NewConversationService(convoRepo, conversationEventService)
 	messageService := services.NewMessageService(messageRepo)
-	messageUpdateService := services.NewUpdateMessageService(messageUpdateRepo)
-	redisMessageService := services.NewRedisMessageService(redisMessageRepo)
-	OpenAIService := services.NewOpenAIService(config.AppConfig.OpenAIUrl, config.AppConfig.OpenAIKey)
-
-	// Handlers
-	authHandler := handlers.NewAuthHandler(authService)
-	convoHandler := handlers.NewConversationHandler(convoService)
-	messageHandler := handlers.NewMessageHandler(messageService, convoService, redisMessageService, OpenAIService)
-	updateMessageHandler := handlers.NewUpdateMessageHandler(messageUpdateService)
-
-	// Middleware
-	authMiddleware := middleware.NewAuthMiddleware(authService)
+	llmProvider, err := services.NewLLMProvider(config.AppConfig)
+	if err != nil {
+		log.Fatalf("Failed to create LLM provider: %v", err)
+	}
+	var searchIndexService *services.SearchIndexService
+	if config.AppConfig.SearchIndexEnabled {
+		searchIndexService = services.NewSearchIndexService(messageRepo, messageStore, llmProvider)
+		searchIndexService.Start(context.Background())
+		if config.AppConfig.SearchBackfillOnStart {
+			// The in-memory store starts empty, so everything has to be embedded again
+			searchIndexService.Backfill(config.AppConfig.VectorStore != "milvus")
+		}
+	}
+	redisMessageService := services.NewRedisMessageService(redisMessageRepo, searchIndexService)
+	var toolRegistry *services.ToolRegistry
+	if config.AppConfig.LLMToolsEnabled {
+		toolRegistry = services.NewToolRegistry()
+		if err := services.RegisterBuiltinTools(toolRegistry, messageService); err != nil {
+			log.Fatalf("Failed to register built-in tools: %v", err)
+		}
+	}
+	llmService := services.NewLLMService(llmProvider, config.AppConfig.LLMModel, toolRegistry)
+	usageService := services.NewUsageService(usageRepo)
+	userService := services.NewUserService(userRepo)
+	titleService := services.NewTitleService(llmService, convoService, userService, usageService)
+	summaryService := services.NewSummaryService(llmService, convoService, usageService)
+	documentService := services.NewDocumentService(documentRepo, documentStore, llmProvider)
+	attachmentService := services.NewAttachmentService(attachmentRepo, blobStore)
+	answerStreamService := services.NewAnswerStreamService(answerStreamRepo, llmService)
+	authorizationService := services.NewAuthorizationService(convoRepo, redisMessageRepo, answerStreamService)
+	messageUpdateService := services.NewUpdateMessageService(messageUpdateRepo, authorizationService, conversationEventService)
+	connectionService := services.NewConnectionService(connectionRepo)
+	conversationLockService := services.NewConversationLockService(conversationLockRepo)
+	chatService := services.NewChatService(convoService, redisMessageService, usageService, titleService, summaryService, documentService, attachmentService, answerStreamService, conversationEventService, conversationLockService)
+	apiKeyService := services.NewAPIKeyService(apiKeyRepo)
+	var moderationService *services.ModerationService
+	if config.AppConfig.ModerationEnabled {
+		moderationService = services.NewModerationService(llmProvider, config.AppConfig.ModerationModel, config.AppConfig.ModerationBlocklist)
+	}
+	gatewayService := services.NewGatewayService(llmService, usageService, convoService, redisMessageService, conversationLockService, moderationService)
+
 	// Create a new Gin engine instance
 	r := gin.Default()
 
 	r.Use(cors.New(cors.Config{
-		AllowOrigins:     []string{"http://localhost:3000"},
+		AllowOrigins:     config.AppConfig.AllowedOrigins, // Also checked by the WebSocket handshake
 		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
 		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization"},
 		AllowCredentials: true, // Allow cookies (HttpOnly refresh token)
 		MaxAge:           12 * time.Hour,
 	}))
 
+	registerRoutes(r, routeHandlers{
+		Auth:          handlers.NewAuthHandler(authService),
+		Conversation:  handlers.NewConversationHandler(convoService),
+		Message:       handlers.NewMessageHandler(messageService, convoService, redisMessageService, chatService, answerStreamService, connectionService, conversationEventService, authorizationService),
+		UpdateMessage: handlers.NewUpdateMessageHandler(messageUpdateService),
+		Usage:         handlers.NewUsageHandler(usageService),
+		User:          handlers.NewUserHandler(userService),
+		Document:      handlers.NewDocumentHandler(documentService),
+		Search:        handlers.NewSearchHandler(searchIndexService),
+		Attachment:    handlers.NewAttachmentHandler(attachmentService),
+		APIKey:        handlers.NewAPIKeyHandler(apiKeyService),
+		OpenAI:        handlers.NewOpenAIHandler(gatewayService),
+	}, routeMiddleware{
+		Auth:          middleware.NewAuthMiddleware(authService),
+		APIKey:        middleware.NewAPIKeyMiddleware(apiKeyService),
+		Authorization: middleware.NewAuthorizationMiddleware(authorizationService),
+	})
+
+	return r
+}
+
+// routeHandlers are the handlers behind the API routes
+type routeHandlers struct {
+	Auth          *handlers.AuthHandler
+	Conversation  *handlers.ConversationHandler
+	Message       *handlers.MessageHandler
+	UpdateMessage *handlers.MessageUpdateHandler
+	Usage         *handlers.UsageHandler
+	User          *handlers.UserHandler
+	Document      *handlers.DocumentHandler
+	Search        *handlers.SearchHandler
+	Attachment    *handlers.AttachmentHandler
+	APIKey        *handlers.APIKeyHandler
+	OpenAI        *handlers.OpenAIHandler
+}
+
+// routeMiddleware authenticates requests and guards the routes of one conversation or message
+type routeMiddleware struct {
+	Auth          *middleware.AuthMiddleware
+	APIKey        *middleware.APIKeyMiddleware
+	Authorization *middleware.AuthorizationMiddleware
+}
+
+// registerRoutes adds the API routes. Routes of one conversation or message are only served to the
+// owner; the WebSocket route checks the conversation it is asked for itself.
+func registerRoutes(r *gin.Engine, h routeHandlers, m routeMiddleware) {
 	// API Version 1
 	v1 := r.Group("/api/v1")
 	{
 		// Auth routes
 		auth := v1.Group("/auth")
 		{
-			auth.POST("/login", authHandler.Login)       // Login route
-			auth.POST("/register", authHandler.Register) // Register route
-			auth.GET("/refresh-token", authHandler.RefreshTokenHandler)
-			auth.POST("/logout", authHandler.LogoutHandler)
+			auth.POST("/login", h.Auth.Login)       // Login route
+			auth.POST("/register", h.Auth.Register) // Register route
+			auth.GET("/refresh-token", h.Auth.RefreshTokenHandler)
+			auth.POST("/logout", h.Auth.LogoutHandler)
 		}
 
 		// WebSocket route
 		messages := v1.Group("/messages")
-		messages.Use(authMiddleware.AuthMiddleware())
+		messages.Use(m.Auth.AuthMiddleware())
+		{
+			messages.GET("/ws", h.Message.Messages)
+			messages.PUT("/:id", m.Authorization.MessageAccess(), h.UpdateMessage.UpdateMessage)
+		}
+
+		// Usage routes
+		v1.GET("/usage", m.Auth.AuthMiddleware(), h.Usage.GetUsage)
+
+		// Search routes
+		v1.GET("/search", m.Auth.AuthMiddleware(), h.Search.Search)
+
+		// User routes
+		users := v1.Group("/users")
+		users.Use(m.Auth.AuthMiddleware())
+		{
+			users.GET("/me/preferences", h.User.GetPreferences)
+			users.PUT("/me/preferences", h.User.UpdatePreferences)
+		}
+
+		// Document routes
+		documents := v1.Group("/documents")
+		documents.Use(m.Auth.AuthMiddleware())
 		{
-			messages.GET("/ws", messageHandler.Messages)
-			messages.PUT("/:id", updateMessageHandler.UpdateMessage)
+			documents.POST("", h.Document.UploadDocument)
+			documents.GET("", h.Document.ListDocuments)
+			documents.DELETE("/:id", h.Document.DeleteDocument)
+		}
+
+		// Attachment routes
+		attachments := v1.Group("/attachments")
+		attachments.Use(m.Auth.AuthMiddleware())
+		{
+			attachments.POST("", h.Attachment.UploadAttachment)
+			attachments.GET("", h.Attachment.ListAttachments)
+			attachments.GET("/:id/content", h.Attachment.GetAttachmentContent)
+			attachments.DELETE("/:id", h.Attachment.DeleteAttachment)
+		}
+
+		// API key routes
+		apiKeys := v1.Group("/api-keys")
+		apiKeys.Use(m.Auth.AuthMiddleware())
+		{
+			apiKeys.POST("", h.APIKey.CreateAPIKey)
+			apiKeys.GET("", h.APIKey.ListAPIKeys)
+			apiKeys.DELETE("/:id", h.APIKey.DeleteAPIKey)
+		}
+
+		// OpenAI-compatible routes, authenticated with an API key instead of the session cookies
+		openAI := v1.Group("/openai/v1")
+		openAI.Use(m.APIKey.APIKeyMiddleware())
+		{
+			openAI.GET("/models", h.OpenAI.ListModels)
+			openAI.POST("/chat/completions", h.OpenAI.ChatCompletions)
 		}
 
 		// Conversation routes
 		conversations := v1.Group("/conversations")
-		conversations.Use(authMiddleware.AuthMiddleware())
+		conversations.Use(m.Auth.AuthMiddleware())
 		{
-			conversations.POST("/", convoHandler.CreateConversationHandler)      // Create a conversation
-			conversations.DELETE("/:id", convoHandler.DeleteConversationHandler) // Delete a conversation
-			conversations.PATCH("/:id", convoHandler.UpdateConversationHandler)  // Update a conversation
+			conversations.GET("", h.Conversation.ListConversationsHandler)    // List conversations
+			conversations.POST("/", h.Conversation.CreateConversationHandler) // Create a conversation
+
+			// Routes of one conversation, only for its owner
+			conversation := conversations.Group("/:id")
+			conversation.Use(m.Authorization.ConversationAccess())
+			{
+				conversation.DELETE("", h.Conversation.DeleteConversationHandler) // Delete a conversation
+				conversation.PATCH("", h.Conversation.UpdateConversationHandler)  // Update a conversation
+				conversation.GET("/settings", h.Conversation.GetConversationSettingsHandler)
+				conversation.PUT("/settings", h.Conversation.UpdateConversationSettingsHandler)
+				conversation.GET("/summary", h.Conversation.GetConversationSummaryHandler)
+				conversation.GET("/tree", h.Message.GetMessageTree)   // Branches of a conversation
+				conversation.GET("/messages", h.Message.GetMessages)  // Read messages without a WebSocket
+				conversation.POST("/messages", h.Message.PostMessage) // Ask without a WebSocket, optionally as SSE
+			}
 		}
 	}
-
-	return r
 }
@@ -0,0 +1,182 @@
+package api
+
+import (
+	"chat-ai-backend/config"
+	"chat-ai-backend/internal/api/handlers"
+	"chat-ai-backend/internal/models"
+	"chat-ai-backend/internal/repositories"
+	"chat-ai-backend/internal/services"
+	"chat-ai-backend/middleware"
+	"chat-ai-backend/utils"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// fakeStore holds the conversations, messages and answer streams the authorization service looks up
+type fakeStore struct {
+	conversations map[string]*models.Conversation
+	messages      map[string]*models.Message
+}
+
+func (s *fakeStore) GetConversationByID(convoID string) (*models.Conversation, error) {
+	convo, ok := s.conversations[convoID]
+	if !ok {
+		return nil, repositories.ErrConversationNotFound
+	}
+	return convo, nil
+}
+
+func (s *fakeStore) FindMessage(messageID string) (*models.Message, error) {
+	msg, ok := s.messages[messageID]
+	if !ok {
+		return nil, repositories.ErrMessageNotFound
+	}
+	return msg, nil
+}
+
+func (s *fakeStore) Lookup(ctx context.Context, messageID string) (*models.Message, error) {
+	msg, ok := s.messages[messageID]
+	if !ok {
+		return nil, services.ErrAnswerStreamNotFound
+	}
+	return msg, nil
+}
+
+// newTestRouter registers the real routes with the real auth and authorization middleware. The
+// handlers have no services behind them: a request that gets past the middleware panics or is
+// rejected by the handler, and never answers 404.
+func newTestRouter(t *testing.T) *gin.Engine {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	gin.DefaultErrorWriter = io.Discard
+
+	config.AppConfig = &config.Config{JWTSecretKey: "test-secret", WSLegacyProtocol: true}
+	utils.InitializeJWT()
+
+	store := &fakeStore{
+		conversations: map[string]*models.Conversation{
+			"conv-alice": {UserID: "alice"},
+			"conv-bob":   {UserID: "bob"},
+		},
+		messages: map[string]*models.Message{
+			"msg-alice": {MessageID: "msg-alice", ConversationID: "conv-alice"},
+			"msg-bob":   {MessageID: "msg-bob", ConversationID: "conv-bob"},
+		},
+	}
+	authorizationService := services.NewAuthorizationService(store, store, store)
+
+	r := gin.New()
+	r.Use(gin.Recovery())
+	registerRoutes(r, routeHandlers{
+		Auth:          handlers.NewAuthHandler(nil),
+		Conversation:  handlers.NewConversationHandler(nil),
+		Message:       handlers.NewMessageHandler(nil, nil, nil, nil, nil, nil, nil, authorizationService),
+		UpdateMessage: handlers.NewUpdateMessageHandler(nil),
+		Usage:         handlers.NewUsageHandler(nil),
+		User:          handlers.NewUserHandler(nil),
+		Document:      handlers.NewDocumentHandler(nil),
+		Search:        handlers.NewSearchHandler(nil),
+		Attachment:    handlers.NewAttachmentHandler(nil),
+		APIKey:        handlers.NewAPIKeyHandler(nil),
+		OpenAI:        handlers.NewOpenAIHandler(nil),
+	}, routeMiddleware{
+		Auth:          middleware.NewAuthMiddleware(nil),
+		APIKey:        middleware.NewAPIKeyMiddleware(nil),
+		Authorization: middleware.NewAuthorizationMiddleware(authorizationService),
+	})
+	return r
+}
+
+// serveAs sends a request authenticated as userID
+func serveAs(t *testing.T, r *gin.Engine, userID, method, path string) int {
+	t.Helper()
+	token, err := utils.GenerateJWT(userID, userID+"@example.com", time.Minute)
+	if err != nil {
+		t.Fatalf("GenerateJWT: %v", err)
+	}
+	req := httptest.NewRequest(method, path, nil)
+	req.AddCookie(&http.Cookie{Name: "access_token", Value: token})
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w.Code
+}
+
+func TestRoutesOfOneConversationOrMessageOnlyServeTheOwner(t *testing.T) {
+	r := newTestRouter(t)
+
+	// Every route naming a conversation or message by :id must be guarded, including ones added later
+	guarded := 0
+	for _, route := range r.Routes() {
+		var owned, foreign, missing string
+		switch {
+		case strings.HasPrefix(route.Path, "/api/v1/conversations/:id"):
+			owned, foreign, missing = "conv-alice", "conv-bob", "conv-missing"
+		case strings.HasPrefix(route.Path, "/api/v1/messages/:id"):
+			owned, foreign, missing = "msg-alice", "msg-bob", "msg-missing"
+		default:
+			continue
+		}
+		guarded++
+
+		cases := []struct {
+			name     string
+			id       string
+			notFound bool
+		}{
+			{"owner", owned, false},
+			{"foreign", foreign, true},
+			{"missing", missing, true},
+		}
+		for _, tc := range cases {
+			path := strings.Replace(route.Path, ":id", tc.id, 1)
+			t.Run(route.Method+" "+path, func(t *testing.T) {
+				code := serveAs(t, r, "alice", route.Method, path)
+				if notFound := code == http.StatusNotFound; notFound != tc.notFound {
+					t.Errorf("%s: status = %d, want not found %v", tc.name, code, tc.notFound)
+				}
+				if code == http.StatusUnauthorized {
+					t.Errorf("%s: status = %d, the request was not authenticated", tc.name, code)
+				}
+			})
+		}
+	}
+
+	// DELETE, PATCH, GET and PUT /settings, /summary, /tree, GET and POST /messages, PUT /messages/:id
+	if guarded != 9 {
+		t.Errorf("found %d routes of one conversation or message, want 9", guarded)
+	}
+}
+
+func TestWebSocketHandshakeOnlyOpensTheOwnersConversation(t *testing.T) {
+	r := newTestRouter(t)
+
+	cases := []struct {
+		name     string
+		query    string
+		notFound bool
+	}{
+		{"owner", "conversationID=conv-alice", false},
+		{"foreign", "conversationID=conv-bob", true},
+		{"missing", "conversationID=conv-missing", true},
+		{"resume in a foreign conversation", "conversationID=conv-bob&resume=msg-bob", true},
+		{"resume in a missing conversation", "conversationID=conv-missing&resume=msg-alice", true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			code := serveAs(t, r, "alice", http.MethodGet, "/api/v1/messages/ws?"+tc.query)
+			if notFound := code == http.StatusNotFound; notFound != tc.notFound {
+				t.Errorf("status = %d, want not found %v", code, tc.notFound)
+			}
+			if code == http.StatusUnauthorized {
+				t.Errorf("status = %d, the request was not authenticated", code)
+			}
+		})
+	}
+}
@@ -0,0 +1,16 @@
+// internal/models/apiKey.go
+
+package models
+
+import "time"
+
+// APIKey lets tools call the OpenAI-compatible API on behalf of a user. Only a hash of the key is stored.
+type APIKey struct {
+	ID         string     `bson:"_id,omitempty" json:"id"`                    // MongoDB auto-generates this field
+	UserID     string     `bson:"user_id" json:"user_id"`                     // Owner of the key
+	Name       string     `bson:"name" json:"name"`                           // Label chosen by the user
+	Prefix     string     `bson:"prefix" json:"prefix"`                       // First characters of the key, to tell keys apart
+	Hash       string     `bson:"hash" json:"-"`                              // SHA-256 of the key
+	CreatedAt  time.Time  `bson:"created_at" json:"created_at"`               // When the key was created
+	LastUsedAt *time.Time `bson:"last_used_at,omitempty" json:"last_used_at"` // Last authenticated request, roughly
+}
@@ -0,0 +1,25 @@
+// internal/models/attachment.go
+
+package models
+
+import (
+	"strings"
+	"time"
+)
+
+// Attachment is a file a user uploaded to send along with a question. The bytes live in the blob store.
+type Attachment struct {
+	ID          string    `bson:"_id,omitempty" json:"id"`          // MongoDB auto-generates this field
+	UserID      string    `bson:"user_id" json:"user_id"`           // Owner of the attachment
+	Filename    string    `bson:"filename" json:"filename"`         // Name of the uploaded file
+	ContentType string    `bson:"content_type" json:"content_type"` // MIME type detected from the content
+	Size        int64     `bson:"size" json:"size"`                 // Upload size in bytes
+	StorageKey  string    `bson:"storage_key,omitempty" json:"-"`   // Key of the bytes in the blob store, only kept in the attachments collection
+	URL         string    `bson:"url" json:"url"`                   // API path serving the content
+	CreatedAt   time.Time `bson:"created_at" json:"created_at"`     // When the attachment was uploaded
+}
+
+// IsImage reports whether the attachment can be shown to a vision model
+func (a Attachment) IsImage() bool {
+	return strings.HasPrefix(a.ContentType, "image/")
+}
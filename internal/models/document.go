@@ -0,0 +1,18 @@
+// internal/models/document.go
+
+package models
+
+import "time"
+
+// Document is a file uploaded by a user for retrieval-augmented answers. The chunks and their
+// embeddings live in the vector store.
+type Document struct {
+	ID          string    `bson:"_id,omitempty" json:"id"`          // MongoDB auto-generates this field
+	UserID      string    `bson:"user_id" json:"user_id"`           // Owner of the document
+	Filename    string    `bson:"filename" json:"filename"`         // Name of the uploaded file
+	ContentType string    `bson:"content_type" json:"content_type"` // text/plain, text/markdown or text/html
+	Size        int64     `bson:"size" json:"size"`                 // Upload size in bytes
+	Chunks      int       `bson:"chunks" json:"chunks"`             // Number of chunks stored in the vector store
+	Tokens      int       `bson:"tokens" json:"tokens"`             // Estimated tokens of the extracted text
+	CreatedAt   time.Time `bson:"created_at" json:"created_at"`     // When the document was uploaded
+}
@@ -6,24 +6,90 @@ import "time"
 
 // Conversation represents a chat session.
 type Conversation struct {
-	ID        string    `bson:"_id,omitempty"` // MongoDB auto-generates this field
-	UserID    string    `bson:"user_id"`       // ID of the user owning the conversation
-	Title     string    `bson:"title"`         // Conversation title
-	CreatedAt time.Time `bson:"created_at"`    // When the conversation was created
+	ID              string                `bson:"_id,omitempty"`               // MongoDB auto-generates this field
+	UserID          string                `bson:"user_id"`                     // ID of the user owning the conversation
+	Title           string                `bson:"title"`                       // Conversation title
+	CustomTitle     bool                  `bson:"custom_title,omitempty"`      // True once the user chose the title, so it is never generated
+	Settings        *ConversationSettings `bson:"settings,omitempty"`          // Model settings (nil = server defaults)
+	ActiveMessageID string                `bson:"active_message_id,omitempty"` // Leaf of the branch currently shown
+	Summary         *ConversationSummary  `bson:"summary,omitempty"`           // Condensed older turns (nil = not summarized yet)
+	Tags            []string              `bson:"tags,omitempty"`              // Labels chosen by the user
+	Pinned          bool                  `bson:"pinned,omitempty"`            // Marked by the user to keep at hand
+	Archived        bool                  `bson:"archived,omitempty"`          // Hidden from the conversation list by default
+	MessageCount    int                   `bson:"message_count,omitempty"`     // Messages stored in MongoDB, kept up to date when messages are written
+	LastMessage     string                `bson:"last_message,omitempty"`      // Start of the newest stored message
+	LastMessageAt   *time.Time            `bson:"last_message_at,omitempty"`   // When the newest stored message was created
+	CreatedAt       time.Time             `bson:"created_at"`                  // When the conversation was created
+	UpdatedAt       *time.Time            `bson:"updated_at,omitempty"`        // Last change of the conversation or its messages
+}
+
+// ConversationSettings holds the generation parameters used for a conversation.
+// Zero values fall back to the server defaults.
+type ConversationSettings struct {
+	Model        string   `bson:"model,omitempty" json:"model"`                 // Model name
+	Temperature  *float64 `bson:"temperature,omitempty" json:"temperature"`     // Sampling temperature (0-2)
+	TopP         *float64 `bson:"top_p,omitempty" json:"top_p"`                 // Nucleus sampling (0-1)
+	MaxTokens    int      `bson:"max_tokens,omitempty" json:"max_tokens"`       // Maximum tokens in the answer
+	Stop         []string `bson:"stop,omitempty" json:"stop"`                   // Stop sequences
+	SystemPrompt string   `bson:"system_prompt,omitempty" json:"system_prompt"` // System prompt for this conversation
+}
+
+// ConversationSummary condenses the turns of a branch up to and including one message.
+type ConversationSummary struct {
+	Content         string    `bson:"content" json:"content"`                   // Summary text sent to the model
+	CoveredUntilID  string    `bson:"covered_until_id" json:"covered_until_id"` // Last message folded into the summary
+	CoveredMessages int       `bson:"covered_messages" json:"covered_messages"` // Number of messages folded into the summary
+	Tokens          int       `bson:"tokens" json:"tokens"`                     // Estimated size of the summary
+	UpdatedAt       time.Time `bson:"updated_at" json:"updated_at"`             // When the summary was last extended
 }
 
 // Message represents a single message in a conversation.
 type Message struct {
-	ID             string    `bson:"_id,omitempty"`      // MongoDB auto-generates this field
-	MessageID      string    `bson:"message_id"`         // Unique ID for the message
-	UserID         string    `bson:"user_id"`            // ID of the user owning the conversation
-	ConversationID string    `bson:"conversation_id"`    // ID of the related conversation
-	Title          string    `bson:"title"`              // Title of the conversation (optional)
-	Question       string    `bson:"question"`           // User's question
-	Answer         string    `bson:"answer"`             // AI's response
-	Feedback       *string   `bson:"feedback,omitempty"` // Feedback provided by the user (default null)
-	ThumbUp        int       `bson:"thumbup"`            // Thumb feedback (-1, 0, 1)
-	InputURL       string    `bson:"input_url"`          // URL for input data (if any)
-	OutputURL      string    `bson:"output_url"`         // URL for output data (if any)
-	CreatedAt      time.Time `bson:"created_at"`         // When the message was created
+	ID              string            `bson:"_id,omitempty"`               // MongoDB auto-generates this field
+	MessageID       string            `bson:"message_id"`                  // Unique ID for the message
+	UserID          string            `bson:"user_id"`                     // ID of the user owning the conversation
+	ConversationID  string            `bson:"conversation_id"`             // ID of the related conversation
+	ParentMessageID *string           `bson:"parent_message_id,omitempty"` // Previous message in the branch ("" = first, nil = legacy linear history)
+	VersionIndex    int               `bson:"version_index"`               // Position among messages sharing the same parent
+	Title           string            `bson:"title"`                       // Title of the conversation (optional)
+	Question        string            `bson:"question"`                    // User's question
+	Answer          string            `bson:"answer"`                      // AI's response
+	Feedback        *string           `bson:"feedback,omitempty"`          // Feedback provided by the user (default null)
+	ThumbUp         int               `bson:"thumbup"`                     // Thumb feedback (-1, 0, 1)
+	InputURL        string            `bson:"input_url"`                   // URL for input data (if any)
+	OutputURL       string            `bson:"output_url"`                  // URL for output data (if any)
+	Attachments     []Attachment      `bson:"attachments,omitempty"`       // Files sent with the question, InputURL is the first one
+	Status          string            `bson:"status,omitempty"`            // Generation status (completed, cancelled, failed)
+	Usage           *Usage            `bson:"usage,omitempty"`             // Token usage of the answer
+	ToolInvocations []ToolInvocation  `bson:"tool_invocations,omitempty"`  // Tools the model called for this answer
+	DroppedTurns    []string          `bson:"dropped_turns,omitempty"`     // Earlier messages left out of the prompt to fit the context budget
+	Metadata        map[string]string `bson:"metadata,omitempty"`          // Data the client sent with the question
+	IndexedAt       *time.Time        `bson:"indexed_at,omitempty"`        // When the message was added to the search index
+	CreatedAt       time.Time         `bson:"created_at"`                  // When the message was created
 }
+
+// Usage is the token count of one LLM request.
+type Usage struct {
+	Model            string `bson:"model" json:"model"`                         // Model that served the request
+	PromptTokens     int    `bson:"prompt_tokens" json:"prompt_tokens"`         // Tokens sent to the model
+	CompletionTokens int    `bson:"completion_tokens" json:"completion_tokens"` // Tokens generated by the model
+	TotalTokens      int    `bson:"total_tokens" json:"total_tokens"`           // Prompt + completion
+	Estimated        bool   `bson:"estimated" json:"estimated"`                 // True when the provider did not report usage
+}
+
+// ToolInvocation records one tool call made by the model while answering.
+type ToolInvocation struct {
+	CallID    string    `bson:"call_id" json:"call_id"`                 // ID assigned by the model
+	Name      string    `bson:"name" json:"name"`                       // Tool name
+	Arguments string    `bson:"arguments" json:"arguments"`             // JSON arguments from the model
+	Result    string    `bson:"result" json:"result"`                   // Result sent back to the model
+	Error     string    `bson:"error,omitempty" json:"error,omitempty"` // Set when the tool failed
+	CreatedAt time.Time `bson:"created_at" json:"created_at"`           // When the tool ran
+}
+
+// Message generation statuses
+const (
+	MessageStatusCompleted = "completed"
+	MessageStatusCancelled = "cancelled"
+	MessageStatusFailed    = "failed"
+)
@@ -5,12 +5,18 @@ package models
 import "time"
 
 type User struct {
-	ID           string    `bson:"_id,omitempty"` // MongoDB generates a unique ObjectID
-	Username     string    `bson:"username"`      // Ensure unique index on this field
-	Password     string    `bson:"password"`      // Store hashed password
-	Email        string    `bson:"email"`
-	LastLogin    time.Time `bson:"last_login"`
-	RegisteredAt time.Time `bson:"registered_at"`
+	ID           string          `bson:"_id,omitempty"` // MongoDB generates a unique ObjectID
+	Username     string          `bson:"username"`      // Ensure unique index on this field
+	Password     string          `bson:"password"`      // Store hashed password
+	Email        string          `bson:"email"`
+	LastLogin    time.Time       `bson:"last_login"`
+	RegisteredAt time.Time       `bson:"registered_at"`
+	Preferences  UserPreferences `bson:"preferences,omitempty"`
+}
+
+// UserPreferences are per-user switches. Nil values fall back to the server defaults.
+type UserPreferences struct {
+	AutoTitle *bool `bson:"auto_title,omitempty" json:"auto_title"` // Generate conversation titles after the first answer
 }
 
 type UserInput struct {
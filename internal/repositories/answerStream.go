@@ -0,0 +1,114 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// AnswerStreamEntry is one chunk of a streamed answer with its sequence number
+type AnswerStreamEntry struct {
+	Seq  int64
+	Data string
+}
+
+// AnswerStreamRepository keeps the chunks of answers in Redis Streams, one stream per message.
+// Entry IDs are "<seq>-0" so a client can read everything after the last sequence number it saw.
+type AnswerStreamRepository struct {
+	RedisChatDB *redis.Client
+}
+
+// Constructor
+func NewAnswerStreamRepository(redisClient *redis.Client) *AnswerStreamRepository {
+	return &AnswerStreamRepository{RedisChatDB: redisClient}
+}
+
+func answerStreamKey(messageID string) string {
+	return "answer_stream:" + messageID
+}
+
+func answerCancelKey(messageID string) string {
+	return "answer_stream:" + messageID + ":cancel"
+}
+
+// Append adds a chunk and keeps the stream alive for ttl
+func (r *AnswerStreamRepository) Append(ctx context.Context, messageID string, seq int64, data string, ttl time.Duration) error {
+	key := answerStreamKey(messageID)
+	pipe := r.RedisChatDB.TxPipeline()
+	pipe.XAdd(ctx, &redis.XAddArgs{
+		Stream: key,
+		ID:     fmt.Sprintf("%d-0", seq),
+		Values: map[string]interface{}{"data": data},
+	})
+	pipe.Expire(ctx, key, ttl)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// Read returns the chunks after afterSeq, waiting up to block for new ones. An empty result means none arrived.
+func (r *AnswerStreamRepository) Read(ctx context.Context, messageID string, afterSeq int64, count int64, block time.Duration) ([]AnswerStreamEntry, error) {
+	streams, err := r.RedisChatDB.XRead(ctx, &redis.XReadArgs{
+		Streams: []string{answerStreamKey(messageID), fmt.Sprintf("%d-0", afterSeq)},
+		Count:   count,
+		Block:   block,
+	}).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []AnswerStreamEntry
+	for _, stream := range streams {
+		for _, msg := range stream.Messages {
+			entry, err := toAnswerStreamEntry(msg)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+// First returns the first chunk of a stream, or nil if the stream does not exist (any more)
+func (r *AnswerStreamRepository) First(ctx context.Context, messageID string) (*AnswerStreamEntry, error) {
+	msgs, err := r.RedisChatDB.XRangeN(ctx, answerStreamKey(messageID), "-", "+", 1).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(msgs) == 0 {
+		return nil, nil
+	}
+	entry, err := toAnswerStreamEntry(msgs[0])
+	if err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// RequestCancel asks the instance generating the answer to stop it
+func (r *AnswerStreamRepository) RequestCancel(ctx context.Context, messageID string, ttl time.Duration) error {
+	return r.RedisChatDB.Set(ctx, answerCancelKey(messageID), 1, ttl).Err()
+}
+
+// CancelRequested reports whether RequestCancel was called for the answer
+func (r *AnswerStreamRepository) CancelRequested(ctx context.Context, messageID string) (bool, error) {
+	n, err := r.RedisChatDB.Exists(ctx, answerCancelKey(messageID)).Result()
+	return n > 0, err
+}
+
+func toAnswerStreamEntry(msg redis.XMessage) (AnswerStreamEntry, error) {
+	seqPart, _, _ := strings.Cut(msg.ID, "-")
+	seq, err := strconv.ParseInt(seqPart, 10, 64)
+	if err != nil {
+		return AnswerStreamEntry{}, fmt.Errorf("unexpected answer stream entry ID %q", msg.ID)
+	}
+	data, _ := msg.Values["data"].(string)
+	return AnswerStreamEntry{Seq: seq, Data: data}, nil
+}
@@ -0,0 +1,119 @@
+package repositories
+
+import (
+	"chat-ai-backend/internal/models"
+	"chat-ai-backend/utils"
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrAPIKeyNotFound is returned when no API key matches
+var ErrAPIKeyNotFound = errors.New("api key not found")
+
+type APIKeyRepository struct {
+	MongoAPIKeyCol *mongo.Collection
+}
+
+func NewAPIKeyRepository(mongoAPIKeyCol *mongo.Collection) *APIKeyRepository {
+	return &APIKeyRepository{MongoAPIKeyCol: mongoAPIKeyCol}
+}
+
+// SaveAPIKey stores a new API key and returns its ID.
+func (r *APIKeyRepository) SaveAPIKey(key models.APIKey) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	doc := bson.M{
+		"user_id":    key.UserID,
+		"name":       key.Name,
+		"prefix":     key.Prefix,
+		"hash":       key.Hash,
+		"created_at": key.CreatedAt,
+	}
+	res, err := r.MongoAPIKeyCol.InsertOne(ctx, doc)
+	if err != nil {
+		utils.Logger.Error("Failed to save API key: %v", err)
+		return "", err
+	}
+	objectID, ok := res.InsertedID.(primitive.ObjectID)
+	if !ok {
+		return "", errors.New("failed to convert inserted ID to ObjectID")
+	}
+	return objectID.Hex(), nil
+}
+
+// FindAPIKeyByHash returns the key with the given hash.
+func (r *APIKeyRepository) FindAPIKeyByHash(hash string) (*models.APIKey, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var key models.APIKey
+	if err := r.MongoAPIKeyCol.FindOne(ctx, bson.M{"hash": hash}).Decode(&key); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrAPIKeyNotFound
+		}
+		return nil, err
+	}
+	return &key, nil
+}
+
+// ListAPIKeys returns the keys of a user, newest first.
+func (r *APIKeyRepository) ListAPIKeys(userID string) ([]models.APIKey, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}})
+	cursor, err := r.MongoAPIKeyCol.Find(ctx, bson.M{"user_id": userID}, opts)
+	if err != nil {
+		utils.Logger.Error("Failed to list API keys of user %s: %v", userID, err)
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	keys := []models.APIKey{}
+	if err := cursor.All(ctx, &keys); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// TouchAPIKey records when a key was last used.
+func (r *APIKeyRepository) TouchAPIKey(keyID string, usedAt time.Time) error {
+	objectID, err := primitive.ObjectIDFromHex(keyID)
+	if err != nil {
+		return ErrAPIKeyNotFound
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err = r.MongoAPIKeyCol.UpdateOne(ctx, bson.M{"_id": objectID}, bson.M{"$set": bson.M{"last_used_at": usedAt}})
+	return err
+}
+
+// DeleteAPIKey revokes a key of the user.
+func (r *APIKeyRepository) DeleteAPIKey(userID, keyID string) error {
+	objectID, err := primitive.ObjectIDFromHex(keyID)
+	if err != nil {
+		return ErrAPIKeyNotFound
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := r.MongoAPIKeyCol.DeleteOne(ctx, bson.M{"_id": objectID, "user_id": userID})
+	if err != nil {
+		utils.Logger.Error("Failed to delete API key %s: %v", keyID, err)
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrAPIKeyNotFound
+	}
+	return nil
+}
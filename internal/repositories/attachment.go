@@ -0,0 +1,160 @@
+package repositories
+
+import (
+	"chat-ai-backend/internal/models"
+	"chat-ai-backend/utils"
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrAttachmentNotFound is returned when no attachment of the user matches the given ID
+var ErrAttachmentNotFound = errors.New("attachment not found")
+
+type AttachmentRepository struct {
+	MongoAttachmentCol *mongo.Collection
+}
+
+func NewAttachmentRepository(mongoAttachmentCol *mongo.Collection) *AttachmentRepository {
+	return &AttachmentRepository{MongoAttachmentCol: mongoAttachmentCol}
+}
+
+// NewAttachmentID reserves an ID so the blob can be stored under it before the metadata is saved.
+func (r *AttachmentRepository) NewAttachmentID() string {
+	return primitive.NewObjectID().Hex()
+}
+
+// SaveAttachment stores the metadata of an uploaded attachment under attachment.ID.
+func (r *AttachmentRepository) SaveAttachment(attachment models.Attachment) error {
+	objectID, err := primitive.ObjectIDFromHex(attachment.ID)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	doc := bson.M{
+		"_id":          objectID,
+		"user_id":      attachment.UserID,
+		"filename":     attachment.Filename,
+		"content_type": attachment.ContentType,
+		"size":         attachment.Size,
+		"storage_key":  attachment.StorageKey,
+		"url":          attachment.URL,
+		"created_at":   attachment.CreatedAt,
+	}
+	if _, err := r.MongoAttachmentCol.InsertOne(ctx, doc); err != nil {
+		utils.Logger.Error("Failed to save attachment: %v", err)
+		return err
+	}
+	return nil
+}
+
+// GetAttachment returns an attachment if it belongs to the user.
+func (r *AttachmentRepository) GetAttachment(userID, attachmentID string) (*models.Attachment, error) {
+	objectID, err := primitive.ObjectIDFromHex(attachmentID)
+	if err != nil {
+		return nil, ErrAttachmentNotFound
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var attachment models.Attachment
+	if err := r.MongoAttachmentCol.FindOne(ctx, bson.M{"_id": objectID, "user_id": userID}).Decode(&attachment); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrAttachmentNotFound
+		}
+		return nil, err
+	}
+	return &attachment, nil
+}
+
+// GetAttachments returns the attachments of the user with the given IDs in the order of the IDs.
+// ErrAttachmentNotFound is returned if any of them is missing.
+func (r *AttachmentRepository) GetAttachments(userID string, attachmentIDs []string) ([]models.Attachment, error) {
+	objectIDs := make([]primitive.ObjectID, 0, len(attachmentIDs))
+	for _, id := range attachmentIDs {
+		objectID, err := primitive.ObjectIDFromHex(id)
+		if err != nil {
+			return nil, ErrAttachmentNotFound
+		}
+		objectIDs = append(objectIDs, objectID)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cursor, err := r.MongoAttachmentCol.Find(ctx, bson.M{"_id": bson.M{"$in": objectIDs}, "user_id": userID})
+	if err != nil {
+		utils.Logger.Error("Failed to load attachments of user %s: %v", userID, err)
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var found []models.Attachment
+	if err := cursor.All(ctx, &found); err != nil {
+		return nil, err
+	}
+	byID := make(map[string]models.Attachment, len(found))
+	for _, attachment := range found {
+		byID[attachment.ID] = attachment
+	}
+
+	attachments := make([]models.Attachment, 0, len(attachmentIDs))
+	for _, id := range attachmentIDs {
+		attachment, ok := byID[id]
+		if !ok {
+			return nil, ErrAttachmentNotFound
+		}
+		attachments = append(attachments, attachment)
+	}
+	return attachments, nil
+}
+
+// ListAttachments returns the attachments of a user, newest first.
+func (r *AttachmentRepository) ListAttachments(userID string) ([]models.Attachment, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}})
+	cursor, err := r.MongoAttachmentCol.Find(ctx, bson.M{"user_id": userID}, opts)
+	if err != nil {
+		utils.Logger.Error("Failed to list attachments of user %s: %v", userID, err)
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	attachments := []models.Attachment{}
+	if err := cursor.All(ctx, &attachments); err != nil {
+		return nil, err
+	}
+	return attachments, nil
+}
+
+// DeleteAttachment removes the metadata of an attachment of the user.
+func (r *AttachmentRepository) DeleteAttachment(userID, attachmentID string) error {
+	objectID, err := primitive.ObjectIDFromHex(attachmentID)
+	if err != nil {
+		return ErrAttachmentNotFound
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := r.MongoAttachmentCol.DeleteOne(ctx, bson.M{"_id": objectID, "user_id": userID})
+	if err != nil {
+		utils.Logger.Error("Failed to delete attachment %s: %v", attachmentID, err)
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrAttachmentNotFound
+	}
+	return nil
+}
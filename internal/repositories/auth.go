@@ -10,6 +10,7 @@ import (
 
 	"github.com/go-redis/redis/v8"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
@@ -140,3 +141,42 @@ func (r *UserRepository) CheckTokenInRedis(ctx context.Context, email, token str
 	}
 	return storedToken == token, nil
 }
+
+// GetPreferences retrieves the preferences of a user by ID.
+func (r *UserRepository) GetPreferences(ctx context.Context, userID string) (*models.UserPreferences, error) {
+	objectID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, errors.New("user not found")
+	}
+
+	var user models.User
+	opts := options.FindOne().SetProjection(bson.M{"preferences": 1})
+	if err := r.Collection.FindOne(ctx, bson.M{"_id": objectID}, opts).Decode(&user); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errors.New("user not found")
+		}
+		return nil, err
+	}
+	return &user.Preferences, nil
+}
+
+// UpdatePreferences replaces the preferences of a user.
+func (r *UserRepository) UpdatePreferences(ctx context.Context, userID string, prefs models.UserPreferences) error {
+	objectID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return errors.New("user not found")
+	}
+
+	result, err := r.Collection.UpdateOne(
+		ctx,
+		bson.M{"_id": objectID},
+		bson.M{"$set": bson.M{"preferences": prefs}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return errors.New("user not found")
+	}
+	return nil
+}
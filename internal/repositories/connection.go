@@ -0,0 +1,125 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+var (
+	// ErrUserConnectionLimit is returned when the user already holds the maximum number of connections
+	ErrUserConnectionLimit = errors.New("too many connections for user")
+	// ErrConnectionLimit is returned when all instances together hold the maximum number of connections
+	ErrConnectionLimit = errors.New("too many connections")
+)
+
+// All open connections, shared by every instance of the server
+const allConnectionsKey = "ws_connections:all"
+
+// ConnectionRepository tracks open WebSocket connections in Redis sorted sets scored by lease expiry,
+// so connections of a crashed instance stop counting once their lease runs out
+type ConnectionRepository struct {
+	RedisChatDB *redis.Client
+}
+
+// Constructor
+func NewConnectionRepository(redisClient *redis.Client) *ConnectionRepository {
+	return &ConnectionRepository{RedisChatDB: redisClient}
+}
+
+func userConnectionsKey(userID string) string {
+	return "ws_connections:user:" + userID
+}
+
+// conversationConnectionsKey holds the connections following a conversation, on any instance
+func conversationConnectionsKey(conversationID string) string {
+	return "ws_connections:conversation:" + conversationID
+}
+
+// Drops expired leases, then registers the connection unless a limit is reached.
+// KEYS: user set, global set. ARGV: now ms, lease expiry ms, connection ID, user limit, global limit, lease ms.
+var acquireConnectionScript = redis.NewScript(`
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', ARGV[1])
+redis.call('ZREMRANGEBYSCORE', KEYS[2], '-inf', ARGV[1])
+if tonumber(ARGV[4]) > 0 and redis.call('ZCARD', KEYS[1]) >= tonumber(ARGV[4]) then
+	return 1
+end
+if tonumber(ARGV[5]) > 0 and redis.call('ZCARD', KEYS[2]) >= tonumber(ARGV[5]) then
+	return 2
+end
+redis.call('ZADD', KEYS[1], ARGV[2], ARGV[3])
+redis.call('ZADD', KEYS[2], ARGV[2], ARGV[3])
+redis.call('PEXPIRE', KEYS[1], ARGV[6])
+return 0
+`)
+
+// AcquireConnection registers a connection for lease. A limit of 0 is unlimited.
+func (r *ConnectionRepository) AcquireConnection(ctx context.Context, userID, connectionID string, lease time.Duration, maxPerUser, maxTotal int) error {
+	now := time.Now()
+	result, err := acquireConnectionScript.Run(ctx, r.RedisChatDB,
+		[]string{userConnectionsKey(userID), allConnectionsKey},
+		now.UnixMilli(),
+		now.Add(lease).UnixMilli(),
+		connectionID,
+		maxPerUser,
+		maxTotal,
+		lease.Milliseconds(),
+	).Int()
+	if err != nil {
+		return err
+	}
+	switch result {
+	case 1:
+		return ErrUserConnectionLimit
+	case 2:
+		return ErrConnectionLimit
+	}
+	return nil
+}
+
+// RefreshConnection extends the lease of a registered connection and of its place in the conversation
+func (r *ConnectionRepository) RefreshConnection(ctx context.Context, userID, conversationID, connectionID string, lease time.Duration) error {
+	expiry := &redis.Z{Score: float64(time.Now().Add(lease).UnixMilli()), Member: connectionID}
+	key := userConnectionsKey(userID)
+	conversationKey := conversationConnectionsKey(conversationID)
+	pipe := r.RedisChatDB.TxPipeline()
+	pipe.ZAddXX(ctx, key, expiry)
+	pipe.ZAddXX(ctx, allConnectionsKey, expiry)
+	pipe.PExpire(ctx, key, lease)
+	pipe.ZAddXX(ctx, conversationKey, expiry)
+	pipe.PExpire(ctx, conversationKey, lease)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// JoinConversation records that a connection follows a conversation until its lease runs out
+func (r *ConnectionRepository) JoinConversation(ctx context.Context, conversationID, connectionID string, lease time.Duration) error {
+	key := conversationConnectionsKey(conversationID)
+	pipe := r.RedisChatDB.TxPipeline()
+	pipe.ZAdd(ctx, key, &redis.Z{Score: float64(time.Now().Add(lease).UnixMilli()), Member: connectionID})
+	pipe.PExpire(ctx, key, lease)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// LeaveConversation removes a connection from the followers of a conversation
+func (r *ConnectionRepository) LeaveConversation(ctx context.Context, conversationID, connectionID string) error {
+	return r.RedisChatDB.ZRem(ctx, conversationConnectionsKey(conversationID), connectionID).Err()
+}
+
+// CountConversationConnections returns how many connections with a live lease follow a conversation
+func (r *ConnectionRepository) CountConversationConnections(ctx context.Context, conversationID string) (int64, error) {
+	return r.RedisChatDB.ZCount(ctx, conversationConnectionsKey(conversationID), strconv.FormatInt(time.Now().UnixMilli(), 10), "+inf").Result()
+}
+
+// ReleaseConnection removes a connection
+func (r *ConnectionRepository) ReleaseConnection(ctx context.Context, userID, connectionID string) error {
+	pipe := r.RedisChatDB.TxPipeline()
+	pipe.ZRem(ctx, userConnectionsKey(userID), connectionID)
+	pipe.ZRem(ctx, allConnectionsKey, connectionID)
+	_, err := pipe.Exec(ctx)
+	return err
+}
@@ -6,14 +6,50 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"regexp"
+	"strings"
 	"time"
 
 	"github.com/go-redis/redis/v8"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// ErrConversationNotFound is returned when no conversation matches the given ID
+var ErrConversationNotFound = errors.New("conversation not found")
+
+// ErrCustomTitle is returned when a generated title would replace one set by the user
+var ErrCustomTitle = errors.New("conversation has a custom title")
+
+// Fields the conversation list can be sorted by
+const (
+	ConversationSortCreated     = "created_at"
+	ConversationSortUpdated     = "updated_at"
+	ConversationSortLastMessage = "last_message_at"
+)
+
+// Length of the last message preview stored on a conversation
+const lastMessagePreviewRunes = 120
+
+// ConversationFilter narrows the conversations listed for a user; zero values match everything
+type ConversationFilter struct {
+	Title    string     // Case-insensitive part of the title
+	Tag      string     // One of the tags
+	Archived *bool      // nil lists archived and other conversations alike
+	Pinned   *bool      // nil lists pinned and other conversations alike
+	From     *time.Time // Earliest value of the sort field
+	To       *time.Time // Latest value of the sort field
+}
+
+// ConversationCursor is the position of a conversation in the list. A nil Value stands for
+// conversations without the sort field, which MongoDB sorts as the oldest.
+type ConversationCursor struct {
+	Value *time.Time
+	ID    string
+}
+
 type ConversationRepository struct {
 	MongoConvoCol *mongo.Collection
 	MongoMsgCol   *mongo.Collection
@@ -68,7 +104,15 @@ func (r *ConversationRepository) DeleteConversation(convoID string) error {
 	// Step 1: Delete from Redis
 	redisKey := fmt.Sprintf("messages:%s", convoID)
 	if exists, _ := r.RedisClient.Exists(ctx, redisKey).Result(); exists > 0 {
-		if _, err := r.RedisClient.Del(ctx, redisKey).Result(); err != nil {
+		messagesJSON, err := r.RedisClient.LRange(ctx, redisKey, 0, -1).Result()
+		if err != nil {
+			utils.Logger.Error("Failed to fetch messages from Redis: %v", err)
+			return err
+		}
+		pipe := r.RedisClient.TxPipeline()
+		pipe.Del(ctx, redisKey)
+		unindexMessages(ctx, pipe, decodeMessages(messagesJSON))
+		if _, err := pipe.Exec(ctx); err != nil {
 			utils.Logger.Error("Failed to delete Redis key: %v", err)
 			return err
 		}
@@ -95,8 +139,121 @@ func (r *ConversationRepository) DeleteConversation(convoID string) error {
 	return nil
 }
 
-// UpdateConversationTitle updates the title of a conversation.
-func (r *ConversationRepository) UpdateConversationTitle(convoID, title string) error {
+// UpdateConversationTitle updates the title of a conversation. A generated title (custom false) never
+// replaces one chosen by the user and returns ErrCustomTitle instead.
+func (r *ConversationRepository) UpdateConversationTitle(convoID, title string, custom bool) error {
+	if r.MongoConvoCol == nil {
+		return errors.New("conversation collection is not initialized")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	objectID, err := primitive.ObjectIDFromHex(convoID)
+	if err != nil {
+		return fmt.Errorf("invalid ObjectID: %w", err)
+	}
+
+	filter := bson.M{"_id": objectID}
+	update := bson.M{"title": title, "updated_at": time.Now()}
+	if custom {
+		update["custom_title"] = true
+	} else {
+		filter["custom_title"] = bson.M{"$ne": true}
+	}
+
+	result, err := r.MongoConvoCol.UpdateOne(ctx, filter, bson.M{"$set": update})
+	if err != nil {
+		utils.Logger.Error("Failed to update title for %s: %v", convoID, err)
+		return err
+	}
+	if !custom && result.MatchedCount == 0 {
+		return ErrCustomTitle
+	}
+
+	utils.Logger.Info("Updated title for conversation %s to %s", convoID, title)
+	return nil
+}
+
+// GetConversationByID retrieves a conversation from MongoDB.
+func (r *ConversationRepository) GetConversationByID(convoID string) (*models.Conversation, error) {
+	if r.MongoConvoCol == nil {
+		return nil, errors.New("conversation collection is not initialized")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	objectID, err := primitive.ObjectIDFromHex(convoID)
+	if err != nil {
+		return nil, ErrConversationNotFound // a malformed ID cannot match any conversation
+	}
+
+	var convo models.Conversation
+	if err := r.MongoConvoCol.FindOne(ctx, bson.M{"_id": objectID}).Decode(&convo); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrConversationNotFound
+		}
+		utils.Logger.Error("Failed to find conversation %s: %v", convoID, err)
+		return nil, err
+	}
+	return &convo, nil
+}
+
+// FindConversationByTitle returns the newest conversation of the user with the given title.
+func (r *ConversationRepository) FindConversationByTitle(userID, title string) (*models.Conversation, error) {
+	if r.MongoConvoCol == nil {
+		return nil, errors.New("conversation collection is not initialized")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	opts := options.FindOne().SetSort(bson.D{{Key: "created_at", Value: -1}})
+	var convo models.Conversation
+	if err := r.MongoConvoCol.FindOne(ctx, bson.M{"user_id": userID, "title": title}, opts).Decode(&convo); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrConversationNotFound
+		}
+		utils.Logger.Error("Failed to find conversation %q of user %s: %v", title, userID, err)
+		return nil, err
+	}
+	return &convo, nil
+}
+
+// UpdateConversationSettings replaces the settings sub-document of a conversation.
+func (r *ConversationRepository) UpdateConversationSettings(convoID string, settings models.ConversationSettings) error {
+	if r.MongoConvoCol == nil {
+		return errors.New("conversation collection is not initialized")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	objectID, err := primitive.ObjectIDFromHex(convoID)
+	if err != nil {
+		return fmt.Errorf("invalid ObjectID: %w", err)
+	}
+
+	result, err := r.MongoConvoCol.UpdateOne(
+		ctx,
+		bson.M{"_id": objectID},
+		bson.M{"$set": bson.M{"settings": settings, "updated_at": time.Now()}},
+	)
+	if err != nil {
+		utils.Logger.Error("Failed to update settings for %s: %v", convoID, err)
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrConversationNotFound
+	}
+
+	utils.Logger.Info("Updated settings for conversation %s", convoID)
+	return nil
+}
+
+// UpdateActiveMessage stores which branch leaf the conversation currently shows.
+func (r *ConversationRepository) UpdateActiveMessage(convoID, messageID string) error {
 	if r.MongoConvoCol == nil {
 		return errors.New("conversation collection is not initialized")
 	}
@@ -112,13 +269,248 @@ func (r *ConversationRepository) UpdateConversationTitle(convoID, title string)
 	_, err = r.MongoConvoCol.UpdateOne(
 		ctx,
 		bson.M{"_id": objectID},
-		bson.M{"$set": bson.M{"title": title}},
+		bson.M{"$set": bson.M{"active_message_id": messageID}},
 	)
 	if err != nil {
-		utils.Logger.Error("Failed to update title for %s: %v", convoID, err)
+		utils.Logger.Error("Failed to update active message for %s: %v", convoID, err)
 		return err
 	}
+	return nil
+}
 
-	utils.Logger.Info("Updated title for conversation %s to %s", convoID, title)
+// UpdateConversationSummary replaces the rolling summary of a conversation.
+func (r *ConversationRepository) UpdateConversationSummary(convoID string, summary models.ConversationSummary) error {
+	if r.MongoConvoCol == nil {
+		return errors.New("conversation collection is not initialized")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	objectID, err := primitive.ObjectIDFromHex(convoID)
+	if err != nil {
+		return fmt.Errorf("invalid ObjectID: %w", err)
+	}
+
+	result, err := r.MongoConvoCol.UpdateOne(
+		ctx,
+		bson.M{"_id": objectID},
+		bson.M{"$set": bson.M{"summary": summary}},
+	)
+	if err != nil {
+		utils.Logger.Error("Failed to update summary for %s: %v", convoID, err)
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrConversationNotFound
+	}
+
+	utils.Logger.Info("Updated summary for conversation %s up to message %s", convoID, summary.CoveredUntilID)
 	return nil
 }
+
+// ListConversations returns up to limit conversations of the user after the cursor (nil for the first
+// page) ordered by sortField, and whether more follow.
+func (r *ConversationRepository) ListConversations(userID, sortField string, ascending bool, filter ConversationFilter, after *ConversationCursor, limit int) ([]models.Conversation, bool, error) {
+	if r.MongoConvoCol == nil {
+		return nil, false, errors.New("conversation collection is not initialized")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conditions := []bson.M{{"user_id": userID}}
+	if filter.Title != "" {
+		conditions = append(conditions, bson.M{"title": bson.M{"$regex": regexp.QuoteMeta(filter.Title), "$options": "i"}})
+	}
+	if filter.Tag != "" {
+		conditions = append(conditions, bson.M{"tags": filter.Tag})
+	}
+	if filter.Archived != nil {
+		conditions = append(conditions, flagCondition("archived", *filter.Archived))
+	}
+	if filter.Pinned != nil {
+		conditions = append(conditions, flagCondition("pinned", *filter.Pinned))
+	}
+	if filter.From != nil {
+		conditions = append(conditions, bson.M{sortField: bson.M{"$gte": *filter.From}})
+	}
+	if filter.To != nil {
+		conditions = append(conditions, bson.M{sortField: bson.M{"$lte": *filter.To}})
+	}
+	if after != nil {
+		objectID, err := primitive.ObjectIDFromHex(after.ID)
+		if err != nil {
+			return nil, false, fmt.Errorf("invalid ObjectID: %w", err)
+		}
+		conditions = append(conditions, afterCondition(sortField, ascending, after.Value, objectID))
+	}
+
+	direction := -1
+	if ascending {
+		direction = 1
+	}
+	opts := options.Find().
+		SetSort(bson.D{{Key: sortField, Value: direction}, {Key: "_id", Value: direction}}).
+		SetLimit(int64(limit + 1)).
+		SetProjection(bson.M{"summary": 0})
+	cursor, err := r.MongoConvoCol.Find(ctx, bson.M{"$and": conditions}, opts)
+	if err != nil {
+		utils.Logger.Error("Failed to list conversations of user %s: %v", userID, err)
+		return nil, false, err
+	}
+	defer cursor.Close(ctx)
+
+	var convos []models.Conversation
+	if err := cursor.All(ctx, &convos); err != nil {
+		return nil, false, err
+	}
+	hasMore := len(convos) > limit
+	if hasMore {
+		convos = convos[:limit]
+	}
+	return convos, hasMore, nil
+}
+
+// flagCondition matches set flags, or flags that are unset or missing
+func flagCondition(field string, set bool) bson.M {
+	if set {
+		return bson.M{field: true}
+	}
+	return bson.M{field: bson.M{"$ne": true}}
+}
+
+// afterCondition matches the conversations after a cursor. Missing sort values come last in
+// descending order and first in ascending order.
+func afterCondition(field string, ascending bool, value *time.Time, id primitive.ObjectID) bson.M {
+	compare, idCompare := "$lt", "$lt"
+	if ascending {
+		compare, idCompare = "$gt", "$gt"
+	}
+	switch {
+	case value == nil && ascending:
+		return bson.M{"$or": []bson.M{
+			{field: nil, "_id": bson.M{idCompare: id}},
+			{field: bson.M{"$ne": nil}},
+		}}
+	case value == nil:
+		return bson.M{field: nil, "_id": bson.M{idCompare: id}}
+	case ascending:
+		return bson.M{"$or": []bson.M{
+			{field: bson.M{compare: *value}},
+			{field: *value, "_id": bson.M{idCompare: id}},
+		}}
+	default:
+		return bson.M{"$or": []bson.M{
+			{field: bson.M{compare: *value}},
+			{field: *value, "_id": bson.M{idCompare: id}},
+			{field: nil},
+		}}
+	}
+}
+
+// UpdateConversationOrganization changes the tags, pinned and archived state of a conversation; nil
+// values are left alone.
+func (r *ConversationRepository) UpdateConversationOrganization(convoID string, tags *[]string, pinned, archived *bool) error {
+	if r.MongoConvoCol == nil {
+		return errors.New("conversation collection is not initialized")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	objectID, err := primitive.ObjectIDFromHex(convoID)
+	if err != nil {
+		return ErrConversationNotFound
+	}
+
+	update := bson.M{"updated_at": time.Now()}
+	if tags != nil {
+		update["tags"] = *tags
+	}
+	if pinned != nil {
+		update["pinned"] = *pinned
+	}
+	if archived != nil {
+		update["archived"] = *archived
+	}
+	result, err := r.MongoConvoCol.UpdateOne(ctx, bson.M{"_id": objectID}, bson.M{"$set": update})
+	if err != nil {
+		utils.Logger.Error("Failed to update conversation %s: %v", convoID, err)
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrConversationNotFound
+	}
+	return nil
+}
+
+// refreshConversationStats stores the message count and the newest message of a conversation on it.
+// cached are the messages still held in Redis; those not written to MongoDB yet are counted too.
+func refreshConversationStats(ctx context.Context, convoCol, msgCol *mongo.Collection, conversationID string, cached []models.Message) error {
+	if convoCol == nil || msgCol == nil {
+		return errors.New("MongoDB collections are not initialized")
+	}
+	objectID, err := primitive.ObjectIDFromHex(conversationID)
+	if err != nil {
+		return fmt.Errorf("invalid ObjectID: %w", err)
+	}
+
+	count, err := msgCol.CountDocuments(ctx, bson.M{"conversation_id": conversationID})
+	if err != nil {
+		return err
+	}
+	if len(cached) > 0 {
+		ids := make([]string, 0, len(cached))
+		seen := make(map[string]bool, len(cached))
+		for _, msg := range cached {
+			if !seen[msg.MessageID] {
+				seen[msg.MessageID] = true
+				ids = append(ids, msg.MessageID)
+			}
+		}
+		stored, err := msgCol.CountDocuments(ctx, bson.M{"conversation_id": conversationID, "message_id": bson.M{"$in": ids}})
+		if err != nil {
+			return err
+		}
+		count += int64(len(ids)) - stored
+	}
+	update := bson.M{"message_count": count, "updated_at": time.Now()}
+
+	var last models.Message
+	found := false
+	opts := options.FindOne().SetSort(bson.D{{Key: "created_at", Value: -1}, {Key: "message_id", Value: -1}})
+	err = msgCol.FindOne(ctx, bson.M{"conversation_id": conversationID}, opts).Decode(&last)
+	switch {
+	case err == nil:
+		found = true
+	case err != mongo.ErrNoDocuments:
+		return err
+	}
+	for _, msg := range cached {
+		newer := msg.CreatedAt.After(last.CreatedAt) || msg.CreatedAt.Equal(last.CreatedAt) && msg.MessageID > last.MessageID
+		if !found || newer {
+			last, found = msg, true
+		}
+	}
+	if found {
+		update["last_message"] = messagePreview(last)
+		update["last_message_at"] = last.CreatedAt
+	}
+
+	_, err = convoCol.UpdateOne(ctx, bson.M{"_id": objectID}, bson.M{"$set": update})
+	return err
+}
+
+// messagePreview is the start of a message's answer, or of its question while it has none
+func messagePreview(msg models.Message) string {
+	text := msg.Answer
+	if strings.TrimSpace(text) == "" {
+		text = msg.Question
+	}
+	runes := []rune(strings.Join(strings.Fields(text), " "))
+	if len(runes) <= lastMessagePreviewRunes {
+		return string(runes)
+	}
+	return string(runes[:lastMessagePreviewRunes]) + "…"
+}
@@ -0,0 +1,32 @@
+package repositories
+
+import (
+	"context"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// ConversationEventRepository carries conversation events between replicas over Redis pub/sub
+type ConversationEventRepository struct {
+	RedisChatDB *redis.Client
+}
+
+// Constructor
+func NewConversationEventRepository(redisClient *redis.Client) *ConversationEventRepository {
+	return &ConversationEventRepository{RedisChatDB: redisClient}
+}
+
+// ConversationEventChannel is the pub/sub channel of a conversation
+func ConversationEventChannel(conversationID string) string {
+	return "conversation_events:" + conversationID
+}
+
+// Publish sends an event to every replica subscribed to the conversation
+func (r *ConversationEventRepository) Publish(ctx context.Context, conversationID, payload string) error {
+	return r.RedisChatDB.Publish(ctx, ConversationEventChannel(conversationID), payload).Err()
+}
+
+// Subscribe opens a subscription without channels; channels are added and removed as connections come and go
+func (r *ConversationEventRepository) Subscribe(ctx context.Context) *redis.PubSub {
+	return r.RedisChatDB.Subscribe(ctx)
+}
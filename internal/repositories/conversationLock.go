@@ -0,0 +1,168 @@
+package repositories
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// ConversationLockRepository keeps, per conversation, a FIFO queue of tickets for the questions waiting
+// to be answered and a lock held by the ticket being answered. A waiting ticket stays valid while its
+// holder keeps refreshing it, so questions of a crashed replica do not block the queue.
+type ConversationLockRepository struct {
+	RedisChatDB *redis.Client
+}
+
+// Constructor
+func NewConversationLockRepository(redisClient *redis.Client) *ConversationLockRepository {
+	return &ConversationLockRepository{RedisChatDB: redisClient}
+}
+
+// An abandoned queue disappears after this long
+const conversationQueueTTL = time.Hour
+
+// The keys of a conversation share the {conv:<id>} hash tag, so in Redis Cluster they live in one slot
+// and the scripts below may touch all of them.
+func conversationHashTag(conversationID string) string {
+	return "{conv:" + conversationID + "}"
+}
+
+func conversationQueueKey(conversationID string) string {
+	return "conversation_queue:" + conversationHashTag(conversationID)
+}
+
+func conversationLockKey(conversationID string) string {
+	return "conversation_lock:" + conversationHashTag(conversationID)
+}
+
+// conversationTicketsKey is a sorted set of the queued tickets scored by the time (ms) they expire at
+func conversationTicketsKey(conversationID string) string {
+	return "conversation_tickets:" + conversationHashTag(conversationID)
+}
+
+// Ticket expiry is measured on the Redis clock, so clock skew between replicas cannot decide which
+// tickets are abandoned
+const nowMillisLua = `local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+`
+
+// pruneTicketsLua drops the tickets whose holder stopped refreshing them from the queue
+// KEYS: queue, tickets.
+const pruneTicketsLua = `redis.call('ZREMRANGEBYSCORE', KEYS[2], '-inf', now)
+for _, ticket in ipairs(redis.call('LRANGE', KEYS[1], 0, -1)) do
+	if not redis.call('ZSCORE', KEYS[2], ticket) then
+		redis.call('LREM', KEYS[1], 1, ticket)
+	end
+end
+`
+
+// Abandoned tickets are pruned first, so they do not count towards the depth.
+// KEYS: queue, tickets. ARGV: ticket, max depth, ticket TTL ms, queue TTL ms.
+var enqueueScript = redis.NewScript(nowMillisLua + pruneTicketsLua + `
+if tonumber(ARGV[2]) > 0 and redis.call('LLEN', KEYS[1]) >= tonumber(ARGV[2]) then
+	return 0
+end
+redis.call('RPUSH', KEYS[1], ARGV[1])
+redis.call('PEXPIRE', KEYS[1], ARGV[4])
+redis.call('ZADD', KEYS[2], now + tonumber(ARGV[3]), ARGV[1])
+redis.call('PEXPIRE', KEYS[2], ARGV[4])
+return 1
+`)
+
+// Refreshes the ticket, drops abandoned tickets ahead of it and takes the lock if the ticket is first
+// and the lock is free. Returns {1, 0} when acquired, otherwise {0, questions ahead} or {0, -1} if the
+// ticket is no longer queued.
+// KEYS: queue, lock, tickets. ARGV: ticket, lock lease ms, ticket TTL ms.
+var tryAcquireScript = redis.NewScript(nowMillisLua + `
+redis.call('ZREMRANGEBYSCORE', KEYS[3], '-inf', now)
+local index = -1
+local live = 0
+for _, ticket in ipairs(redis.call('LRANGE', KEYS[1], 0, -1)) do
+	if ticket == ARGV[1] then
+		index = live
+		break
+	end
+	if redis.call('ZSCORE', KEYS[3], ticket) then
+		live = live + 1
+	else
+		redis.call('LREM', KEYS[1], 1, ticket)
+	end
+end
+if index < 0 then
+	redis.call('ZREM', KEYS[3], ARGV[1])
+	return {0, -1}
+end
+local held = redis.call('EXISTS', KEYS[2])
+if index == 0 and held == 0 then
+	redis.call('SET', KEYS[2], ARGV[1], 'PX', ARGV[2])
+	redis.call('LREM', KEYS[1], 1, ARGV[1])
+	redis.call('ZREM', KEYS[3], ARGV[1])
+	return {1, 0}
+end
+redis.call('ZADD', KEYS[3], now + tonumber(ARGV[3]), ARGV[1])
+return {0, index + held}
+`)
+
+// KEYS: lock. ARGV: ticket, lease ms.
+var renewLockScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+// KEYS: lock. ARGV: ticket.
+var releaseLockScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+// Enqueue appends a ticket to the queue; false means the queue already holds maxDepth tickets (0 is unlimited)
+func (r *ConversationLockRepository) Enqueue(ctx context.Context, conversationID, ticket string, maxDepth int, ticketTTL time.Duration) (bool, error) {
+	added, err := enqueueScript.Run(ctx, r.RedisChatDB,
+		[]string{conversationQueueKey(conversationID), conversationTicketsKey(conversationID)},
+		ticket,
+		maxDepth,
+		ticketTTL.Milliseconds(),
+		conversationQueueTTL.Milliseconds(),
+	).Int()
+	return added == 1, err
+}
+
+// TryAcquire takes the lock for the ticket if it is next. Otherwise it returns how many questions are
+// ahead of it, including the one being answered, or -1 if the ticket expired.
+func (r *ConversationLockRepository) TryAcquire(ctx context.Context, conversationID, ticket string, lease, ticketTTL time.Duration) (bool, int, error) {
+	result, err := tryAcquireScript.Run(ctx, r.RedisChatDB,
+		[]string{conversationQueueKey(conversationID), conversationLockKey(conversationID), conversationTicketsKey(conversationID)},
+		ticket,
+		lease.Milliseconds(),
+		ticketTTL.Milliseconds(),
+	).Int64Slice()
+	if err != nil {
+		return false, 0, err
+	}
+	return result[0] == 1, int(result[1]), nil
+}
+
+// LeaveQueue removes a ticket that no longer waits
+func (r *ConversationLockRepository) LeaveQueue(ctx context.Context, conversationID, ticket string) error {
+	pipe := r.RedisChatDB.TxPipeline()
+	pipe.LRem(ctx, conversationQueueKey(conversationID), 1, ticket)
+	pipe.ZRem(ctx, conversationTicketsKey(conversationID), ticket)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// RenewLock extends the lease of a lock still held by the ticket
+func (r *ConversationLockRepository) RenewLock(ctx context.Context, conversationID, ticket string, lease time.Duration) (bool, error) {
+	renewed, err := renewLockScript.Run(ctx, r.RedisChatDB, []string{conversationLockKey(conversationID)}, ticket, lease.Milliseconds()).Int()
+	return renewed == 1, err
+}
+
+// ReleaseLock frees the lock if the ticket still holds it
+func (r *ConversationLockRepository) ReleaseLock(ctx context.Context, conversationID, ticket string) error {
+	return releaseLockScript.Run(ctx, r.RedisChatDB, []string{conversationLockKey(conversationID)}, ticket).Err()
+}
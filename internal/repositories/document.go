@@ -0,0 +1,116 @@
+package repositories
+
+import (
+	"chat-ai-backend/internal/models"
+	"chat-ai-backend/utils"
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrDocumentNotFound is returned when no document of the user matches the given ID
+var ErrDocumentNotFound = errors.New("document not found")
+
+type DocumentRepository struct {
+	MongoDocCol *mongo.Collection
+}
+
+func NewDocumentRepository(mongoDocCol *mongo.Collection) *DocumentRepository {
+	return &DocumentRepository{MongoDocCol: mongoDocCol}
+}
+
+// SaveDocument stores the metadata of an uploaded document and returns its ID.
+func (r *DocumentRepository) SaveDocument(doc models.Document) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	res, err := r.MongoDocCol.InsertOne(ctx, doc)
+	if err != nil {
+		utils.Logger.Error("Failed to save document: %v", err)
+		return "", err
+	}
+
+	objectID, ok := res.InsertedID.(primitive.ObjectID)
+	if !ok {
+		return "", errors.New("failed to convert inserted ID to ObjectID")
+	}
+	return objectID.Hex(), nil
+}
+
+// ListDocuments returns the documents of a user, newest first.
+func (r *DocumentRepository) ListDocuments(userID string) ([]models.Document, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}})
+	cursor, err := r.MongoDocCol.Find(ctx, bson.M{"user_id": userID}, opts)
+	if err != nil {
+		utils.Logger.Error("Failed to list documents of user %s: %v", userID, err)
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	documents := []models.Document{}
+	if err := cursor.All(ctx, &documents); err != nil {
+		return nil, err
+	}
+	return documents, nil
+}
+
+// GetDocument returns a document if it belongs to the user.
+func (r *DocumentRepository) GetDocument(userID, documentID string) (*models.Document, error) {
+	objectID, err := primitive.ObjectIDFromHex(documentID)
+	if err != nil {
+		return nil, ErrDocumentNotFound
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var doc models.Document
+	if err := r.MongoDocCol.FindOne(ctx, bson.M{"_id": objectID, "user_id": userID}).Decode(&doc); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrDocumentNotFound
+		}
+		return nil, err
+	}
+	return &doc, nil
+}
+
+// DeleteDocument removes the metadata of a document of the user.
+func (r *DocumentRepository) DeleteDocument(userID, documentID string) error {
+	objectID, err := primitive.ObjectIDFromHex(documentID)
+	if err != nil {
+		return ErrDocumentNotFound
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := r.MongoDocCol.DeleteOne(ctx, bson.M{"_id": objectID, "user_id": userID})
+	if err != nil {
+		utils.Logger.Error("Failed to delete document %s: %v", documentID, err)
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrDocumentNotFound
+	}
+	return nil
+}
+
+// HasDocuments reports whether the user uploaded any document.
+func (r *DocumentRepository) HasDocuments(userID string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	count, err := r.MongoDocCol.CountDocuments(ctx, bson.M{"user_id": userID}, options.Count().SetLimit(1))
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
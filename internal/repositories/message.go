@@ -7,11 +7,13 @@ import (
 	"chat-ai-backend/utils"
 	"context"
 	"errors"
+	"regexp"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 type MessageRepository struct {
@@ -80,5 +82,125 @@ func (r *MessageRepository) SaveMessage(message models.Message) error {
 	}
 
 	utils.Logger.Info("Message saved: %+v", message)
+	if err := refreshConversationStats(ctx, r.MongoConvoCol, r.MongoMsgCol, message.ConversationID, nil); err != nil {
+		utils.Logger.Warn("Failed to refresh stats of conversation %s: %v", message.ConversationID, err)
+	}
 	return nil
 }
+
+// SearchUserMessages finds the user's messages whose question or answer contains the query (case-insensitive).
+func (r *MessageRepository) SearchUserMessages(userID, query string, limit int) ([]models.Message, error) {
+	if r.MongoMsgCol == nil {
+		return nil, errors.New("message collection is not initialized")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	pattern := primitive.Regex{Pattern: regexp.QuoteMeta(query), Options: "i"}
+	filter := bson.M{
+		"user_id": userID,
+		"$or": []bson.M{
+			{"question": pattern},
+			{"answer": pattern},
+		},
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}).SetLimit(int64(limit))
+
+	cursor, err := r.MongoMsgCol.Find(ctx, filter, opts)
+	if err != nil {
+		utils.Logger.Error("Failed to search messages: %v\n", err)
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var messages []models.Message
+	if err := cursor.All(ctx, &messages); err != nil {
+		utils.Logger.Error("Failed to decode messages: %v\n", err)
+		return nil, err
+	}
+	return messages, nil
+}
+
+// FindMessagesToIndex returns messages in insertion order after afterID (empty = from the start),
+// optionally only those not yet in the search index and only of one conversation.
+func (r *MessageRepository) FindMessagesToIndex(conversationID, afterID string, onlyUnindexed bool, limit int) ([]models.Message, error) {
+	if r.MongoMsgCol == nil {
+		return nil, errors.New("message collection is not initialized")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.M{}
+	if conversationID != "" {
+		filter["conversation_id"] = conversationID
+	}
+	if onlyUnindexed {
+		filter["indexed_at"] = bson.M{"$exists": false}
+	}
+	if afterID != "" {
+		objectID, err := primitive.ObjectIDFromHex(afterID)
+		if err != nil {
+			return nil, err
+		}
+		filter["_id"] = bson.M{"$gt": objectID}
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}).SetLimit(int64(limit))
+
+	cursor, err := r.MongoMsgCol.Find(ctx, filter, opts)
+	if err != nil {
+		utils.Logger.Error("Failed to find messages to index: %v\n", err)
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var messages []models.Message
+	if err := cursor.All(ctx, &messages); err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+// MarkMessagesIndexed records that the messages were added to the search index.
+func (r *MessageRepository) MarkMessagesIndexed(messageIDs []string, at time.Time) error {
+	if len(messageIDs) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := r.MongoMsgCol.UpdateMany(
+		ctx,
+		bson.M{"message_id": bson.M{"$in": messageIDs}},
+		bson.M{"$set": bson.M{"indexed_at": at}},
+	)
+	if err != nil {
+		utils.Logger.Error("Failed to mark messages as indexed: %v\n", err)
+	}
+	return err
+}
+
+// GetUserMessagesByIDs returns the user's messages with the given message IDs.
+func (r *MessageRepository) GetUserMessagesByIDs(userID string, messageIDs []string) ([]models.Message, error) {
+	if len(messageIDs) == 0 {
+		return nil, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cursor, err := r.MongoMsgCol.Find(ctx, bson.M{"user_id": userID, "message_id": bson.M{"$in": messageIDs}})
+	if err != nil {
+		utils.Logger.Error("Failed to load messages by ID: %v\n", err)
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var messages []models.Message
+	if err := cursor.All(ctx, &messages); err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
@@ -0,0 +1,187 @@
+package repositories
+
+import (
+	"chat-ai-backend/utils"
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/milvus-io/milvus-sdk-go/v2/client"
+	"github.com/milvus-io/milvus-sdk-go/v2/entity"
+)
+
+// Field names of the per-user Milvus collections
+const (
+	milvusFieldID         = "chunk_id"
+	milvusFieldDocumentID = "document_id"
+	milvusFieldIndex      = "chunk_index"
+	milvusFieldText       = "text"
+	milvusFieldVector     = "embedding"
+)
+
+// Collection name prefixes, one collection per user and kind of content
+const (
+	MilvusDocumentsPrefix = "user_docs_"
+	MilvusMessagesPrefix  = "user_msgs_"
+)
+
+// MilvusVectorStore keeps each user's chunks in a collection of their own
+type MilvusVectorStore struct {
+	Client client.Client
+	Prefix string   // Prepended to the user ID to name the collection
+	ready  sync.Map // Collections known to exist and be loaded
+}
+
+// Constructor
+func NewMilvusVectorStore(milvusClient client.Client, prefix string) *MilvusVectorStore {
+	return &MilvusVectorStore{Client: milvusClient, Prefix: prefix}
+}
+
+func (s *MilvusVectorStore) collectionName(userID string) string {
+	return s.Prefix + userID
+}
+
+// ensureCollection creates, indexes and loads the user's collection on first use
+func (s *MilvusVectorStore) ensureCollection(ctx context.Context, name string, dim int) error {
+	if _, ok := s.ready.Load(name); ok {
+		return nil
+	}
+
+	exists, err := s.Client.HasCollection(ctx, name)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		schema := entity.NewSchema().
+			WithName(name).
+			WithDescription("Embedded chunks of one user").
+			WithField(entity.NewField().WithName(milvusFieldID).WithDataType(entity.FieldTypeVarChar).WithMaxLength(64).WithIsPrimaryKey(true)).
+			WithField(entity.NewField().WithName(milvusFieldDocumentID).WithDataType(entity.FieldTypeVarChar).WithMaxLength(64)).
+			WithField(entity.NewField().WithName(milvusFieldIndex).WithDataType(entity.FieldTypeInt64)).
+			WithField(entity.NewField().WithName(milvusFieldText).WithDataType(entity.FieldTypeVarChar).WithMaxLength(65535)).
+			WithField(entity.NewField().WithName(milvusFieldVector).WithDataType(entity.FieldTypeFloatVector).WithDim(int64(dim)))
+		if err := s.Client.CreateCollection(ctx, schema, entity.DefaultShardNumber); err != nil {
+			return fmt.Errorf("failed to create collection %s: %w", name, err)
+		}
+
+		index, err := entity.NewIndexHNSW(entity.COSINE, 16, 200)
+		if err != nil {
+			return err
+		}
+		if err := s.Client.CreateIndex(ctx, name, milvusFieldVector, index, false); err != nil {
+			return fmt.Errorf("failed to index collection %s: %w", name, err)
+		}
+		utils.Logger.Info("Created Milvus collection %s with dimension %d", name, dim)
+	}
+
+	if err := s.Client.LoadCollection(ctx, name, false); err != nil {
+		return fmt.Errorf("failed to load collection %s: %w", name, err)
+	}
+	s.ready.Store(name, true)
+	return nil
+}
+
+// Upsert adds chunks to the user's collection, replacing chunks with the same ID
+func (s *MilvusVectorStore) Upsert(ctx context.Context, userID string, chunks []VectorChunk) error {
+	if len(chunks) == 0 {
+		return nil
+	}
+	dim := len(chunks[0].Vector)
+	name := s.collectionName(userID)
+	if err := s.ensureCollection(ctx, name, dim); err != nil {
+		return err
+	}
+
+	ids := make([]string, 0, len(chunks))
+	documentIDs := make([]string, 0, len(chunks))
+	indexes := make([]int64, 0, len(chunks))
+	texts := make([]string, 0, len(chunks))
+	vectors := make([][]float32, 0, len(chunks))
+	for _, chunk := range chunks {
+		if len(chunk.Vector) != dim {
+			return ErrDimensionMismatch
+		}
+		ids = append(ids, chunk.ID)
+		documentIDs = append(documentIDs, chunk.DocumentID)
+		indexes = append(indexes, int64(chunk.Index))
+		texts = append(texts, chunk.Text)
+		vectors = append(vectors, chunk.Vector)
+	}
+
+	_, err := s.Client.Upsert(ctx, name, "",
+		entity.NewColumnVarChar(milvusFieldID, ids),
+		entity.NewColumnVarChar(milvusFieldDocumentID, documentIDs),
+		entity.NewColumnInt64(milvusFieldIndex, indexes),
+		entity.NewColumnVarChar(milvusFieldText, texts),
+		entity.NewColumnFloatVector(milvusFieldVector, dim, vectors),
+	)
+	if err != nil {
+		utils.Logger.Error("Failed to upsert %d chunks into %s: %v", len(chunks), name, err)
+		return err
+	}
+	return nil
+}
+
+// Search returns the topK chunks of the user closest to the vector
+func (s *MilvusVectorStore) Search(ctx context.Context, userID string, vector []float32, topK int) ([]VectorMatch, error) {
+	name := s.collectionName(userID)
+	exists, err := s.Client.HasCollection(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, nil // nothing uploaded yet
+	}
+	if err := s.ensureCollection(ctx, name, len(vector)); err != nil {
+		return nil, err
+	}
+
+	param, err := entity.NewIndexHNSWSearchParam(64)
+	if err != nil {
+		return nil, err
+	}
+	results, err := s.Client.Search(ctx, name, nil, "",
+		[]string{milvusFieldID, milvusFieldDocumentID, milvusFieldIndex, milvusFieldText},
+		[]entity.Vector{entity.FloatVector(vector)},
+		milvusFieldVector, entity.COSINE, topK, param,
+	)
+	if err != nil {
+		utils.Logger.Error("Milvus search in %s failed: %v", name, err)
+		return nil, err
+	}
+
+	var matches []VectorMatch
+	for _, result := range results {
+		if result.Err != nil {
+			return nil, result.Err
+		}
+		for i := 0; i < result.ResultCount; i++ {
+			chunk := VectorChunk{}
+			chunk.ID, _ = result.Fields.GetColumn(milvusFieldID).GetAsString(i)
+			chunk.DocumentID, _ = result.Fields.GetColumn(milvusFieldDocumentID).GetAsString(i)
+			chunk.Text, _ = result.Fields.GetColumn(milvusFieldText).GetAsString(i)
+			if index, err := result.Fields.GetColumn(milvusFieldIndex).GetAsInt64(i); err == nil {
+				chunk.Index = int(index)
+			}
+			matches = append(matches, VectorMatch{Chunk: chunk, Score: result.Scores[i]})
+		}
+	}
+	return matches, nil
+}
+
+// DeleteDocument removes every chunk of a document from the user's collection
+func (s *MilvusVectorStore) DeleteDocument(ctx context.Context, userID, documentID string) error {
+	name := s.collectionName(userID)
+	exists, err := s.Client.HasCollection(ctx, name)
+	if err != nil || !exists {
+		return err
+	}
+
+	expr := fmt.Sprintf("%s == %s", milvusFieldDocumentID, strconv.Quote(documentID))
+	if err := s.Client.Delete(ctx, name, "", expr); err != nil {
+		utils.Logger.Error("Failed to delete chunks of document %s from %s: %v", documentID, name, err)
+		return err
+	}
+	return nil
+}
@@ -5,7 +5,10 @@ import (
 	"chat-ai-backend/utils"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"slices"
+	"sort"
 	"time"
 
 	"github.com/go-redis/redis/v8"
@@ -15,6 +18,25 @@ import (
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// ErrMessageNotFound is returned when no message matches the given ID
+var ErrMessageNotFound = errors.New("message not found")
+
+// messageIndexKey is a hash from the ID of every message cached in Redis to its conversation, so a
+// message not flushed yet is found without scanning every cached conversation
+const messageIndexKey = "message_conversations"
+
+// unindexMessages queues the removal of messages that left the Redis cache from the index
+func unindexMessages(ctx context.Context, pipe redis.Pipeliner, messages []models.Message) {
+	if len(messages) == 0 {
+		return
+	}
+	ids := make([]string, 0, len(messages))
+	for _, msg := range messages {
+		ids = append(ids, msg.MessageID)
+	}
+	pipe.HDel(ctx, messageIndexKey, ids...)
+}
+
 type RedisMessageRepository struct {
 	MongoMsgCol   *mongo.Collection
 	MongoConvoCol *mongo.Collection
@@ -34,8 +56,36 @@ func NewRedisMessageRepository(
 	}
 }
 
-// LoadMessagesIntoRedis loads messages from MongoDB into Redis.
-func (r *RedisMessageRepository) LoadMessagesIntoRedis(conversationID string) ([]models.Message, error) {
+// MessageCursor is a position in a conversation ordered by creation time, ties broken by message ID.
+// Times are compared in milliseconds, the precision MongoDB keeps.
+type MessageCursor struct {
+	CreatedAt time.Time
+	MessageID string
+}
+
+// olderThan reports whether msg was created before the cursor
+func (c MessageCursor) olderThan(msg models.Message) bool {
+	created, at := msg.CreatedAt.UnixMilli(), c.CreatedAt.UnixMilli()
+	return created < at || (created == at && msg.MessageID < c.MessageID)
+}
+
+// newerThan reports whether msg was created after the cursor
+func (c MessageCursor) newerThan(msg models.Message) bool {
+	created, at := msg.CreatedAt.UnixMilli(), c.CreatedAt.UnixMilli()
+	return created > at || (created == at && msg.MessageID > c.MessageID)
+}
+
+// follows reports whether msg comes after the cursor in the given order
+func (c MessageCursor) follows(msg models.Message, ascending bool) bool {
+	if ascending {
+		return c.newerThan(msg)
+	}
+	return c.olderThan(msg)
+}
+
+// LoadMessagesIntoRedis loads the newest window messages (0 for all) from MongoDB into Redis unless the
+// conversation is cached already, and returns the cached messages.
+func (r *RedisMessageRepository) LoadMessagesIntoRedis(conversationID string, window int) ([]models.Message, error) {
 	ctx := context.Background()
 	redisKey := fmt.Sprintf("messages:%s", conversationID)
 
@@ -58,7 +108,7 @@ func (r *RedisMessageRepository) LoadMessagesIntoRedis(conversationID string) ([
 	}
 
 	// Fetch from MongoDB
-	messages, err := r.LoadMessagesFromMongo(conversationID)
+	messages, err := r.LoadRecentMessagesFromMongo(conversationID, window)
 	if err != nil {
 		utils.Logger.Error("Failed to load messages from MongoDB: %v", err)
 		return nil, err
@@ -74,6 +124,131 @@ func (r *RedisMessageRepository) LoadMessagesIntoRedis(conversationID string) ([
 	return messages, nil
 }
 
+// GetConversationMessages returns every message of a conversation, oldest first, without loading them
+// into Redis. Redis only caches the newest messages, so MongoDB is read too and cached copies win.
+func (r *RedisMessageRepository) GetConversationMessages(conversationID string) ([]models.Message, error) {
+	stored, err := r.LoadMessagesFromMongo(conversationID)
+	if err != nil {
+		return nil, err
+	}
+	cached, err := r.ReadAllMessagesFromRedis(conversationID)
+	if err != nil {
+		return nil, err
+	}
+	if len(cached) == 0 {
+		return stored, nil
+	}
+
+	return mergeMessages(stored, cached, nil, true), nil
+}
+
+// ListMessagesPage returns up to limit messages after the cursor (nil to start at the newest, or the
+// oldest when ascending) in the given order, and whether more follow.
+func (r *RedisMessageRepository) ListMessagesPage(conversationID string, after *MessageCursor, ascending bool, limit int) ([]models.Message, bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	compare, direction := "$lt", -1
+	if ascending {
+		compare, direction = "$gt", 1
+	}
+	filter := bson.M{"conversation_id": conversationID}
+	if after != nil {
+		at := time.UnixMilli(after.CreatedAt.UnixMilli())
+		filter["$or"] = []bson.M{
+			{"created_at": bson.M{compare: at}},
+			{"created_at": at, "message_id": bson.M{compare: after.MessageID}},
+		}
+	}
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: direction}, {Key: "message_id", Value: direction}}).
+		SetLimit(int64(limit + 1))
+	cursor, err := r.MongoMsgCol.Find(ctx, filter, opts)
+	if err != nil {
+		utils.Logger.Error("Failed to query messages of conversation %s: %v", conversationID, err)
+		return nil, false, err
+	}
+	defer cursor.Close(ctx)
+	var stored []models.Message
+	if err := cursor.All(ctx, &stored); err != nil {
+		return nil, false, err
+	}
+
+	// Messages not flushed yet, or changed since, are only current in Redis
+	cached, err := r.ReadAllMessagesFromRedis(conversationID)
+	if err != nil {
+		return nil, false, err
+	}
+
+	page := mergeMessages(stored, cached, after, ascending)
+	hasMore := len(page) > limit
+	if hasMore {
+		page = page[:limit]
+	}
+	return page, hasMore, nil
+}
+
+// mergeMessages combines stored and cached messages after the cursor in the given order; cached copies win
+func mergeMessages(stored, cached []models.Message, after *MessageCursor, ascending bool) []models.Message {
+	byID := make(map[string]models.Message, len(stored)+len(cached))
+	for _, msg := range stored {
+		byID[msg.MessageID] = msg
+	}
+	for _, msg := range cached {
+		if after == nil || after.follows(msg, ascending) {
+			byID[msg.MessageID] = msg
+		}
+	}
+
+	merged := make([]models.Message, 0, len(byID))
+	for _, msg := range byID {
+		merged = append(merged, msg)
+	}
+	sort.Slice(merged, func(i, j int) bool {
+		return MessageCursor{CreatedAt: merged[i].CreatedAt, MessageID: merged[i].MessageID}.follows(merged[j], ascending)
+	})
+	return merged
+}
+
+// FindMessage returns a message from MongoDB or, if it was not flushed yet, from Redis.
+func (r *RedisMessageRepository) FindMessage(messageID string) (*models.Message, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var msg models.Message
+	err := r.MongoMsgCol.FindOne(ctx, bson.M{"message_id": messageID}).Decode(&msg)
+	if err == nil {
+		return &msg, nil
+	}
+	if err != mongo.ErrNoDocuments {
+		utils.Logger.Error("Failed to find message %s: %v", messageID, err)
+		return nil, err
+	}
+
+	// Messages not flushed yet are found through the index of cached messages
+	conversationID, err := r.RedisChatDB.HGet(ctx, messageIndexKey, messageID).Result()
+	if err == redis.Nil {
+		return nil, ErrMessageNotFound
+	}
+	if err != nil {
+		utils.Logger.Error("Failed to look up message %s in Redis: %v", messageID, err)
+		return nil, err
+	}
+	messagesJSON, err := r.RedisChatDB.LRange(ctx, fmt.Sprintf("messages:%s", conversationID), 0, -1).Result()
+	if err != nil {
+		utils.Logger.Error("Failed to fetch messages from Redis: %v", err)
+		return nil, err
+	}
+	for _, cached := range decodeMessages(messagesJSON) {
+		if cached.MessageID == messageID {
+			return &cached, nil
+		}
+	}
+	// The entry outlived its message
+	r.RedisChatDB.HDel(ctx, messageIndexKey, messageID)
+	return nil, ErrMessageNotFound
+}
+
 // StoreMessagesInRedis saves multiple messages in Redis.
 func (r *RedisMessageRepository) StoreMessagesInRedis(conversationID string, messages []models.Message) error {
 	ctx := context.Background()
@@ -85,7 +260,10 @@ func (r *RedisMessageRepository) StoreMessagesInRedis(conversationID string, mes
 			utils.Logger.Error("Error encoding message to JSON: %v", err)
 			continue
 		}
-		if err := r.RedisChatDB.RPush(ctx, redisKey, messageJSON).Err(); err != nil {
+		pipe := r.RedisChatDB.TxPipeline()
+		pipe.RPush(ctx, redisKey, messageJSON)
+		pipe.HSet(ctx, messageIndexKey, msg.MessageID, conversationID)
+		if _, err := pipe.Exec(ctx); err != nil {
 			utils.Logger.Error("Failed to store message in Redis: %v", err)
 			return err
 		}
@@ -95,13 +273,22 @@ func (r *RedisMessageRepository) StoreMessagesInRedis(conversationID string, mes
 	return nil
 }
 
-// LoadMessagesFromMongo retrieves messages from MongoDB.
+// LoadMessagesFromMongo retrieves all messages of a conversation from MongoDB, oldest first.
 func (r *RedisMessageRepository) LoadMessagesFromMongo(conversationID string) ([]models.Message, error) {
+	return r.LoadRecentMessagesFromMongo(conversationID, 0)
+}
+
+// LoadRecentMessagesFromMongo retrieves the newest limit messages (0 for all) of a conversation, oldest first.
+func (r *RedisMessageRepository) LoadRecentMessagesFromMongo(conversationID string, limit int) ([]models.Message, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
 	filter := bson.M{"conversation_id": conversationID}
-	cursor, err := r.MongoMsgCol.Find(ctx, filter)
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}, {Key: "message_id", Value: -1}})
+	if limit > 0 {
+		opts.SetLimit(int64(limit))
+	}
+	cursor, err := r.MongoMsgCol.Find(ctx, filter, opts)
 	if err != nil {
 		utils.Logger.Error("Failed to query messages from MongoDB: %v", err)
 		return nil, err
@@ -114,41 +301,84 @@ func (r *RedisMessageRepository) LoadMessagesFromMongo(conversationID string) ([
 		return nil, err
 	}
 
+	slices.Reverse(messages)
+
 	utils.Logger.Info("Loaded %d messages from MongoDB for conversationID %s", len(messages), conversationID)
 	return messages, nil
 }
 
-// StoreOneMessageInRedis saves a single message in Redis.
-func (r *RedisMessageRepository) StoreOneMessageInRedis(userID, conversationID, question, answer string) error {
+// StoreOneMessageInRedis saves a single message in Redis, filling in the ID and timestamp if missing.
+// Once more than window messages (0 for no limit) are cached, the oldest are written to MongoDB and dropped;
+// the number of messages written to MongoDB is returned.
+func (r *RedisMessageRepository) StoreOneMessageInRedis(msg models.Message, window int) (int, error) {
 	ctx := context.Background()
-	redisKey := fmt.Sprintf("messages:%s", conversationID)
+	redisKey := fmt.Sprintf("messages:%s", msg.ConversationID)
 
-	msg := models.Message{
-		MessageID:      uuid.New().String(),
-		UserID:         userID,
-		ConversationID: conversationID,
-		Question:       question,
-		Answer:         answer,
-		ThumbUp:        0,
-		Feedback:       nil,
-		InputURL:       "",
-		OutputURL:      "",
-		CreatedAt:      time.Now(),
+	if msg.MessageID == "" {
+		msg.MessageID = uuid.New().String()
+	}
+	if msg.CreatedAt.IsZero() {
+		msg.CreatedAt = time.Now()
 	}
 
 	messageJSON, err := json.Marshal(msg)
 	if err != nil {
 		utils.Logger.Error("Error encoding message to JSON: %v", err)
-		return err
+		return 0, err
 	}
 
-	if err := r.RedisChatDB.RPush(ctx, redisKey, messageJSON).Err(); err != nil {
+	pipe := r.RedisChatDB.TxPipeline()
+	pipe.RPush(ctx, redisKey, messageJSON)
+	pipe.HSet(ctx, messageIndexKey, msg.MessageID, msg.ConversationID)
+	if _, err := pipe.Exec(ctx); err != nil {
 		utils.Logger.Error("Failed to store message in Redis: %v", err)
-		return err
+		return 0, err
 	}
 
-	utils.Logger.Info("Message stored in Redis for conversation %s", conversationID)
-	return nil
+	utils.Logger.Info("Message stored in Redis for conversation %s", msg.ConversationID)
+	moved, err := r.trimWindow(ctx, msg.ConversationID, window)
+	if moved == 0 {
+		// Moving messages to MongoDB refreshes the stats already
+		mongoCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+		r.refreshStats(mongoCtx, msg.ConversationID)
+	}
+	return moved, err
+}
+
+// trimWindow moves the messages beyond the newest window from Redis to MongoDB and returns how many it moved
+func (r *RedisMessageRepository) trimWindow(ctx context.Context, conversationID string, window int) (int, error) {
+	if window <= 0 {
+		return 0, nil
+	}
+	redisKey := fmt.Sprintf("messages:%s", conversationID)
+	length, err := r.RedisChatDB.LLen(ctx, redisKey).Result()
+	if err != nil || length <= int64(window) {
+		return 0, err
+	}
+
+	overflow := length - int64(window)
+	messagesJSON, err := r.RedisChatDB.LRange(ctx, redisKey, 0, overflow-1).Result()
+	if err != nil {
+		utils.Logger.Error("Failed to fetch messages from Redis: %v", err)
+		return 0, err
+	}
+	mongoCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	moved := decodeMessages(messagesJSON)
+	if err := r.upsertMessages(mongoCtx, moved); err != nil {
+		return 0, err
+	}
+	// The messages are in MongoDB even if they stay cached a little longer
+	pipe := r.RedisChatDB.TxPipeline()
+	pipe.LTrim(ctx, redisKey, overflow, -1)
+	unindexMessages(ctx, pipe, moved)
+	if _, err := pipe.Exec(ctx); err != nil {
+		utils.Logger.Error("Failed to trim messages of conversation %s in Redis: %v", conversationID, err)
+		return int(overflow), err
+	}
+	utils.Logger.Info("Moved %d old messages of conversation %s from Redis to MongoDB", overflow, conversationID)
+	return int(overflow), nil
 }
 
 // ReadMessagesFromRedis fetches all messages from Redis.
@@ -162,6 +392,14 @@ func (r *RedisMessageRepository) ReadAllMessagesFromRedis(conversationID string)
 		return nil, err
 	}
 
+	messages := decodeMessages(messagesJSON)
+
+	utils.Logger.Info("Loaded %d messages from Redis for conversationID %s", len(messages), conversationID)
+	return messages, nil
+}
+
+// decodeMessages decodes cached messages, skipping any that are malformed
+func decodeMessages(messagesJSON []string) []models.Message {
 	var messages []models.Message
 	for _, msg := range messagesJSON {
 		var message models.Message
@@ -171,9 +409,7 @@ func (r *RedisMessageRepository) ReadAllMessagesFromRedis(conversationID string)
 		}
 		messages = append(messages, message)
 	}
-
-	utils.Logger.Info("Loaded %d messages from Redis for conversationID %s", len(messages), conversationID)
-	return messages, nil
+	return messages
 }
 
 // MoveAllConversationsToMongo finds all conversations (Used in Gracefule Shutdown)
@@ -231,15 +467,7 @@ func (r *RedisMessageRepository) MoveConvToMongo(conversationID string) error {
 	}
 
 	// Convert JSON strings to Message objects
-	var messages []models.Message
-	for _, msg := range messagesJSON {
-		var message models.Message
-		if err := json.Unmarshal([]byte(msg), &message); err != nil {
-			utils.Logger.Error("Error decoding Redis message: %v", err)
-			continue
-		}
-		messages = append(messages, message)
-	}
+	messages := decodeMessages(messagesJSON)
 
 	// Ensure messages exist before saving
 	if len(messages) == 0 {
@@ -247,20 +475,45 @@ func (r *RedisMessageRepository) MoveConvToMongo(conversationID string) error {
 		return nil
 	}
 
-	// Upsert each message in MongoDB
+	if err := r.upsertMessages(ctx, messages); err != nil {
+		return err
+	}
+
+	// Delete from Redis after successful migration
+	pipe := r.RedisChatDB.TxPipeline()
+	pipe.Del(ctx, redisKey)
+	unindexMessages(ctx, pipe, messages)
+	if _, err := pipe.Exec(ctx); err != nil {
+		utils.Logger.Error("Failed to delete Redis conversation: %v", err)
+		return err
+	}
+
+	utils.Logger.Info("Moved conversation %s to MongoDB", conversationID)
+	return nil
+}
+
+// upsertMessages writes cached messages to MongoDB, replacing older copies
+func (r *RedisMessageRepository) upsertMessages(ctx context.Context, messages []models.Message) error {
 	for _, msg := range messages {
 		filter := bson.M{"message_id": msg.MessageID} // Check if message already exists
 		update := bson.M{
 			"$set": bson.M{
-				"user_id":         msg.UserID,
-				"conversation_id": msg.ConversationID,
-				"question":        msg.Question,
-				"answer":          msg.Answer,
-				"thumbup":         msg.ThumbUp,
-				"feedback":        msg.Feedback,
-				"input_url":       msg.InputURL,
-				"output_url":      msg.OutputURL,
-				"created_at":      msg.CreatedAt,
+				"user_id":           msg.UserID,
+				"conversation_id":   msg.ConversationID,
+				"parent_message_id": msg.ParentMessageID,
+				"version_index":     msg.VersionIndex,
+				"question":          msg.Question,
+				"answer":            msg.Answer,
+				"thumbup":           msg.ThumbUp,
+				"feedback":          msg.Feedback,
+				"input_url":         msg.InputURL,
+				"output_url":        msg.OutputURL,
+				"attachments":       msg.Attachments,
+				"metadata":          msg.Metadata,
+				"status":            msg.Status,
+				"usage":             msg.Usage,
+				"tool_invocations":  msg.ToolInvocations,
+				"created_at":        msg.CreatedAt,
 			},
 		}
 
@@ -272,13 +525,20 @@ func (r *RedisMessageRepository) MoveConvToMongo(conversationID string) error {
 		}
 	}
 
-	// Delete from Redis after successful migration
-	_, err = r.RedisChatDB.Del(ctx, redisKey).Result()
-	if err != nil {
-		utils.Logger.Error("Failed to delete Redis conversation: %v", err)
-		return err
+	if len(messages) > 0 {
+		r.refreshStats(ctx, messages[0].ConversationID)
 	}
-
-	utils.Logger.Info("Moved conversation %s to MongoDB", conversationID)
 	return nil
 }
+
+// refreshStats updates the stats of a conversation from MongoDB and the messages still cached in Redis
+func (r *RedisMessageRepository) refreshStats(ctx context.Context, conversationID string) {
+	messagesJSON, err := r.RedisChatDB.LRange(ctx, fmt.Sprintf("messages:%s", conversationID), 0, -1).Result()
+	if err != nil {
+		utils.Logger.Warn("Failed to read cached messages of conversation %s: %v", conversationID, err)
+		return
+	}
+	if err := refreshConversationStats(ctx, r.MongoConvoCol, r.MongoMsgCol, conversationID, decodeMessages(messagesJSON)); err != nil {
+		utils.Logger.Warn("Failed to refresh stats of conversation %s: %v", conversationID, err)
+	}
+}
@@ -8,11 +8,13 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"time"
 
 	"github.com/go-redis/redis/v8"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 type MessageUpdateRepository struct {
@@ -33,12 +35,13 @@ func NewMessageUpdateRepository(
 	}
 }
 
-// UpdateMessageByConversationID updates the feedback and thumbup fields of a message document
-func (r *MessageUpdateRepository) UpdateMessageByMessageID(messageID string, feedback string, thumbUp int) error {
+// UpdateMessageByConversationID updates the feedback and thumbup fields of a message document.
+// It returns the ID of the message's conversation, empty if the message was not found.
+func (r *MessageUpdateRepository) UpdateMessageByMessageID(messageID string, feedback string, thumbUp int) (string, error) {
 	// Ensure the collection is initialized
 	if r.MongoMsgCol == nil {
 		utils.Logger.Error("Error: Message collection is not initialized")
-		return errors.New("message collection is not initialized")
+		return "", errors.New("message collection is not initialized")
 	}
 
 	// Prepare the update filter and update document
@@ -55,93 +58,104 @@ func (r *MessageUpdateRepository) UpdateMessageByMessageID(messageID string, fee
 	defer cancel()
 
 	// Update in Mongo
-	result, err := r.MongoMsgCol.UpdateOne(ctx, filter, update)
-	if err != nil {
+	var stored struct {
+		ConversationID string `bson:"conversation_id"`
+	}
+	opts := options.FindOneAndUpdate().SetProjection(bson.M{"conversation_id": 1})
+	err := r.MongoMsgCol.FindOneAndUpdate(ctx, filter, update, opts).Decode(&stored)
+	if err != nil && err != mongo.ErrNoDocuments {
 		utils.Logger.Error("Failed to update message: %v\n", err)
-		return err
+		return "", err
 	}
 
-	// Update in Redis
-	err = r.updateMessageInRedis(messageID, feedback, thumbUp)
+	// Update in Redis, where messages of an open conversation may not have reached Mongo yet
+	conversationID, err := r.updateMessageInRedis(messageID, feedback, thumbUp)
 	if err != nil {
 		utils.Logger.Error("Failed to update message in Redis: %v\n", err)
-		return err
+		return "", err
+	}
+	if conversationID == "" {
+		conversationID = stored.ConversationID
 	}
 
-	utils.Logger.Info("Matched %d document(s) and modified %d document(s)", result.MatchedCount, result.ModifiedCount)
-	return nil
+	utils.Logger.Info("Updated feedback of message %s in conversation %q", messageID, conversationID)
+	return conversationID, nil
 }
 
-// updateMessageInRedis finds and updates a message in Redis
-func (r *MessageUpdateRepository) updateMessageInRedis(messageID string, feedback string, thumbUp int) error {
+// Replaces the entry at an index of a cached conversation only if it still holds the message that was
+// read, so a concurrent append or trim of the list is never overwritten.
+// KEYS: messages list. ARGV: index, entry read, updated entry.
+var setCachedMessageScript = redis.NewScript(`
+if redis.call('LINDEX', KEYS[1], ARGV[1]) == ARGV[2] then
+	redis.call('LSET', KEYS[1], ARGV[1], ARGV[3])
+	return 1
+end
+return 0
+`)
+
+// Reads of a cached conversation to retry when its list changed before the update
+const cachedMessageUpdateAttempts = 3
+
+// updateMessageInRedis finds and updates a message in Redis and returns its conversation ID, empty if not found
+func (r *MessageUpdateRepository) updateMessageInRedis(messageID string, feedback string, thumbUp int) (string, error) {
 	ctx := context.Background()
 
-	// Scan all keys that store messages (messages:<conversationID>)
-	pattern := "messages:*"
-	keys, err := r.RedisChatDB.Keys(ctx, pattern).Result()
+	// The message index tells which conversation caches the message
+	conversationID, err := r.RedisChatDB.HGet(ctx, messageIndexKey, messageID).Result()
+	if err == redis.Nil {
+		utils.Logger.Warn("Message %s not found in Redis", messageID)
+		return "", nil
+	}
 	if err != nil {
-		utils.Logger.Error("Failed to scan Redis keys: %v", err)
-		return err
+		utils.Logger.Error("Failed to look up message %s in Redis: %v", messageID, err)
+		return "", err
 	}
+	redisKey := fmt.Sprintf("messages:%s", conversationID)
 
-	for _, redisKey := range keys {
-		// Get all messages in the Redis list
+	for attempt := 0; attempt < cachedMessageUpdateAttempts; attempt++ {
 		messagesJSON, err := r.RedisChatDB.LRange(ctx, redisKey, 0, -1).Result()
 		if err != nil {
 			utils.Logger.Error("Failed to fetch messages from Redis: %v", err)
-			continue
+			return "", err
 		}
 
-		// Iterate over messages and update the target message
-		var updatedMessages []string
-		found := false
-
-		for _, msgJSON := range messagesJSON {
-			var msg models.Message
-			if err := json.Unmarshal([]byte(msgJSON), &msg); err != nil {
+		index := -1
+		var msg models.Message
+		for i, msgJSON := range messagesJSON {
+			var cached models.Message
+			if err := json.Unmarshal([]byte(msgJSON), &cached); err != nil {
 				utils.Logger.Error("Error decoding Redis message: %v", err)
 				continue
 			}
-
-			// If the message matches, update the fields
-			if msg.MessageID == messageID {
-				msg.Feedback = &feedback
-				msg.ThumbUp = thumbUp
-				found = true
-			}
-
-			// Convert message back to JSON and store in the new list
-			updatedMsgJSON, err := json.Marshal(msg)
-			if err != nil {
-				utils.Logger.Error("Error encoding updated message to JSON: %v", err)
-				continue
+			if cached.MessageID == messageID {
+				index, msg = i, cached
+				break
 			}
-			updatedMessages = append(updatedMessages, string(updatedMsgJSON))
+		}
+		if index < 0 {
+			// The entry outlived its message
+			r.RedisChatDB.HDel(ctx, messageIndexKey, messageID)
+			utils.Logger.Warn("Message %s not found in Redis", messageID)
+			return "", nil
 		}
 
-		// If message was found and updated, replace the list in Redis
-		if found {
-			// Remove old list
-			err = r.RedisChatDB.Del(ctx, redisKey).Err()
-			if err != nil {
-				utils.Logger.Error("Failed to delete old message list in Redis: %v", err)
-				return err
-			}
-
-			// Push updated messages back into Redis
-			for _, msg := range updatedMessages {
-				err = r.RedisChatDB.RPush(ctx, redisKey, msg).Err()
-				if err != nil {
-					utils.Logger.Error("Failed to push updated message back into Redis: %v", err)
-					return err
-				}
-			}
-
+		msg.Feedback = &feedback
+		msg.ThumbUp = thumbUp
+		updatedJSON, err := json.Marshal(msg)
+		if err != nil {
+			utils.Logger.Error("Error encoding updated message to JSON: %v", err)
+			return "", err
+		}
+		set, err := setCachedMessageScript.Run(ctx, r.RedisChatDB, []string{redisKey}, index, messagesJSON[index], updatedJSON).Int()
+		if err != nil {
+			utils.Logger.Error("Failed to update message %s in Redis: %v", messageID, err)
+			return "", err
+		}
+		if set == 1 {
 			utils.Logger.Info("Updated message %s in Redis (key: %s)", messageID, redisKey)
-			return nil
+			return conversationID, nil
 		}
 	}
 
-	utils.Logger.Warn("Message %s not found in Redis", messageID)
-	return nil
+	return "", fmt.Errorf("message %s kept moving in Redis while being updated", messageID)
 }
@@ -0,0 +1,171 @@
+package repositories
+
+import (
+	"chat-ai-backend/internal/models"
+	"chat-ai-backend/utils"
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Daily usage is kept for this long before Redis expires it
+const usageRetention = 90 * 24 * time.Hour
+
+// Usage reported without a model name is counted under this name
+const unknownUsageModel = "unknown"
+
+type UsageRepository struct {
+	RedisClient *redis.Client
+}
+
+func NewUsageRepository(redisClient *redis.Client) *UsageRepository {
+	return &UsageRepository{RedisClient: redisClient}
+}
+
+// DailyUsage is the usage of one user on one day
+type DailyUsage struct {
+	Date             string                 `json:"date"`
+	Requests         int                    `json:"requests"`
+	PromptTokens     int                    `json:"prompt_tokens"`
+	CompletionTokens int                    `json:"completion_tokens"`
+	TotalTokens      int                    `json:"total_tokens"`
+	Models           map[string]*ModelUsage `json:"models"`
+}
+
+// ModelUsage is the usage of one model
+type ModelUsage struct {
+	Requests         int `json:"requests"`
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+func usageKey(userID, date string) string {
+	return fmt.Sprintf("usage:%s:%s", userID, date)
+}
+
+// usageModelsKey is the set of models used by the user on the given day
+func usageModelsKey(userID, date string) string {
+	return usageKey(userID, date) + ":models"
+}
+
+// usageModelKey holds the totals of one model, kept apart from the day totals so no model name can clash with them
+func usageModelKey(userID, date, model string) string {
+	return usageKey(userID, date) + ":model:" + model
+}
+
+// IncrementUsage adds one request to the user's totals of the given day and to the totals of its model
+func (r *UsageRepository) IncrementUsage(userID, date string, usage models.Usage) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	model := usage.Model
+	if model == "" {
+		model = unknownUsageModel
+	}
+
+	key := usageKey(userID, date)
+	modelsKey := usageModelsKey(userID, date)
+	modelKey := usageModelKey(userID, date, model)
+	pipe := r.RedisClient.TxPipeline()
+	incrementUsageFields(ctx, pipe, key, usage)
+	incrementUsageFields(ctx, pipe, modelKey, usage)
+	pipe.SAdd(ctx, modelsKey, model)
+	pipe.Expire(ctx, key, usageRetention)
+	pipe.Expire(ctx, modelsKey, usageRetention)
+	pipe.Expire(ctx, modelKey, usageRetention)
+
+	if _, err := pipe.Exec(ctx); err != nil {
+		utils.Logger.Error("Failed to record usage for user %s: %v", userID, err)
+		return err
+	}
+	return nil
+}
+
+func incrementUsageFields(ctx context.Context, pipe redis.Pipeliner, key string, usage models.Usage) {
+	pipe.HIncrBy(ctx, key, "requests", 1)
+	pipe.HIncrBy(ctx, key, "prompt_tokens", int64(usage.PromptTokens))
+	pipe.HIncrBy(ctx, key, "completion_tokens", int64(usage.CompletionTokens))
+	pipe.HIncrBy(ctx, key, "total_tokens", int64(usage.TotalTokens))
+}
+
+// GetDailyUsage reads the user's usage of one day; a day without usage returns zero totals
+func (r *UsageRepository) GetDailyUsage(userID, date string) (*DailyUsage, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	pipe := r.RedisClient.Pipeline()
+	totalsCmd := pipe.HGetAll(ctx, usageKey(userID, date))
+	modelsCmd := pipe.SMembers(ctx, usageModelsKey(userID, date))
+	if _, err := pipe.Exec(ctx); err != nil {
+		utils.Logger.Error("Failed to read usage for user %s: %v", userID, err)
+		return nil, err
+	}
+
+	day := &DailyUsage{Date: date, Models: map[string]*ModelUsage{}}
+	for field, raw := range totalsCmd.Val() {
+		value, err := strconv.Atoi(raw)
+		if err != nil {
+			continue
+		}
+
+		// Days recorded before the per-model hashes keep "<model>|<field>" entries next to the totals
+		if idx := strings.LastIndex(field, "|"); idx >= 0 {
+			if idx > 0 {
+				m := day.model(field[:idx])
+				setUsageField(&m.Requests, &m.PromptTokens, &m.CompletionTokens, &m.TotalTokens, field[idx+1:], value)
+			}
+			continue
+		}
+		setUsageField(&day.Requests, &day.PromptTokens, &day.CompletionTokens, &day.TotalTokens, field, value)
+	}
+
+	if len(modelsCmd.Val()) == 0 {
+		return day, nil
+	}
+	pipe = r.RedisClient.Pipeline()
+	modelCmds := make(map[string]*redis.StringStringMapCmd, len(modelsCmd.Val()))
+	for _, model := range modelsCmd.Val() {
+		modelCmds[model] = pipe.HGetAll(ctx, usageModelKey(userID, date, model))
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		utils.Logger.Error("Failed to read model usage for user %s: %v", userID, err)
+		return nil, err
+	}
+	for model, cmd := range modelCmds {
+		m := day.model(model)
+		for field, raw := range cmd.Val() {
+			if value, err := strconv.Atoi(raw); err == nil {
+				setUsageField(&m.Requests, &m.PromptTokens, &m.CompletionTokens, &m.TotalTokens, field, value)
+			}
+		}
+	}
+	return day, nil
+}
+
+// model returns the usage entry of the given model, adding it if needed
+func (d *DailyUsage) model(name string) *ModelUsage {
+	m, ok := d.Models[name]
+	if !ok {
+		m = &ModelUsage{}
+		d.Models[name] = m
+	}
+	return m
+}
+
+func setUsageField(requests, prompt, completion, total *int, name string, value int) {
+	switch name {
+	case "requests":
+		*requests = value
+	case "prompt_tokens":
+		*prompt = value
+	case "completion_tokens":
+		*completion = value
+	case "total_tokens":
+		*total = value
+	}
+}
@@ -0,0 +1,118 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sort"
+	"sync"
+)
+
+// ErrDimensionMismatch is returned when a vector does not match the dimension of the stored ones
+var ErrDimensionMismatch = errors.New("embedding dimension does not match the stored vectors")
+
+// VectorChunk is one embedded piece of text, such as a document chunk or a chat message
+type VectorChunk struct {
+	ID         string
+	DocumentID string // Document or conversation the chunk belongs to
+	Index      int    // Position of the chunk in the document
+	Text       string
+	Vector     []float32
+}
+
+// VectorMatch is a chunk found by a similarity search; higher scores are more similar
+type VectorMatch struct {
+	Chunk VectorChunk
+	Score float32
+}
+
+// VectorStore keeps the embedded chunks of every user apart from the others
+type VectorStore interface {
+	Upsert(ctx context.Context, userID string, chunks []VectorChunk) error // Chunks with a known ID are replaced
+	Search(ctx context.Context, userID string, vector []float32, topK int) ([]VectorMatch, error)
+	DeleteDocument(ctx context.Context, userID, documentID string) error
+}
+
+// MemoryVectorStore is a brute-force cosine similarity store for tests and local development
+type MemoryVectorStore struct {
+	mu     sync.RWMutex
+	chunks map[string][]VectorChunk // by user ID
+}
+
+// Constructor
+func NewMemoryVectorStore() *MemoryVectorStore {
+	return &MemoryVectorStore{chunks: make(map[string][]VectorChunk)}
+}
+
+// Upsert adds chunks to the user's collection, replacing chunks with the same ID
+func (s *MemoryVectorStore) Upsert(ctx context.Context, userID string, chunks []VectorChunk) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing := s.chunks[userID]
+	positions := make(map[string]int, len(existing))
+	for i, chunk := range existing {
+		positions[chunk.ID] = i
+	}
+	for _, chunk := range chunks {
+		if len(existing) > 0 && len(existing[0].Vector) != len(chunk.Vector) {
+			return ErrDimensionMismatch
+		}
+		if i, ok := positions[chunk.ID]; ok {
+			existing[i] = chunk
+			continue
+		}
+		positions[chunk.ID] = len(existing)
+		existing = append(existing, chunk)
+	}
+	s.chunks[userID] = existing
+	return nil
+}
+
+// Search compares the vector with every chunk of the user and returns the topK most similar
+func (s *MemoryVectorStore) Search(ctx context.Context, userID string, vector []float32, topK int) ([]VectorMatch, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	matches := make([]VectorMatch, 0, len(s.chunks[userID]))
+	for _, chunk := range s.chunks[userID] {
+		if len(chunk.Vector) != len(vector) {
+			return nil, ErrDimensionMismatch
+		}
+		matches = append(matches, VectorMatch{Chunk: chunk, Score: cosineSimilarity(vector, chunk.Vector)})
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool { return matches[i].Score > matches[j].Score })
+	if len(matches) > topK {
+		matches = matches[:topK]
+	}
+	return matches, nil
+}
+
+// DeleteDocument removes every chunk of a document
+func (s *MemoryVectorStore) DeleteDocument(ctx context.Context, userID, documentID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	kept := s.chunks[userID][:0]
+	for _, chunk := range s.chunks[userID] {
+		if chunk.DocumentID != documentID {
+			kept = append(kept, chunk)
+		}
+	}
+	s.chunks[userID] = kept
+	return nil
+}
+
+func cosineSimilarity(a, b []float32) float32 {
+	var dot, normA, normB float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return float32(dot / (math.Sqrt(normA) * math.Sqrt(normB)))
+}
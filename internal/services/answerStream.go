@@ -0,0 +1,306 @@
+package services
+
+import (
+	"chat-ai-backend/config"
+	"chat-ai-backend/internal/models"
+	"chat-ai-backend/internal/repositories"
+	"chat-ai-backend/utils"
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrAnswerStreamNotFound is returned when an answer stream never existed or has expired
+var ErrAnswerStreamNotFound = errors.New("answer stream not found")
+
+// Answer chunk types, in the order they appear in a stream
+const (
+	AnswerChunkQueued         = "queued"  // Waiting for earlier questions of the conversation, repeated as the position changes
+	AnswerChunkStarted        = "started" // No longer waiting
+	AnswerChunkStart          = "start"
+	AnswerChunkDelta          = "delta"
+	AnswerChunkToolInvocation = "tool_invocation"
+	AnswerChunkError          = "error"
+	AnswerChunkEnd            = "end"
+	AnswerChunkRejected       = "rejected" // Refused before the answer started, ends the stream instead of an end chunk
+)
+
+// Upper bound on how long an unfinished stream is kept, in case its generator dies
+const answerStreamMaxAge = time.Hour
+
+// How often a running answer checks whether another instance asked to stop it
+const answerCancelPollInterval = time.Second
+
+// AnswerChunk is one entry of an answer stream
+type AnswerChunk struct {
+	Seq        int64                  `json:"-"`
+	Type       string                 `json:"type"`
+	Content    string                 `json:"content,omitempty"`    // Delta text
+	Invocation *models.ToolInvocation `json:"invocation,omitempty"` // Tool call made while answering
+	Error      *answerChunkError      `json:"error,omitempty"`      // Upstream failure, or why the question was rejected
+	Message    *models.Message        `json:"message,omitempty"`    // The message when queued, at start and, complete, at end
+	Position   *int                   `json:"position,omitempty"`   // Questions ahead of a queued one
+}
+
+type answerChunkError struct {
+	Code         string `json:"code"`
+	Message      string `json:"message"`
+	Retryable    bool   `json:"retryable"`
+	RetryAfterMs int64  `json:"retry_after_ms,omitempty"`
+}
+
+// LLMError returns the upstream failure of an error chunk
+func (c AnswerChunk) LLMError() *LLMError {
+	if c.Error == nil {
+		return nil
+	}
+	return &LLMError{
+		Code:       c.Error.Code,
+		Message:    c.Error.Message,
+		Retryable:  c.Error.Retryable,
+		RetryAfter: time.Duration(c.Error.RetryAfterMs) * time.Millisecond,
+	}
+}
+
+// AnswerStreamService generates answers in the background and records every chunk in a Redis Stream,
+// so the answer is finished and stored even if the client leaves, and a reconnecting client can replay it.
+type AnswerStreamService struct {
+	Repo       *repositories.AnswerStreamRepository
+	LLMService *LLMService
+	running    sync.Map // messageID -> context.CancelFunc of answers generated by this instance
+}
+
+// Constructor
+func NewAnswerStreamService(repo *repositories.AnswerStreamRepository, llmService *LLMService) *AnswerStreamService {
+	return &AnswerStreamService{Repo: repo, LLMService: llmService}
+}
+
+// AnswerStream writes the chunks of one answer in order
+type AnswerStream struct {
+	service *AnswerStreamService
+	msg     models.Message
+	seq     int64
+}
+
+// Open prepares the stream of an answer to msg; nothing is recorded until the first chunk
+func (s *AnswerStreamService) Open(msg models.Message) *AnswerStream {
+	return &AnswerStream{service: s, msg: msg}
+}
+
+// MessageID returns the message the stream answers
+func (a *AnswerStream) MessageID() string {
+	return a.msg.MessageID
+}
+
+// Queued records that the question waits behind position others
+func (a *AnswerStream) Queued(position int) error {
+	msg := a.msg
+	return a.write(AnswerChunk{Type: AnswerChunkQueued, Message: &msg, Position: &position}, answerStreamMaxAge)
+}
+
+// Started records that the question no longer waits
+func (a *AnswerStream) Started() error {
+	return a.write(AnswerChunk{Type: AnswerChunkStarted}, answerStreamMaxAge)
+}
+
+// Reject ends the stream of a question that will not be answered
+func (a *AnswerStream) Reject(code, message string) error {
+	return a.write(AnswerChunk{Type: AnswerChunkRejected, Error: &answerChunkError{Code: code, Message: message}}, config.AppConfig.AnswerStreamTTL)
+}
+
+// Generate records the start of the message and generates the answer in the background. finish is called
+// with the final message (answer, status, usage and tool invocations filled in) before the end chunk is written.
+func (a *AnswerStream) Generate(msg models.Message, genReq GenerateRequest, finish func(models.Message)) error {
+	a.msg = msg
+	started := msg
+	if err := a.write(AnswerChunk{Type: AnswerChunkStart, Message: &started}, answerStreamMaxAge); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	a.service.running.Store(msg.MessageID, cancel)
+	go a.service.watchCancel(ctx, msg.MessageID, cancel)
+	go a.generate(ctx, cancel, genReq, finish)
+	return nil
+}
+
+// generate runs the LLM request and writes its events to the stream
+func (a *AnswerStream) generate(ctx context.Context, cancel context.CancelFunc, genReq GenerateRequest, finish func(models.Message)) {
+	msg := a.msg
+	defer a.service.running.Delete(msg.MessageID)
+	defer cancel()
+
+	events := make(chan StreamEvent)
+	go a.service.LLMService.GenerateAIResponse(ctx, genReq, events)
+
+	status := models.MessageStatusCompleted
+	var answer []byte
+	for event := range events {
+		var chunk AnswerChunk
+		switch event.Type {
+		case StreamEventUsage:
+			msg.Usage = event.Usage
+			continue
+		case StreamEventContext:
+			msg.DroppedTurns = event.Dropped
+			continue
+		case StreamEventToolInvocation:
+			msg.ToolInvocations = append(msg.ToolInvocations, *event.Invocation)
+			chunk = AnswerChunk{Type: AnswerChunkToolInvocation, Invocation: event.Invocation}
+		case StreamEventError:
+			// Upstream failures are reported as an error chunk and are never part of the answer
+			status = models.MessageStatusFailed
+			chunk = AnswerChunk{Type: AnswerChunkError, Error: &answerChunkError{
+				Code:         event.Err.Code,
+				Message:      event.Err.Message,
+				Retryable:    event.Err.Retryable,
+				RetryAfterMs: event.Err.RetryAfter.Milliseconds(),
+			}}
+		default:
+			answer = append(answer, event.Content...)
+			chunk = AnswerChunk{Type: AnswerChunkDelta, Content: event.Content}
+		}
+
+		if err := a.write(chunk, answerStreamMaxAge); err != nil {
+			// Listeners miss this chunk, the stored answer is still complete
+			utils.Logger.Error("Failed to record chunk %d of answer %s: %v", a.seq, msg.MessageID, err)
+		}
+	}
+	if ctx.Err() != nil && status == models.MessageStatusCompleted {
+		status = models.MessageStatusCancelled
+	}
+
+	msg.Answer = string(answer)
+	msg.Status = status
+	finish(msg)
+
+	if err := a.write(AnswerChunk{Type: AnswerChunkEnd, Message: &msg}, config.AppConfig.AnswerStreamTTL); err != nil {
+		utils.Logger.Error("Failed to record the end of answer %s: %v", msg.MessageID, err)
+	}
+}
+
+func (a *AnswerStream) write(chunk AnswerChunk, ttl time.Duration) error {
+	a.seq++
+	return a.service.append(a.msg.MessageID, a.seq, chunk, ttl)
+}
+
+// watchCancel stops the answer when a stop arrives through another instance
+func (s *AnswerStreamService) watchCancel(ctx context.Context, messageID string, cancel context.CancelFunc) {
+	ticker := time.NewTicker(answerCancelPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			requested, err := s.Repo.CancelRequested(ctx, messageID)
+			if err == nil && requested {
+				utils.Logger.Info("Answer %s stopped from another connection", messageID)
+				cancel()
+				return
+			}
+		}
+	}
+}
+
+func (s *AnswerStreamService) append(messageID string, seq int64, chunk AnswerChunk, ttl time.Duration) error {
+	data, err := json.Marshal(chunk)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return s.Repo.Append(ctx, messageID, seq, string(data), ttl)
+}
+
+// CancelRequested reports whether a stop was asked for the answer through any instance
+func (s *AnswerStreamService) CancelRequested(messageID string) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	requested, err := s.Repo.CancelRequested(ctx, messageID)
+	return err == nil && requested
+}
+
+// Stop cancels an answer, queued or running, whichever instance handles it
+func (s *AnswerStreamService) Stop(messageID string) {
+	if cancel, ok := s.running.Load(messageID); ok {
+		cancel.(context.CancelFunc)()
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.Repo.RequestCancel(ctx, messageID, answerStreamMaxAge); err != nil {
+		utils.Logger.Error("Failed to request stop of answer %s: %v", messageID, err)
+	}
+}
+
+// Lookup returns the message an answer stream was opened for, to check who may resume it
+func (s *AnswerStreamService) Lookup(ctx context.Context, messageID string) (*models.Message, error) {
+	entry, err := s.Repo.First(ctx, messageID)
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil || entry.Seq != 1 {
+		return nil, ErrAnswerStreamNotFound
+	}
+	chunk, err := decodeAnswerChunk(*entry)
+	if err != nil || chunk.Message == nil {
+		return nil, ErrAnswerStreamNotFound
+	}
+	return chunk.Message, nil
+}
+
+// Follow sends the chunks after afterSeq, waiting for new ones until the stream ends or until ctx is done.
+// The channel is closed early if the stream cannot be read.
+func (s *AnswerStreamService) Follow(ctx context.Context, messageID string, afterSeq int64) <-chan AnswerChunk {
+	chunks := make(chan AnswerChunk)
+	go func() {
+		defer close(chunks)
+		for {
+			entries, err := s.Repo.Read(ctx, messageID, afterSeq, 100, 5*time.Second)
+			if err != nil {
+				if ctx.Err() == nil {
+					utils.Logger.Error("Failed to read answer stream %s: %v", messageID, err)
+				}
+				return
+			}
+			if len(entries) == 0 {
+				// Stop waiting for a stream that expired without an end chunk
+				if first, err := s.Repo.First(ctx, messageID); err == nil && first == nil {
+					return
+				}
+			}
+			for _, entry := range entries {
+				chunk, err := decodeAnswerChunk(entry)
+				if err != nil {
+					utils.Logger.Error("Invalid chunk %d in answer stream %s: %v", entry.Seq, messageID, err)
+					return
+				}
+				select {
+				case chunks <- chunk:
+				case <-ctx.Done():
+					return
+				}
+				if chunk.Type == AnswerChunkEnd || chunk.Type == AnswerChunkRejected {
+					return
+				}
+				afterSeq = entry.Seq
+			}
+			if ctx.Err() != nil {
+				return
+			}
+		}
+	}()
+	return chunks
+}
+
+func decodeAnswerChunk(entry repositories.AnswerStreamEntry) (AnswerChunk, error) {
+	var chunk AnswerChunk
+	if err := json.Unmarshal([]byte(entry.Data), &chunk); err != nil {
+		return AnswerChunk{}, err
+	}
+	chunk.Seq = entry.Seq
+	return chunk, nil
+}
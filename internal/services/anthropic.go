@@ -0,0 +1,304 @@
+package services
+
+import (
+	"bytes"
+	"chat-ai-backend/config"
+	"chat-ai-backend/internal/models"
+	"chat-ai-backend/utils"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+const anthropicVersion = "2023-06-01"
+
+// AnthropicService talks to an Anthropic-style messages API with a typed event stream
+type AnthropicService struct {
+	AnthropicKey string
+	AnthropicUrl string
+	Client       *ResilientClient
+}
+
+// Constructor
+func NewAnthropicService(url string, apiKey string, client *ResilientClient) *AnthropicService {
+	return &AnthropicService{
+		AnthropicKey: apiKey,
+		AnthropicUrl: strings.TrimSuffix(url, "/"),
+		Client:       client,
+	}
+}
+
+func (s *AnthropicService) Name() string {
+	return "anthropic"
+}
+
+func (s *AnthropicService) newRequest(ctx context.Context, method, path string, body interface{}) (*http.Request, error) {
+	var reader io.Reader
+	if body != nil {
+		jsonData, err := json.Marshal(body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal payload: %w", err)
+		}
+		reader = bytes.NewReader(jsonData)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, s.AnthropicUrl+path, reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("anthropic-version", anthropicVersion)
+	if s.AnthropicKey != "" {
+		req.Header.Set("x-api-key", s.AnthropicKey)
+	}
+	return req, nil
+}
+
+// StreamChat calls /v1/messages and forwards the text of every content_block_delta event
+func (s *AnthropicService) StreamChat(ctx context.Context, chatReq ChatRequest, out chan<- StreamEvent) error {
+	system, messages := anthropicMessages(chatReq.Messages)
+
+	maxTokens := chatReq.MaxTokens
+	if maxTokens <= 0 {
+		maxTokens = config.AppConfig.LLMMaxTokens
+	}
+
+	payload := map[string]interface{}{
+		"model":      chatReq.Model,
+		"messages":   messages,
+		"max_tokens": maxTokens,
+		"stream":     true,
+	}
+	if system != "" {
+		payload["system"] = system
+	}
+	if chatReq.Temperature != nil {
+		payload["temperature"] = *chatReq.Temperature
+	}
+	if chatReq.TopP != nil {
+		payload["top_p"] = *chatReq.TopP
+	}
+	if len(chatReq.Stop) > 0 {
+		payload["stop_sequences"] = chatReq.Stop
+	}
+	if len(chatReq.Tools) > 0 {
+		tools := make([]map[string]interface{}, 0, len(chatReq.Tools))
+		for _, tool := range chatReq.Tools {
+			tools = append(tools, map[string]interface{}{
+				"name":         tool.Function.Name,
+				"description":  tool.Function.Description,
+				"input_schema": tool.Function.Parameters,
+			})
+		}
+		payload["tools"] = tools
+	}
+
+	req, err := s.newRequest(ctx, http.MethodPost, "/v1/messages", payload)
+	if err != nil {
+		return err
+	}
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// Input tokens arrive with message_start, output tokens with message_delta
+	usage := &models.Usage{Model: chatReq.Model}
+	// tool_use blocks are keyed by content block index; their input arrives as partial JSON
+	toolBlocks := map[int]*ToolCall{}
+	var toolOrder []int
+	return readSSE(resp.Body, func(event, data string) error {
+		var chunk struct {
+			Type    string `json:"type"`
+			Index   int    `json:"index"`
+			Message struct {
+				Usage struct {
+					InputTokens int `json:"input_tokens"`
+				} `json:"usage"`
+			} `json:"message"`
+			ContentBlock struct {
+				Type string `json:"type"`
+				ID   string `json:"id"`
+				Name string `json:"name"`
+			} `json:"content_block"`
+			Delta struct {
+				Type        string `json:"type"`
+				Text        string `json:"text"`
+				PartialJSON string `json:"partial_json"`
+			} `json:"delta"`
+			Usage struct {
+				OutputTokens int `json:"output_tokens"`
+			} `json:"usage"`
+			Error struct {
+				Type    string `json:"type"`
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			utils.Logger.Error("Failed to unmarshal chunk: %v\n", err)
+			return nil
+		}
+		if chunk.Type == "" {
+			chunk.Type = event
+		}
+
+		switch chunk.Type {
+		case "message_start":
+			usage.PromptTokens = chunk.Message.Usage.InputTokens
+		case "message_delta":
+			usage.CompletionTokens = chunk.Usage.OutputTokens
+		case "content_block_start":
+			if chunk.ContentBlock.Type == "tool_use" {
+				if len(toolOrder) >= maxToolCalls {
+					return &LLMError{Code: LLMErrorStream, Message: "LLM service sent an invalid tool call",
+						Err: fmt.Errorf("more than %d tool calls", maxToolCalls)}
+				}
+				toolBlocks[chunk.Index] = &ToolCall{
+					ID:       chunk.ContentBlock.ID,
+					Type:     "function",
+					Function: ToolCallFunction{Name: chunk.ContentBlock.Name},
+				}
+				toolOrder = append(toolOrder, chunk.Index)
+			}
+		case "content_block_delta":
+			if chunk.Delta.Text != "" {
+				out <- StreamEvent{Type: StreamEventDelta, Content: chunk.Delta.Text}
+			}
+			if call, ok := toolBlocks[chunk.Index]; ok {
+				call.Function.Arguments += chunk.Delta.PartialJSON
+			}
+		case "message_stop":
+			if len(toolOrder) > 0 {
+				calls := make([]ToolCall, 0, len(toolOrder))
+				for _, idx := range toolOrder {
+					calls = append(calls, *toolBlocks[idx])
+				}
+				out <- StreamEvent{Type: StreamEventToolCalls, ToolCalls: calls}
+			}
+			usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+			if usage.TotalTokens > 0 {
+				out <- StreamEvent{Type: StreamEventUsage, Usage: usage}
+			}
+			return io.EOF
+		case "error":
+			llmErr := &LLMError{Code: LLMErrorStream, Message: chunk.Error.Message}
+			if chunk.Error.Type == "overloaded_error" || chunk.Error.Type == "rate_limit_error" {
+				llmErr.Retryable = true
+			}
+			return llmErr
+		}
+		return nil
+	})
+}
+
+// anthropicMessages splits off the system prompt and converts tool calls and results into content blocks
+func anthropicMessages(messages []ChatMessage) (string, []map[string]interface{}) {
+	var system []string
+	converted := make([]map[string]interface{}, 0, len(messages))
+	for _, msg := range messages {
+		switch {
+		case msg.Role == "system":
+			// System prompts are a top-level field rather than a message
+			system = append(system, msg.Content)
+		case msg.Role == "tool":
+			block := map[string]interface{}{
+				"type":        "tool_result",
+				"tool_use_id": msg.ToolCallID,
+				"content":     msg.Content,
+			}
+			// Results of parallel calls belong in one user message
+			if last := len(converted) - 1; last >= 0 && converted[last]["role"] == "user" {
+				if blocks, ok := converted[last]["content"].([]map[string]interface{}); ok {
+					converted[last]["content"] = append(blocks, block)
+					continue
+				}
+			}
+			converted = append(converted, map[string]interface{}{
+				"role":    "user",
+				"content": []map[string]interface{}{block},
+			})
+		case len(msg.ToolCalls) > 0:
+			var blocks []map[string]interface{}
+			if msg.Content != "" {
+				blocks = append(blocks, map[string]interface{}{"type": "text", "text": msg.Content})
+			}
+			for _, call := range msg.ToolCalls {
+				input := json.RawMessage(call.Function.Arguments)
+				if !json.Valid(input) {
+					input = json.RawMessage("{}")
+				}
+				blocks = append(blocks, map[string]interface{}{
+					"type":  "tool_use",
+					"id":    call.ID,
+					"name":  call.Function.Name,
+					"input": input,
+				})
+			}
+			converted = append(converted, map[string]interface{}{"role": msg.Role, "content": blocks})
+		case len(msg.Images) > 0:
+			var blocks []map[string]interface{}
+			for _, image := range msg.Images {
+				blocks = append(blocks, map[string]interface{}{
+					"type": "image",
+					"source": map[string]interface{}{
+						"type":       "base64",
+						"media_type": image.MediaType,
+						"data":       base64.StdEncoding.EncodeToString(image.Data),
+					},
+				})
+			}
+			if msg.Content != "" {
+				blocks = append(blocks, map[string]interface{}{"type": "text", "text": msg.Content})
+			}
+			converted = append(converted, map[string]interface{}{"role": msg.Role, "content": blocks})
+		default:
+			converted = append(converted, map[string]interface{}{"role": msg.Role, "content": msg.Content})
+		}
+	}
+	return strings.Join(system, "\n\n"), converted
+}
+
+// ListModels returns the model IDs from /v1/models
+func (s *AnthropicService) ListModels(ctx context.Context) ([]string, error) {
+	req, err := s.newRequest(ctx, http.MethodGet, "/v1/models", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Data []struct {
+			ID string `json:"id"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode models: %w", err)
+	}
+
+	models := make([]string, 0, len(result.Data))
+	for _, m := range result.Data {
+		models = append(models, m.ID)
+	}
+	return models, nil
+}
+
+// Embeddings is not offered by Anthropic-style APIs
+func (s *AnthropicService) Embeddings(ctx context.Context, model string, inputs []string) ([][]float32, error) {
+	return nil, ErrEmbeddingsNotSupported
+}
+
+// Moderate is not offered by Anthropic-style APIs
+func (s *AnthropicService) Moderate(ctx context.Context, model string, inputs []string) ([]ModerationResult, error) {
+	return nil, ErrModerationNotSupported
+}
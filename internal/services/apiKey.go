@@ -0,0 +1,112 @@
+package services
+
+import (
+	"chat-ai-backend/internal/models"
+	"chat-ai-backend/internal/repositories"
+	"chat-ai-backend/utils"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+var (
+	// ErrInvalidAPIKey is returned for keys that do not exist or were revoked
+	ErrInvalidAPIKey = errors.New("invalid api key")
+	// ErrInvalidAPIKeyName is returned for empty or overly long key names
+	ErrInvalidAPIKeyName = errors.New("api key name must be 1 to 64 characters")
+)
+
+// Every key starts with this, so leaked keys are easy to recognise
+const apiKeyPrefix = "sk-chat-"
+
+// Characters of the key kept in clear to tell keys apart
+const apiKeyVisibleLength = len(apiKeyPrefix) + 4
+
+// LastUsedAt is written at most this often per key
+const apiKeyTouchInterval = time.Minute
+
+// APIKeyService issues and checks the API keys used by the OpenAI-compatible API
+type APIKeyService struct {
+	Repo *repositories.APIKeyRepository
+}
+
+// Constructor
+func NewAPIKeyService(repo *repositories.APIKeyRepository) *APIKeyService {
+	return &APIKeyService{Repo: repo}
+}
+
+// CreateAPIKey issues a new key. The key itself is only returned here, it cannot be read again.
+func (s *APIKeyService) CreateAPIKey(userID, name string) (string, *models.APIKey, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || utf8.RuneCountInString(name) > 64 {
+		return "", nil, ErrInvalidAPIKeyName
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", nil, err
+	}
+	plain := apiKeyPrefix + base64.RawURLEncoding.EncodeToString(secret)
+
+	key := models.APIKey{
+		UserID:    userID,
+		Name:      name,
+		Prefix:    plain[:apiKeyVisibleLength],
+		Hash:      hashAPIKey(plain),
+		CreatedAt: time.Now(),
+	}
+	id, err := s.Repo.SaveAPIKey(key)
+	if err != nil {
+		return "", nil, err
+	}
+	key.ID = id
+	utils.Logger.Info("API key %s (%s) created for user %s", key.ID, key.Prefix, userID)
+	return plain, &key, nil
+}
+
+// Authenticate returns the key matching a key sent by a client
+func (s *APIKeyService) Authenticate(plain string) (*models.APIKey, error) {
+	if !strings.HasPrefix(plain, apiKeyPrefix) {
+		return nil, ErrInvalidAPIKey
+	}
+	key, err := s.Repo.FindAPIKeyByHash(hashAPIKey(plain))
+	if err != nil {
+		if errors.Is(err, repositories.ErrAPIKeyNotFound) {
+			return nil, ErrInvalidAPIKey
+		}
+		return nil, err
+	}
+
+	now := time.Now()
+	if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) > apiKeyTouchInterval {
+		if err := s.Repo.TouchAPIKey(key.ID, now); err != nil {
+			utils.Logger.Warn("Failed to record use of API key %s: %v", key.ID, err)
+		}
+	}
+	return key, nil
+}
+
+// ListAPIKeys returns the keys of a user without their secrets
+func (s *APIKeyService) ListAPIKeys(userID string) ([]models.APIKey, error) {
+	return s.Repo.ListAPIKeys(userID)
+}
+
+// DeleteAPIKey revokes a key of the user
+func (s *APIKeyService) DeleteAPIKey(userID, keyID string) error {
+	if err := s.Repo.DeleteAPIKey(userID, keyID); err != nil {
+		return err
+	}
+	utils.Logger.Info("API key %s of user %s revoked", keyID, userID)
+	return nil
+}
+
+// Keys are long random strings, so a fast unsalted hash is enough
+func hashAPIKey(plain string) string {
+	sum := sha256.Sum256([]byte(plain))
+	return hex.EncodeToString(sum[:])
+}
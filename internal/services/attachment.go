@@ -0,0 +1,199 @@
+package services
+
+import (
+	"chat-ai-backend/config"
+	"chat-ai-backend/internal/models"
+	"chat-ai-backend/internal/repositories"
+	"chat-ai-backend/utils"
+	"context"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+var (
+	// ErrAttachmentTooLarge is returned for uploads above AttachmentMaxBytes
+	ErrAttachmentTooLarge = errors.New("attachment is too large")
+	// ErrUnsupportedAttachment is returned for content types outside AttachmentTypes
+	ErrUnsupportedAttachment = errors.New("unsupported attachment type")
+	// ErrTooManyAttachments is returned when a question carries more than AttachmentMaxPerMsg files
+	ErrTooManyAttachments = errors.New("too many attachments")
+)
+
+// Longest text attachment added to the prompt, in runes
+const attachmentTextMaxRunes = 20000
+
+// AttachmentContent is an attachment together with its bytes, ready to be added to a prompt
+type AttachmentContent struct {
+	Attachment models.Attachment
+	Data       []byte
+}
+
+// AttachmentService stores uploaded files in the blob store and loads them for prompts
+type AttachmentService struct {
+	Repo  *repositories.AttachmentRepository
+	Store repositories.BlobStore
+}
+
+// Constructor
+func NewAttachmentService(repo *repositories.AttachmentRepository, store repositories.BlobStore) *AttachmentService {
+	return &AttachmentService{Repo: repo, Store: store}
+}
+
+// DetectAttachmentType sniffs the content type from the bytes rather than trusting the client
+func DetectAttachmentType(data []byte) (string, error) {
+	contentType, _, err := mime.ParseMediaType(http.DetectContentType(data))
+	if err != nil {
+		return "", ErrUnsupportedAttachment
+	}
+	for _, allowed := range config.AppConfig.AttachmentTypes {
+		if contentType == allowed {
+			return contentType, nil
+		}
+	}
+	return "", fmt.Errorf("%w: %s", ErrUnsupportedAttachment, contentType)
+}
+
+// Upload validates a file, stores its bytes and saves the metadata
+func (s *AttachmentService) Upload(ctx context.Context, userID, filename string, data []byte) (*models.Attachment, error) {
+	if int64(len(data)) > config.AppConfig.AttachmentMaxBytes {
+		return nil, ErrAttachmentTooLarge
+	}
+	contentType, err := DetectAttachmentType(data)
+	if err != nil {
+		return nil, err
+	}
+
+	id := s.Repo.NewAttachmentID()
+	attachment := models.Attachment{
+		ID:          id,
+		UserID:      userID,
+		Filename:    filename,
+		ContentType: contentType,
+		Size:        int64(len(data)),
+		StorageKey:  userID + "/" + id + attachmentExtension(filename),
+		URL:         "/api/v1/attachments/" + id + "/content",
+		CreatedAt:   time.Now(),
+	}
+
+	if err := s.Store.Put(ctx, attachment.StorageKey, contentType, data); err != nil {
+		utils.Logger.Error("Failed to store attachment %s of user %s: %v", id, userID, err)
+		return nil, err
+	}
+	if err := s.Repo.SaveAttachment(attachment); err != nil {
+		// Do not leave an unreachable blob behind
+		if delErr := s.Store.Delete(ctx, attachment.StorageKey); delErr != nil {
+			utils.Logger.Warn("Failed to remove blob %s: %v", attachment.StorageKey, delErr)
+		}
+		return nil, err
+	}
+
+	utils.Logger.Info("Stored attachment %s (%s, %d bytes) for user %s", id, contentType, len(data), userID)
+	return &attachment, nil
+}
+
+// attachmentExtension keeps a short alphanumeric extension of the original name for the blob key
+func attachmentExtension(filename string) string {
+	ext := strings.ToLower(filepath.Ext(filename))
+	if len(ext) < 2 || len(ext) > 8 {
+		return ""
+	}
+	for _, c := range ext[1:] {
+		if (c < 'a' || c > 'z') && (c < '0' || c > '9') {
+			return ""
+		}
+	}
+	return ext
+}
+
+// ListAttachments returns the user's attachments, newest first
+func (s *AttachmentService) ListAttachments(userID string) ([]models.Attachment, error) {
+	return s.Repo.ListAttachments(userID)
+}
+
+// OpenAttachment returns an attachment of the user together with its bytes
+func (s *AttachmentService) OpenAttachment(ctx context.Context, userID, attachmentID string) (*models.Attachment, []byte, error) {
+	attachment, err := s.Repo.GetAttachment(userID, attachmentID)
+	if err != nil {
+		return nil, nil, err
+	}
+	data, err := s.Store.Get(ctx, attachment.StorageKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	return attachment, data, nil
+}
+
+// DeleteAttachment removes the metadata and the bytes. Messages keep naming the file but can no longer show it.
+func (s *AttachmentService) DeleteAttachment(ctx context.Context, userID, attachmentID string) error {
+	attachment, err := s.Repo.GetAttachment(userID, attachmentID)
+	if err != nil {
+		return err
+	}
+	if err := s.Repo.DeleteAttachment(userID, attachmentID); err != nil {
+		return err
+	}
+	if err := s.Store.Delete(ctx, attachment.StorageKey); err != nil {
+		utils.Logger.Warn("Failed to remove blob %s: %v", attachment.StorageKey, err)
+	}
+	return nil
+}
+
+// ResolveAttachments looks up the attachments a question refers to, making sure they belong to the user
+func (s *AttachmentService) ResolveAttachments(userID string, attachmentIDs []string) ([]models.Attachment, error) {
+	if len(attachmentIDs) == 0 {
+		return nil, nil
+	}
+
+	seen := map[string]bool{}
+	unique := make([]string, 0, len(attachmentIDs))
+	for _, id := range attachmentIDs {
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+	if len(unique) > config.AppConfig.AttachmentMaxPerMsg {
+		return nil, ErrTooManyAttachments
+	}
+	return s.Repo.GetAttachments(userID, unique)
+}
+
+// LoadContent reads the bytes of the attachments for a prompt
+func (s *AttachmentService) LoadContent(ctx context.Context, attachments []models.Attachment) ([]AttachmentContent, error) {
+	contents := make([]AttachmentContent, 0, len(attachments))
+	for _, attachment := range attachments {
+		data, err := s.Store.Get(ctx, attachment.StorageKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read attachment %s: %w", attachment.ID, err)
+		}
+		contents = append(contents, AttachmentContent{Attachment: attachment, Data: data})
+	}
+	return contents, nil
+}
+
+// attachmentPrompt turns attachments into text appended to the question and images for vision models.
+// Models without vision only learn the names of attached images.
+func attachmentPrompt(contents []AttachmentContent, vision bool) (string, []ChatImage) {
+	var b strings.Builder
+	var images []ChatImage
+	for _, content := range contents {
+		attachment := content.Attachment
+		switch {
+		case attachment.IsImage() && vision:
+			images = append(images, ChatImage{MediaType: attachment.ContentType, Data: content.Data})
+		case attachment.IsImage():
+			fmt.Fprintf(&b, "\n\n[Attached image %s, which the current model cannot view]", attachment.Filename)
+		case strings.HasPrefix(attachment.ContentType, "text/") && utf8.Valid(content.Data):
+			fmt.Fprintf(&b, "\n\n[Attached file %s]\n%s", attachment.Filename, truncateRunes(string(content.Data), attachmentTextMaxRunes))
+		default:
+			fmt.Fprintf(&b, "\n\n[Attached file %s (%s)]", attachment.Filename, attachment.ContentType)
+		}
+	}
+	return b.String(), images
+}
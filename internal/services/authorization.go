@@ -0,0 +1,90 @@
+package services
+
+import (
+	"chat-ai-backend/internal/models"
+	"chat-ai-backend/internal/repositories"
+	"context"
+	"errors"
+)
+
+// ConversationFinder looks up conversations; implemented by repositories.ConversationRepository
+type ConversationFinder interface {
+	GetConversationByID(convoID string) (*models.Conversation, error)
+}
+
+// MessageFinder looks up messages wherever they are kept; implemented by repositories.RedisMessageRepository
+type MessageFinder interface {
+	FindMessage(messageID string) (*models.Message, error)
+}
+
+// AnswerFinder looks up the message an answer stream was opened for; implemented by AnswerStreamService
+type AnswerFinder interface {
+	Lookup(ctx context.Context, messageID string) (*models.Message, error)
+}
+
+// AuthorizationService decides who may act on conversations and their messages. A resource the user
+// may not access is reported as not found, so its existence is not revealed.
+type AuthorizationService struct {
+	ConversationRepo ConversationFinder
+	MessageRepo      MessageFinder
+	AnswerStreams    AnswerFinder
+}
+
+// Constructor
+func NewAuthorizationService(convoRepo ConversationFinder, msgRepo MessageFinder, answerStreams AnswerFinder) *AuthorizationService {
+	return &AuthorizationService{ConversationRepo: convoRepo, MessageRepo: msgRepo, AnswerStreams: answerStreams}
+}
+
+// mayAccessConversation is the access policy of conversations: only the owner may read or change a
+// conversation and its messages, as conversations are not shared. Every check goes through it.
+func mayAccessConversation(convo *models.Conversation, userID string) bool {
+	return convo != nil && userID != "" && convo.UserID == userID
+}
+
+// AuthorizeConversation returns the conversation if the user may access it, otherwise
+// ErrConversationNotFound
+func (s *AuthorizationService) AuthorizeConversation(userID, conversationID string) (*models.Conversation, error) {
+	convo, err := s.ConversationRepo.GetConversationByID(conversationID)
+	if err != nil {
+		return nil, err
+	}
+	if !mayAccessConversation(convo, userID) {
+		return nil, repositories.ErrConversationNotFound
+	}
+	return convo, nil
+}
+
+// AuthorizeMessage returns the message if the user may access its conversation, otherwise
+// ErrMessageNotFound
+func (s *AuthorizationService) AuthorizeMessage(userID, messageID string) (*models.Message, error) {
+	msg, err := s.MessageRepo.FindMessage(messageID)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.AuthorizeConversation(userID, msg.ConversationID); err != nil {
+		if errors.Is(err, repositories.ErrConversationNotFound) {
+			return nil, repositories.ErrMessageNotFound
+		}
+		return nil, err
+	}
+	return msg, nil
+}
+
+// AuthorizeAnswer returns the message an answer stream was opened for if it answers in conversationID
+// and the user may access that conversation, otherwise ErrAnswerStreamNotFound
+func (s *AuthorizationService) AuthorizeAnswer(ctx context.Context, userID, conversationID, messageID string) (*models.Message, error) {
+	msg, err := s.AnswerStreams.Lookup(ctx, messageID)
+	if err != nil {
+		return nil, err
+	}
+	if msg.ConversationID != conversationID {
+		return nil, ErrAnswerStreamNotFound
+	}
+	if _, err := s.AuthorizeConversation(userID, msg.ConversationID); err != nil {
+		if errors.Is(err, repositories.ErrConversationNotFound) {
+			return nil, ErrAnswerStreamNotFound
+		}
+		return nil, err
+	}
+	return msg, nil
+}
@@ -0,0 +1,77 @@
+package services
+
+import (
+	"chat-ai-backend/internal/models"
+	"chat-ai-backend/internal/repositories"
+	"context"
+	"errors"
+	"testing"
+)
+
+// fakeAuthorizationStore holds the conversations and answer streams the authorization service looks up
+type fakeAuthorizationStore struct {
+	conversations map[string]*models.Conversation
+	answers       map[string]*models.Message
+}
+
+func (s *fakeAuthorizationStore) GetConversationByID(convoID string) (*models.Conversation, error) {
+	convo, ok := s.conversations[convoID]
+	if !ok {
+		return nil, repositories.ErrConversationNotFound
+	}
+	return convo, nil
+}
+
+func (s *fakeAuthorizationStore) FindMessage(messageID string) (*models.Message, error) {
+	return nil, repositories.ErrMessageNotFound
+}
+
+func (s *fakeAuthorizationStore) Lookup(ctx context.Context, messageID string) (*models.Message, error) {
+	msg, ok := s.answers[messageID]
+	if !ok {
+		return nil, ErrAnswerStreamNotFound
+	}
+	return msg, nil
+}
+
+func TestAuthorizeAnswer(t *testing.T) {
+	store := &fakeAuthorizationStore{
+		conversations: map[string]*models.Conversation{
+			"conv-alice":       {UserID: "alice"},
+			"conv-alice-other": {UserID: "alice"},
+			"conv-bob":         {UserID: "bob"},
+		},
+		answers: map[string]*models.Message{
+			"answer-alice": {MessageID: "answer-alice", ConversationID: "conv-alice"},
+			"answer-bob":   {MessageID: "answer-bob", ConversationID: "conv-bob"},
+		},
+	}
+	authorization := NewAuthorizationService(store, store, store)
+
+	tests := []struct {
+		name           string
+		conversationID string
+		messageID      string
+		allowed        bool
+	}{
+		{"owner", "conv-alice", "answer-alice", true},
+		{"answer of a foreign conversation", "conv-bob", "answer-bob", false},
+		{"foreign answer through an owned conversation", "conv-alice", "answer-bob", false},
+		{"answer of another owned conversation", "conv-alice-other", "answer-alice", false},
+		{"missing answer", "conv-alice", "answer-missing", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg, err := authorization.AuthorizeAnswer(context.Background(), "alice", tt.conversationID, tt.messageID)
+			if tt.allowed {
+				if err != nil || msg == nil || msg.MessageID != tt.messageID {
+					t.Fatalf("AuthorizeAnswer = %v, %v, want message %s", msg, err, tt.messageID)
+				}
+				return
+			}
+			if !errors.Is(err, ErrAnswerStreamNotFound) {
+				t.Fatalf("AuthorizeAnswer = %v, %v, want ErrAnswerStreamNotFound", msg, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,426 @@
+package services
+
+import (
+	"chat-ai-backend/internal/models"
+	"chat-ai-backend/internal/repositories"
+	"chat-ai-backend/utils"
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Kinds of chat request
+const (
+	ChatQuestion   = "question"   // Continue the active branch
+	ChatRegenerate = "regenerate" // Answer the question of an earlier message again
+	ChatEdit       = "edit"       // Ask a different question in place of an earlier message
+)
+
+// ChatTurnRequest is a question sent over any of the chat transports
+type ChatTurnRequest struct {
+	Type          string
+	MessageID     string // Message regenerated or edited
+	Content       string
+	AttachmentIDs []string // Left nil, an edit keeps the attachments of the original question
+	Metadata      map[string]string
+	Origin        string // Connection that asked, which follows the answer itself instead of through the broadcast
+}
+
+// ChatHooks are optional callbacks of a transport for work that happens after the answer ends
+type ChatHooks struct {
+	Done func() // The answer is stored in Redis, or the queued question was rejected
+}
+
+// ChatError is a refused chat request. Code is a stable identifier sent to clients.
+type ChatError struct {
+	Code    string
+	Message string
+	Err     error
+}
+
+func (e *ChatError) Error() string {
+	if e.Err != nil {
+		return e.Code + ": " + e.Err.Error()
+	}
+	return e.Code + ": " + e.Message
+}
+
+func (e *ChatError) Unwrap() error {
+	return e.Err
+}
+
+// ChatService runs the question and answer pipeline shared by the WebSocket and HTTP transports:
+// branch placement, attachments, quota, document retrieval, generation and storing the answer.
+type ChatService struct {
+	ConversationService *ConversationService
+	RedisMessageService *RedisMessageService
+	UsageService        *UsageService
+	TitleService        *TitleService
+	SummaryService      *SummaryService
+	DocumentService     *DocumentService
+	AttachmentService   *AttachmentService
+	AnswerStreamService *AnswerStreamService
+	EventService        *ConversationEventService
+	LockService         *ConversationLockService
+}
+
+// Constructor
+func NewChatService(convoSvc *ConversationService, redisMsgSvc *RedisMessageService, usageSvc *UsageService, titleSvc *TitleService, summarySvc *SummaryService, documentSvc *DocumentService, attachmentSvc *AttachmentService, answerStreamSvc *AnswerStreamService, eventSvc *ConversationEventService, lockSvc *ConversationLockService) *ChatService {
+	return &ChatService{
+		ConversationService: convoSvc,
+		RedisMessageService: redisMsgSvc,
+		UsageService:        usageSvc,
+		TitleService:        titleSvc,
+		SummaryService:      summarySvc,
+		DocumentService:     documentSvc,
+		AttachmentService:   attachmentSvc,
+		AnswerStreamService: answerStreamSvc,
+		EventService:        eventSvc,
+		LockService:         lockSvc,
+	}
+}
+
+// preparedTurn is a question placed in the conversation, ready to be answered
+type preparedTurn struct {
+	convo         *models.Conversation
+	history       []models.Message
+	firstExchange bool
+	msg           models.Message
+	genReq        GenerateRequest
+}
+
+// Start answers the question in the background. Questions of a conversation are answered one at a
+// time in the order they arrive on any instance: when another answer is in progress the question is
+// queued, and its place in the branch is decided once its turn comes.
+// It returns the new message as announced to clients; the answer can be followed through the
+// AnswerStreamService and is stored whether or not anyone follows it.
+func (s *ChatService) Start(userID, conversationID string, req ChatTurnRequest, hooks ChatHooks) (*models.Message, error) {
+	// Refuse questions to unknown conversations before they take a place in the queue
+	if _, err := s.loadConversation(userID, conversationID); err != nil {
+		return nil, err
+	}
+
+	ticket, err := s.LockService.Enqueue(conversationID)
+	if err != nil {
+		if errors.Is(err, ErrQueueFull) {
+			return nil, &ChatError{Code: "queue_full", Message: "Too many questions are waiting in this conversation", Err: err}
+		}
+		utils.Logger.Error("Failed to queue question in conversation %s: %v", conversationID, err)
+		return nil, &ChatError{Code: "queue_unavailable", Message: "Failed to queue the question", Err: err}
+	}
+	messageID := uuid.New().String()
+
+	lock, position, err := ticket.TryAcquire()
+	if err != nil {
+		ticket.Leave()
+		utils.Logger.Error("Failed to lock conversation %s: %v", conversationID, err)
+		return nil, &ChatError{Code: "queue_unavailable", Message: "Failed to queue the question", Err: err}
+	}
+	if lock != nil {
+		turn, err := s.prepare(userID, conversationID, messageID, req)
+		if err != nil {
+			lock.Release()
+			return nil, err
+		}
+		if err := s.generate(s.AnswerStreamService.Open(turn.msg), turn, lock, hooks); err != nil {
+			return nil, err
+		}
+		s.announce(conversationID, messageID, req.Origin)
+		return &turn.msg, nil
+	}
+
+	// Wait for the earlier questions in the background; the client follows the stream meanwhile
+	msg := models.Message{
+		MessageID:      messageID,
+		UserID:         userID,
+		ConversationID: conversationID,
+		Question:       req.Content,
+		Metadata:       req.Metadata,
+	}
+	stream := s.AnswerStreamService.Open(msg)
+	if err := stream.Queued(position); err != nil {
+		ticket.Leave()
+		utils.Logger.Error("Failed to start answer stream for user %s: %v", userID, err)
+		return nil, &ChatError{Code: "answer_unavailable", Message: "Failed to start the answer", Err: err}
+	}
+	go s.waitAndAnswer(ticket, position, stream, userID, conversationID, req, hooks)
+	s.announce(conversationID, messageID, req.Origin)
+	return &msg, nil
+}
+
+// waitAndAnswer answers a queued question once the questions before it are answered
+func (s *ChatService) waitAndAnswer(ticket *QueueTicket, position int, stream *AnswerStream, userID, conversationID string, req ChatTurnRequest, hooks ChatHooks) {
+	reject := func(chatErr *ChatError) {
+		if err := stream.Reject(chatErr.Code, chatErr.Message); err != nil {
+			utils.Logger.Error("Failed to record rejection of answer %s: %v", stream.MessageID(), err)
+		}
+		if hooks.Done != nil {
+			hooks.Done()
+		}
+	}
+
+	lock, err := ticket.Wait(position, func(position int) {
+		if err := stream.Queued(position); err != nil {
+			utils.Logger.Error("Failed to record queue position of answer %s: %v", stream.MessageID(), err)
+		}
+	}, func() bool {
+		return s.AnswerStreamService.CancelRequested(stream.MessageID())
+	})
+	if err != nil {
+		if errors.Is(err, ErrQueueCancelled) {
+			reject(&ChatError{Code: "cancelled", Message: "The question was withdrawn before it was answered", Err: err})
+			return
+		}
+		utils.Logger.Error("Queued question %s in conversation %s failed: %v", stream.MessageID(), conversationID, err)
+		reject(&ChatError{Code: "queue_unavailable", Message: "Failed to queue the question", Err: err})
+		return
+	}
+	if err := stream.Started(); err != nil {
+		utils.Logger.Error("Failed to record start of answer %s: %v", stream.MessageID(), err)
+	}
+
+	turn, err := s.prepare(userID, conversationID, stream.MessageID(), req)
+	if err != nil {
+		lock.Release()
+		var chatErr *ChatError
+		if !errors.As(err, &chatErr) {
+			chatErr = &ChatError{Code: "answer_unavailable", Message: "Failed to start the answer", Err: err}
+		}
+		reject(chatErr)
+		return
+	}
+	if err := s.generate(stream, turn, lock, hooks); err != nil {
+		reject(err)
+	}
+}
+
+// generate starts the answer of a prepared turn; the lock is released once the answer is stored
+func (s *ChatService) generate(stream *AnswerStream, turn *preparedTurn, lock *ConversationLock, hooks ChatHooks) *ChatError {
+	err := stream.Generate(turn.msg, turn.genReq, func(final models.Message) {
+		s.storeAnswer(turn.convo, turn.history, final, turn.firstExchange, hooks)
+		lock.Release()
+	})
+	if err != nil {
+		lock.Release()
+		utils.Logger.Error("Failed to start answer stream for user %s: %v", turn.msg.UserID, err)
+		return &ChatError{Code: "answer_unavailable", Message: "Failed to start the answer", Err: err}
+	}
+	return nil
+}
+
+// announce lets other devices on the conversation stream the answer too
+func (s *ChatService) announce(conversationID, messageID, origin string) {
+	s.EventService.Publish(ConversationEvent{
+		Type:           ConversationEventAnswerStarted,
+		ConversationID: conversationID,
+		MessageID:      messageID,
+		Origin:         origin,
+	})
+}
+
+func (s *ChatService) loadConversation(userID, conversationID string) (*models.Conversation, error) {
+	convo, err := s.ConversationService.GetConversation(userID, conversationID)
+	if err != nil {
+		if errors.Is(err, repositories.ErrConversationNotFound) {
+			return nil, &ChatError{Code: "conversation_not_found", Message: "Conversation not found", Err: err}
+		}
+		return nil, &ChatError{Code: "conversation_unavailable", Message: "Failed to load conversation", Err: err}
+	}
+	return convo, nil
+}
+
+// prepare places the question in the conversation and gathers what the model needs to answer it.
+// It runs while holding the conversation lock, so it sees every earlier answer.
+func (s *ChatService) prepare(userID, conversationID, messageID string, req ChatTurnRequest) (*preparedTurn, error) {
+	// The conversation is re-read every time: settings and the active branch may have changed
+	convo, err := s.loadConversation(userID, conversationID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Fetch the conversation history so the model sees previous turns
+	tree, err := s.RedisMessageService.LoadMessageTree(conversationID, convo.ActiveMessageID, req.MessageID)
+	if err != nil {
+		utils.Logger.Error("Failed to load history for conversation %s: %v\n", conversationID, err)
+		tree = BuildMessageTree(nil)
+	}
+	firstExchange := err == nil && len(tree.Nodes) == 0
+
+	// A new question continues the active branch; regenerate and edit add a sibling of an earlier message
+	question := req.Content
+	parentID := tree.ActiveLeaf(convo.ActiveMessageID)
+	var attachments []models.Attachment
+	if len(req.AttachmentIDs) > 0 {
+		attachments, err = s.AttachmentService.ResolveAttachments(userID, req.AttachmentIDs)
+		if err != nil {
+			return nil, attachmentChatError(err)
+		}
+	}
+	if req.Type == ChatRegenerate || req.Type == ChatEdit {
+		target, ok := tree.Nodes[req.MessageID]
+		if !ok {
+			return nil, &ChatError{Code: "message_not_found", Message: "Message not found"}
+		}
+		parentID = target.ParentID
+		if req.Type == ChatRegenerate {
+			question = target.Message.Question
+		}
+		// Regenerating, or editing without naming attachments, keeps the files of the original question
+		if (req.Type == ChatRegenerate || req.AttachmentIDs == nil) && len(target.Message.Attachments) > 0 {
+			ids := make([]string, 0, len(target.Message.Attachments))
+			for _, attachment := range target.Message.Attachments {
+				ids = append(ids, attachment.ID)
+			}
+			attachments, err = s.AttachmentService.ResolveAttachments(userID, ids)
+			if err != nil {
+				return nil, attachmentChatError(err)
+			}
+		}
+	}
+	if question == "" && len(attachments) == 0 {
+		return nil, &ChatError{Code: "empty_question", Message: "Question must not be empty"}
+	}
+	history := tree.PathTo(parentID)
+	truncated := len(history) > 0 && history[0].ParentMessageID != nil && *history[0].ParentMessageID != ""
+	if truncated && convo.Summary != nil && convo.Summary.CoveredUntilID != "" && !containsMessage(history, convo.Summary.CoveredUntilID) {
+		// The branch starts before the cached messages and so does the end of the summary
+		if full, err := s.RedisMessageService.LoadFullMessageTree(conversationID); err == nil {
+			history = full.PathTo(parentID)
+		}
+	}
+	summary, recent := ApplySummary(convo.Summary, history)
+
+	// Refuse the question before calling the LLM if the user is over quota
+	if err := s.UsageService.CheckQuota(userID); err != nil {
+		utils.Logger.Warn("User %s is over quota: %v", userID, err)
+		return nil, &ChatError{Code: "quota_exceeded", Message: "Daily usage quota exceeded", Err: err}
+	}
+
+	// Look up the parts of the user's documents that relate to the question
+	retrieveCtx, cancelRetrieve := context.WithTimeout(context.Background(), 10*time.Second)
+	excerpts, err := s.DocumentService.RetrieveExcerpts(retrieveCtx, userID, question)
+	cancelRetrieve()
+	if err != nil {
+		utils.Logger.Warn("Document retrieval failed for user %s: %v", userID, err)
+	}
+
+	// Read the attached files so they can be sent to the model
+	var attachmentContents []AttachmentContent
+	if len(attachments) > 0 {
+		loadCtx, cancelLoad := context.WithTimeout(context.Background(), 30*time.Second)
+		attachmentContents, err = s.AttachmentService.LoadContent(loadCtx, attachments)
+		cancelLoad()
+		if err != nil {
+			utils.Logger.Error("Failed to load attachments for user %s: %v", userID, err)
+			return nil, &ChatError{Code: "attachment_unavailable", Message: "Failed to read attachment", Err: err}
+		}
+	}
+
+	msg := models.Message{
+		MessageID:       messageID,
+		UserID:          userID,
+		ConversationID:  conversationID,
+		ParentMessageID: &parentID,
+		VersionIndex:    s.RedisMessageService.VersionIndex(tree, conversationID, parentID),
+		Question:        question,
+		Attachments:     attachments,
+		Metadata:        req.Metadata,
+	}
+	if len(attachments) > 0 {
+		msg.InputURL = attachments[0].URL
+	}
+
+	return &preparedTurn{
+		convo:         convo,
+		history:       history,
+		firstExchange: firstExchange,
+		msg:           msg,
+		genReq: GenerateRequest{
+			UserID:         userID,
+			ConversationID: conversationID,
+			Message:        question,
+			History:        recent,
+			Settings:       convo.Settings,
+			Summary:        summary,
+			Documents:      excerpts,
+			Attachments:    attachmentContents,
+		},
+	}, nil
+}
+
+// SelectBranch makes the branch through messageID the one the conversation shows and returns its newest leaf
+func (s *ChatService) SelectBranch(conversationID, messageID string) (string, error) {
+	tree, err := s.RedisMessageService.LoadMessageTree(conversationID, messageID)
+	if err != nil {
+		return "", &ChatError{Code: "conversation_unavailable", Message: "Failed to load conversation", Err: err}
+	}
+	if _, ok := tree.Nodes[messageID]; !ok {
+		return "", &ChatError{Code: "message_not_found", Message: "Message not found"}
+	}
+	leafID := tree.LatestLeaf(messageID)
+	if err := s.ConversationService.SetActiveMessage(conversationID, leafID); err != nil {
+		return "", &ChatError{Code: "branch_not_selected", Message: "Failed to select branch", Err: err}
+	}
+	return leafID, nil
+}
+
+func containsMessage(messages []models.Message, messageID string) bool {
+	for _, msg := range messages {
+		if msg.MessageID == messageID {
+			return true
+		}
+	}
+	return false
+}
+
+// storeAnswer stores a finished answer, partial answers included, and runs the work that follows it
+func (s *ChatService) storeAnswer(convo *models.Conversation, history []models.Message, msg models.Message, firstExchange bool, hooks ChatHooks) {
+	utils.Logger.Info("AI response for user %s (%s): %s", msg.UserID, msg.Status, msg.Answer)
+
+	// The conversation may have been moved to MongoDB while the answer was generated
+	if _, err := s.RedisMessageService.LoadMsgIntoRedis(msg.ConversationID); err != nil {
+		utils.Logger.Error("Failed to reload conversation %s into Redis: %v", msg.ConversationID, err)
+	}
+	if err := s.RedisMessageService.StoreOneMsgInRedis(msg); err != nil {
+		utils.Logger.Error("Failed to store message %s in Redis: %v", msg.MessageID, err)
+	}
+	s.UsageService.RecordUsage(msg.UserID, msg.Usage)
+
+	// Fold older turns into the summary once the branch gets long
+	s.SummaryService.SummarizeAsync(msg.UserID, msg.ConversationID, convo.Summary, append(history, msg))
+
+	// The new message becomes the end of the branch the conversation shows
+	if err := s.ConversationService.SetActiveMessage(msg.ConversationID, msg.MessageID); err != nil {
+		utils.Logger.Error("Failed to set active message of conversation %s: %v", msg.ConversationID, err)
+	}
+
+	// Name the conversation after its first completed exchange
+	if firstExchange && msg.Status == models.MessageStatusCompleted && !convo.CustomTitle {
+		s.TitleService.GenerateTitleAsync(msg.UserID, msg.ConversationID, msg.Question, msg.Answer, func(title string) {
+			s.EventService.Publish(ConversationEvent{
+				Type:           ConversationEventTitleUpdated,
+				ConversationID: msg.ConversationID,
+				Data:           map[string]interface{}{"conversation_id": msg.ConversationID, "title": title},
+			})
+		})
+	}
+
+	if hooks.Done != nil {
+		hooks.Done()
+	}
+}
+
+// attachmentChatError tells the client why the attachments of a question were refused
+func attachmentChatError(err error) *ChatError {
+	switch {
+	case errors.Is(err, repositories.ErrAttachmentNotFound):
+		return &ChatError{Code: "attachment_not_found", Message: "Attachment not found", Err: err}
+	case errors.Is(err, ErrTooManyAttachments):
+		return &ChatError{Code: "too_many_attachments", Message: err.Error(), Err: err}
+	default:
+		utils.Logger.Error("Failed to resolve attachments: %v", err)
+		return &ChatError{Code: "attachment_unavailable", Message: "Failed to read attachment", Err: err}
+	}
+}
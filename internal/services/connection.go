@@ -0,0 +1,97 @@
+package services
+
+import (
+	"chat-ai-backend/config"
+	"chat-ai-backend/internal/repositories"
+	"chat-ai-backend/utils"
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Pings refresh the lease, so a connection outlives a few missed refreshes but not a dead instance
+const connectionLeasePings = 3
+
+// ConnectionService enforces the per-user and global caps on concurrent WebSocket connections and
+// tracks which conversations they follow
+type ConnectionService struct {
+	Repo *repositories.ConnectionRepository
+}
+
+// Constructor
+func NewConnectionService(repo *repositories.ConnectionRepository) *ConnectionService {
+	return &ConnectionService{Repo: repo}
+}
+
+func connectionLease() time.Duration {
+	return connectionLeasePings * config.AppConfig.WSPingInterval
+}
+
+// Acquire registers a new connection of the user and returns its ID, or
+// repositories.ErrUserConnectionLimit / repositories.ErrConnectionLimit when a cap is reached
+func (s *ConnectionService) Acquire(userID string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	connectionID := uuid.New().String()
+	err := s.Repo.AcquireConnection(ctx, userID, connectionID, connectionLease(), config.AppConfig.WSMaxConnsPerUser, config.AppConfig.WSMaxConns)
+	if err != nil {
+		return "", err
+	}
+	return connectionID, nil
+}
+
+// Refresh keeps the connection counted; it is called on every ping
+func (s *ConnectionService) Refresh(userID, conversationID, connectionID string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := s.Repo.RefreshConnection(ctx, userID, conversationID, connectionID, connectionLease()); err != nil {
+		utils.Logger.Warn("Failed to refresh connection %s of user %s: %v", connectionID, userID, err)
+	}
+}
+
+// Release stops counting a closed connection
+func (s *ConnectionService) Release(userID, connectionID string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := s.Repo.ReleaseConnection(ctx, userID, connectionID); err != nil {
+		utils.Logger.Error("Failed to release connection %s of user %s: %v", connectionID, userID, err)
+	}
+}
+
+// Join records that the connection follows the conversation and relies on its Redis cache
+func (s *ConnectionService) Join(conversationID, connectionID string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := s.Repo.JoinConversation(ctx, conversationID, connectionID, connectionLease()); err != nil {
+		utils.Logger.Error("Failed to register connection %s on conversation %s: %v", connectionID, conversationID, err)
+	}
+}
+
+// Leave stops counting the connection as a follower of the conversation
+func (s *ConnectionService) Leave(conversationID, connectionID string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := s.Repo.LeaveConversation(ctx, conversationID, connectionID); err != nil {
+		utils.Logger.Error("Failed to remove connection %s from conversation %s: %v", connectionID, conversationID, err)
+	}
+}
+
+// Connected reports whether a connection on any instance still follows the conversation. When Redis
+// cannot tell, it reports false, so the conversation is flushed to MongoDB rather than left behind.
+func (s *ConnectionService) Connected(conversationID string) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	count, err := s.Repo.CountConversationConnections(ctx, conversationID)
+	if err != nil {
+		utils.Logger.Error("Failed to count connections of conversation %s: %v", conversationID, err)
+		return false
+	}
+	return count > 0
+}
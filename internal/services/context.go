@@ -0,0 +1,148 @@
+package services
+
+import (
+	"chat-ai-backend/internal/models"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"unicode/utf8"
+)
+
+// Rough per-message overhead the chat format adds on top of the content (role, separators)
+const messageTokenOverhead = 4
+
+// Rough prompt cost of one image, vision models bill a few hundred tokens per tile
+const imageTokenEstimate = 800
+
+// ChatMessage is a single entry of the "messages" array sent to the LLM
+type ChatMessage struct {
+	Role       string      `json:"role"`
+	Content    string      `json:"content"`
+	ToolCalls  []ToolCall  `json:"tool_calls,omitempty"`   // Set on assistant messages that call tools
+	ToolCallID string      `json:"tool_call_id,omitempty"` // Set on "tool" messages carrying a result
+	Images     []ChatImage `json:"-"`                      // Images sent with a user message to vision models
+}
+
+// ChatImage is an image embedded in a user message
+type ChatImage struct {
+	MediaType string // e.g. image/png
+	Data      []byte
+}
+
+// DataURL encodes the image as a base64 data URL
+func (i ChatImage) DataURL() string {
+	return "data:" + i.MediaType + ";base64," + base64.StdEncoding.EncodeToString(i.Data)
+}
+
+// MarshalJSON writes the OpenAI message format, turning the content into parts when images are attached
+func (m ChatMessage) MarshalJSON() ([]byte, error) {
+	type plain ChatMessage
+	if len(m.Images) == 0 {
+		return json.Marshal(plain(m))
+	}
+
+	parts := []map[string]interface{}{}
+	if m.Content != "" {
+		parts = append(parts, map[string]interface{}{"type": "text", "text": m.Content})
+	}
+	for _, image := range m.Images {
+		parts = append(parts, map[string]interface{}{
+			"type":      "image_url",
+			"image_url": map[string]string{"url": image.DataURL()},
+		})
+	}
+	return json.Marshal(struct {
+		plain
+		Content []map[string]interface{} `json:"content"`
+	}{plain(m), parts})
+}
+
+// ContextWindow is the prompt built for one request, together with the turns left out of it
+type ContextWindow struct {
+	Messages          []ChatMessage
+	Tokens            int
+	DroppedMessageIDs []string // MessageIDs of older turns that did not fit into the budget
+}
+
+// EstimateTokens approximates the token count of a text (about 4 characters per token)
+func EstimateTokens(text string) int {
+	n := utf8.RuneCountInString(text)
+	if n == 0 {
+		return 0
+	}
+	return (n + 3) / 4
+}
+
+func estimateMessageTokens(content string) int {
+	return EstimateTokens(content) + messageTokenOverhead
+}
+
+// estimateTurnTokens is the prompt cost of replaying a stored question/answer pair
+func estimateTurnTokens(turn models.Message) int {
+	return estimateMessageTokens(turnQuestion(turn)) + estimateMessageTokens(turn.Answer)
+}
+
+// turnQuestion is the question of a stored turn as replayed to the model. Earlier attachments are
+// only named, their content is sent with the question they were attached to.
+func turnQuestion(turn models.Message) string {
+	if len(turn.Attachments) == 0 {
+		return turn.Question
+	}
+	names := make([]string, 0, len(turn.Attachments))
+	for _, attachment := range turn.Attachments {
+		names = append(names, attachment.Filename)
+	}
+	return turn.Question + "\n[Attached: " + strings.Join(names, ", ") + "]"
+}
+
+// BuildContextWindow builds the prompt from the system prompt, the stored question/answer pairs
+// and the new question. History is kept newest first until the budget is used up; the system
+// prompt and the new question are always included.
+func BuildContextWindow(systemPrompt string, history []models.Message, question string, budget int) ContextWindow {
+	window := ContextWindow{}
+
+	// Failed turns have no answer worth replaying
+	turns := make([]models.Message, 0, len(history))
+	for _, msg := range history {
+		if msg.Status != models.MessageStatusFailed {
+			turns = append(turns, msg)
+		}
+	}
+	history = turns
+
+	used := estimateMessageTokens(question)
+	if systemPrompt != "" {
+		used += estimateMessageTokens(systemPrompt)
+	}
+
+	// Walk history from the newest turn backwards and keep as many whole turns as fit
+	kept := 0
+	for i := len(history) - 1; i >= 0; i-- {
+		turn := history[i]
+		cost := estimateTurnTokens(turn)
+		if used+cost > budget {
+			break
+		}
+		used += cost
+		kept++
+	}
+
+	firstKept := len(history) - kept
+	for _, msg := range history[:firstKept] {
+		window.DroppedMessageIDs = append(window.DroppedMessageIDs, msg.MessageID)
+	}
+
+	if systemPrompt != "" {
+		window.Messages = append(window.Messages, ChatMessage{Role: "system", Content: systemPrompt})
+	}
+	for _, turn := range history[firstKept:] {
+		window.Messages = append(window.Messages, ChatMessage{Role: "user", Content: turnQuestion(turn)})
+		if turn.Answer != "" {
+			window.Messages = append(window.Messages, ChatMessage{Role: "assistant", Content: turn.Answer})
+		}
+	}
+	window.Messages = append(window.Messages, ChatMessage{Role: "user", Content: question})
+	window.Tokens = used
+
+	return window
+}
@@ -3,6 +3,11 @@
 package services
 
 import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
 	"time"
 
 	"chat-ai-backend/internal/models"
@@ -10,20 +15,75 @@ import (
 	"chat-ai-backend/utils"
 )
 
+// ErrInvalidSettings is returned when conversation settings fail validation
+var ErrInvalidSettings = errors.New("invalid conversation settings")
+
+// ErrInvalidConversationUpdate is returned when tags, pinned or archived fail validation
+var ErrInvalidConversationUpdate = errors.New("invalid conversation update")
+
+// ErrInvalidConversationQuery is returned for unknown sort orders of the conversation list
+var ErrInvalidConversationQuery = errors.New("invalid conversation query")
+
+// Limits of the conversation list and of the tags of a conversation
+const (
+	DefaultConversationPageSize = 20
+	MaxConversationPageSize     = 100
+	maxConversationTags         = 20
+	maxConversationTagLen       = 32
+)
+
+// Sort orders of the conversation list, by the field they sort on
+var conversationSorts = map[string]string{
+	"created":      repositories.ConversationSortCreated,
+	"updated":      repositories.ConversationSortUpdated,
+	"last_message": repositories.ConversationSortLastMessage,
+}
+
+// ConversationQuery selects a page of a user's conversations
+type ConversationQuery struct {
+	Sort      string // created, updated or last_message; updated if empty
+	Ascending bool   // Oldest first instead of newest first
+	Filter    repositories.ConversationFilter
+	Cursor    string // NextCursor of the previous page, empty for the first one
+	Limit     int    // DefaultConversationPageSize if 0
+}
+
+// ConversationPage is one page of the conversation list. NextCursor loads the following page and is
+// empty once HasMore is false.
+type ConversationPage struct {
+	Conversations []models.Conversation
+	NextCursor    string
+	HasMore       bool
+}
+
+// ConversationUpdate changes how a conversation is organised; nil fields are left alone
+type ConversationUpdate struct {
+	Tags     *[]string
+	Pinned   *bool
+	Archived *bool
+}
+
 type ConversationService struct {
-	Repo *repositories.ConversationRepository
+	Repo         *repositories.ConversationRepository
+	EventService *ConversationEventService
 }
 
-func NewConversationService(repo *repositories.ConversationRepository) *ConversationService {
-	return &ConversationService{Repo: repo}
+func NewConversationService(repo *repositories.ConversationRepository, eventSvc *ConversationEventService) *ConversationService {
+	return &ConversationService{Repo: repo, EventService: eventSvc}
 }
 
+// DefaultConversationTitle is the title of a conversation until one is chosen or generated
+const DefaultConversationTitle = "New Conversation"
+
 // CreateOrFetchConversation handles conversation creation or retrieval
 func (s *ConversationService) CreateOrFetchConversation(userID, title string) (string, error) {
+	now := time.Now()
 	conversation := models.Conversation{
-		UserID:    userID,
-		Title:     title,
-		CreatedAt: time.Now(),
+		UserID:      userID,
+		Title:       title,
+		CustomTitle: title != DefaultConversationTitle,
+		CreatedAt:   now,
+		UpdatedAt:   &now,
 	}
 	conversationID, err := s.Repo.SaveConversation(conversation)
 	if err != nil {
@@ -34,8 +94,28 @@ func (s *ConversationService) CreateOrFetchConversation(userID, title string) (s
 	return conversationID, nil
 }
 
-// DeleteConversation deletes MongoDB conversation and its associated messages
-func (s *ConversationService) DeleteConversation(conversationID string) error {
+// FindOrCreateConversation returns the user's newest conversation with the given title, creating it if there is none
+func (s *ConversationService) FindOrCreateConversation(userID, title string) (*models.Conversation, error) {
+	convo, err := s.Repo.FindConversationByTitle(userID, title)
+	if err == nil {
+		return convo, nil
+	}
+	if !errors.Is(err, repositories.ErrConversationNotFound) {
+		return nil, err
+	}
+	conversationID, err := s.CreateOrFetchConversation(userID, title)
+	if err != nil {
+		return nil, err
+	}
+	return s.Repo.GetConversationByID(conversationID)
+}
+
+// DeleteConversation deletes a conversation of the user and its associated messages
+func (s *ConversationService) DeleteConversation(userID, conversationID string) error {
+	if _, err := s.GetConversation(userID, conversationID); err != nil {
+		return err
+	}
+
 	err := s.Repo.DeleteConversation(conversationID)
 	if err != nil {
 		utils.Logger.Error("Failed to delete conversation and messages: %v\n", err)
@@ -45,13 +125,221 @@ func (s *ConversationService) DeleteConversation(conversationID string) error {
 	return nil
 }
 
-// UpdateConversationTitle updates the title of a conversation
-func (s *ConversationService) UpdateConversationTitle(conversationID, title string) error {
-	err := s.Repo.UpdateConversationTitle(conversationID, title)
+// UpdateConversationTitle sets a title chosen by the user, which is never replaced by a generated one
+func (s *ConversationService) UpdateConversationTitle(userID, conversationID, title string) error {
+	if _, err := s.GetConversation(userID, conversationID); err != nil {
+		return err
+	}
+
+	err := s.Repo.UpdateConversationTitle(conversationID, title, true)
 	if err != nil {
 		utils.Logger.Error("Failed to update conversation title: %v\n", err)
 		return err
 	}
 	utils.Logger.Info("Successfully updated conversation title in mongo: %s", conversationID)
+
+	// Show the new title on every device the conversation is open on
+	s.EventService.Publish(ConversationEvent{
+		Type:           ConversationEventTitleUpdated,
+		ConversationID: conversationID,
+		Data:           map[string]interface{}{"conversation_id": conversationID, "title": title},
+	})
+	return nil
+}
+
+// GetConversation returns a conversation if it belongs to the user
+func (s *ConversationService) GetConversation(userID, conversationID string) (*models.Conversation, error) {
+	convo, err := s.Repo.GetConversationByID(conversationID)
+	if err != nil {
+		return nil, err
+	}
+	if !mayAccessConversation(convo, userID) {
+		return nil, repositories.ErrConversationNotFound
+	}
+	return convo, nil
+}
+
+// GetConversationSettings returns the settings of a conversation, empty if none were set
+func (s *ConversationService) GetConversationSettings(userID, conversationID string) (*models.ConversationSettings, error) {
+	convo, err := s.GetConversation(userID, conversationID)
+	if err != nil {
+		return nil, err
+	}
+	if convo.Settings == nil {
+		return &models.ConversationSettings{}, nil
+	}
+	return convo.Settings, nil
+}
+
+// UpdateConversationSettings validates and stores the settings of a conversation
+func (s *ConversationService) UpdateConversationSettings(userID, conversationID string, settings models.ConversationSettings) error {
+	if err := validateSettings(settings); err != nil {
+		return err
+	}
+
+	if _, err := s.GetConversation(userID, conversationID); err != nil {
+		return err
+	}
+
+	if err := s.Repo.UpdateConversationSettings(conversationID, settings); err != nil {
+		utils.Logger.Error("Failed to update conversation settings: %v\n", err)
+		return err
+	}
+	utils.Logger.Info("Successfully updated conversation settings in mongo: %s", conversationID)
+	return nil
+}
+
+func validateSettings(settings models.ConversationSettings) error {
+	if settings.Temperature != nil && (*settings.Temperature < 0 || *settings.Temperature > 2) {
+		return fmt.Errorf("%w: temperature must be between 0 and 2", ErrInvalidSettings)
+	}
+	if settings.TopP != nil && (*settings.TopP < 0 || *settings.TopP > 1) {
+		return fmt.Errorf("%w: top_p must be between 0 and 1", ErrInvalidSettings)
+	}
+	if settings.MaxTokens < 0 {
+		return fmt.Errorf("%w: max_tokens must not be negative", ErrInvalidSettings)
+	}
+	if len(settings.Stop) > 4 {
+		return fmt.Errorf("%w: at most 4 stop sequences are allowed", ErrInvalidSettings)
+	}
+	return nil
+}
+
+// GetConversationSummary returns the rolling summary of a conversation, nil if it has none yet
+func (s *ConversationService) GetConversationSummary(userID, conversationID string) (*models.ConversationSummary, error) {
+	convo, err := s.GetConversation(userID, conversationID)
+	if err != nil {
+		return nil, err
+	}
+	return convo.Summary, nil
+}
+
+// SetActiveMessage switches the branch a conversation continues from
+func (s *ConversationService) SetActiveMessage(conversationID, messageID string) error {
+	err := s.Repo.UpdateActiveMessage(conversationID, messageID)
+	if err != nil {
+		utils.Logger.Error("Failed to update active message: %v\n", err)
+		return err
+	}
 	return nil
 }
+
+// ListConversations returns a page of the user's conversations in the query's order
+func (s *ConversationService) ListConversations(userID string, query ConversationQuery) (*ConversationPage, error) {
+	if query.Sort == "" {
+		query.Sort = "updated"
+	}
+	sortField, ok := conversationSorts[query.Sort]
+	if !ok {
+		return nil, fmt.Errorf("%w: sort must be created, updated or last_message", ErrInvalidConversationQuery)
+	}
+	if query.Limit <= 0 {
+		query.Limit = DefaultConversationPageSize
+	}
+	query.Limit = min(query.Limit, MaxConversationPageSize)
+
+	var after *repositories.ConversationCursor
+	if query.Cursor != "" {
+		decoded, err := decodeConversationCursor(query.Cursor, query.Sort, query.Ascending)
+		if err != nil {
+			return nil, err
+		}
+		after = decoded
+	}
+
+	convos, hasMore, err := s.Repo.ListConversations(userID, sortField, query.Ascending, query.Filter, after, query.Limit)
+	if err != nil {
+		utils.Logger.Error("Failed to list conversations of user %s: %v\n", userID, err)
+		return nil, err
+	}
+	page := &ConversationPage{Conversations: convos, HasMore: hasMore}
+	if hasMore && len(convos) > 0 {
+		page.NextCursor = encodeConversationCursor(convos[len(convos)-1], query.Sort, query.Ascending)
+	}
+	return page, nil
+}
+
+// conversationSortValue is the value of the sort field of a conversation, nil if it has none
+func conversationSortValue(convo models.Conversation, sort string) *time.Time {
+	switch sort {
+	case "created":
+		return &convo.CreatedAt
+	case "last_message":
+		return convo.LastMessageAt
+	default:
+		return convo.UpdatedAt
+	}
+}
+
+// encodeConversationCursor makes the opaque cursor of the conversations after convo. It records the
+// order, so a cursor cannot be used with another one.
+func encodeConversationCursor(convo models.Conversation, sort string, ascending bool) string {
+	value := ""
+	if at := conversationSortValue(convo, sort); at != nil {
+		value = strconv.FormatInt(at.UnixMilli(), 10)
+	}
+	raw := strings.Join([]string{sort, strconv.FormatBool(ascending), value, convo.ID}, "|")
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeConversationCursor(cursor, sort string, ascending bool) (*repositories.ConversationCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	parts := strings.Split(string(raw), "|")
+	if len(parts) != 4 || parts[0] != sort || parts[1] != strconv.FormatBool(ascending) || len(parts[3]) != 24 {
+		return nil, ErrInvalidCursor
+	}
+	decoded := &repositories.ConversationCursor{ID: parts[3]}
+	if parts[2] != "" {
+		millis, err := strconv.ParseInt(parts[2], 10, 64)
+		if err != nil {
+			return nil, ErrInvalidCursor
+		}
+		at := time.UnixMilli(millis)
+		decoded.Value = &at
+	}
+	return decoded, nil
+}
+
+// UpdateConversationOrganization changes the tags, pinned or archived state of one of the user's conversations
+func (s *ConversationService) UpdateConversationOrganization(userID, conversationID string, update ConversationUpdate) error {
+	if update.Tags != nil {
+		tags, err := normalizeTags(*update.Tags)
+		if err != nil {
+			return err
+		}
+		update.Tags = &tags
+	}
+
+	if _, err := s.GetConversation(userID, conversationID); err != nil {
+		return err
+	}
+
+	if err := s.Repo.UpdateConversationOrganization(conversationID, update.Tags, update.Pinned, update.Archived); err != nil {
+		utils.Logger.Error("Failed to update conversation %s: %v\n", conversationID, err)
+		return err
+	}
+	return nil
+}
+
+// normalizeTags trims tags and drops duplicates, keeping the given order
+func normalizeTags(tags []string) ([]string, error) {
+	normalized := make([]string, 0, len(tags))
+	seen := make(map[string]bool, len(tags))
+	for _, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		if tag == "" || len([]rune(tag)) > maxConversationTagLen {
+			return nil, fmt.Errorf("%w: tags must be 1-%d characters", ErrInvalidConversationUpdate, maxConversationTagLen)
+		}
+		if !seen[tag] {
+			seen[tag] = true
+			normalized = append(normalized, tag)
+		}
+	}
+	if len(normalized) > maxConversationTags {
+		return nil, fmt.Errorf("%w: at most %d tags are allowed", ErrInvalidConversationUpdate, maxConversationTags)
+	}
+	return normalized, nil
+}
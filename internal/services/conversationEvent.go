@@ -0,0 +1,119 @@
+package services
+
+import (
+	"chat-ai-backend/internal/repositories"
+	"chat-ai-backend/utils"
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Conversation event types
+const (
+	ConversationEventAnswerStarted   = "answer_started"   // Follow the message's answer stream for its chunks
+	ConversationEventTitleUpdated    = "title_updated"    // Data: conversation_id, title
+	ConversationEventFeedbackUpdated = "feedback_updated" // Data: message_id, feedback, thumb_up
+)
+
+// Events buffered per connection before a slow one starts missing them
+const conversationEventBuffer = 64
+
+// ConversationEvent is broadcast to every device connected to a conversation, on any replica
+type ConversationEvent struct {
+	Type           string                 `json:"type"`
+	ConversationID string                 `json:"conversation_id"`
+	MessageID      string                 `json:"message_id,omitempty"`
+	Origin         string                 `json:"origin,omitempty"` // Connection that caused the event, if any
+	Data           map[string]interface{} `json:"data,omitempty"`
+}
+
+// ConversationEventService fans conversation events out to the local connections of this replica.
+// The replica holds one Redis subscription, with a channel per conversation that has local connections.
+type ConversationEventService struct {
+	Repo        *repositories.ConversationEventRepository
+	mu          sync.Mutex
+	pubsub      *redis.PubSub
+	subscribers map[string]map[chan ConversationEvent]struct{} // conversationID -> local connections
+}
+
+// Constructor
+func NewConversationEventService(repo *repositories.ConversationEventRepository) *ConversationEventService {
+	return &ConversationEventService{
+		Repo:        repo,
+		subscribers: make(map[string]map[chan ConversationEvent]struct{}),
+	}
+}
+
+// Publish broadcasts an event to every connection of its conversation
+func (s *ConversationEventService) Publish(event ConversationEvent) {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		utils.Logger.Error("Failed to encode %s event: %v", event.Type, err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.Repo.Publish(ctx, event.ConversationID, string(payload)); err != nil {
+		utils.Logger.Error("Failed to publish %s event of conversation %s: %v", event.Type, event.ConversationID, err)
+	}
+}
+
+// Subscribe returns the events of a conversation until unsubscribe is called
+func (s *ConversationEventService) Subscribe(conversationID string) (events <-chan ConversationEvent, unsubscribe func()) {
+	ch := make(chan ConversationEvent, conversationEventBuffer)
+	channel := repositories.ConversationEventChannel(conversationID)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.pubsub == nil {
+		s.pubsub = s.Repo.Subscribe(context.Background())
+		go s.dispatch(s.pubsub.Channel())
+	}
+	subs, ok := s.subscribers[conversationID]
+	if !ok {
+		subs = make(map[chan ConversationEvent]struct{})
+		s.subscribers[conversationID] = subs
+		if err := s.pubsub.Subscribe(context.Background(), channel); err != nil {
+			utils.Logger.Error("Failed to subscribe to conversation %s: %v", conversationID, err)
+		}
+	}
+	subs[ch] = struct{}{}
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			delete(subs, ch)
+			if len(subs) == 0 {
+				delete(s.subscribers, conversationID)
+				if err := s.pubsub.Unsubscribe(context.Background(), channel); err != nil {
+					utils.Logger.Error("Failed to unsubscribe from conversation %s: %v", conversationID, err)
+				}
+			}
+		})
+	}
+}
+
+// dispatch hands every received event to the local connections of its conversation
+func (s *ConversationEventService) dispatch(messages <-chan *redis.Message) {
+	for message := range messages {
+		var event ConversationEvent
+		if err := json.Unmarshal([]byte(message.Payload), &event); err != nil {
+			utils.Logger.Error("Invalid event on %s: %v", message.Channel, err)
+			continue
+		}
+		s.mu.Lock()
+		for ch := range s.subscribers[event.ConversationID] {
+			select {
+			case ch <- event:
+			default:
+				utils.Logger.Warn("Dropping %s event of conversation %s for a slow connection", event.Type, event.ConversationID)
+			}
+		}
+		s.mu.Unlock()
+	}
+}
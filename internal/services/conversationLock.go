@@ -0,0 +1,161 @@
+package services
+
+import (
+	"chat-ai-backend/config"
+	"chat-ai-backend/internal/repositories"
+	"chat-ai-backend/utils"
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	// ErrQueueFull is returned when too many questions already wait in the conversation
+	ErrQueueFull = errors.New("conversation queue is full")
+	// ErrQueueCancelled is returned when a waiting question is withdrawn
+	ErrQueueCancelled = errors.New("question withdrawn from the queue")
+	// ErrQueueTicketLost is returned when a waiting question dropped out of the queue
+	ErrQueueTicketLost = errors.New("question is no longer queued")
+)
+
+// How often a waiting question checks whether its turn has come
+const queuePollInterval = 500 * time.Millisecond
+
+// A waiting question that stops checking for this long is dropped from the queue
+const queueTicketTTL = 10 * time.Second
+
+// ConversationLockService answers the questions of a conversation one at a time, in the order they
+// arrived on any instance, so two answers never interleave their writes to the conversation.
+type ConversationLockService struct {
+	Repo *repositories.ConversationLockRepository
+}
+
+// Constructor
+func NewConversationLockService(repo *repositories.ConversationLockRepository) *ConversationLockService {
+	return &ConversationLockService{Repo: repo}
+}
+
+// QueueTicket is the place of a question in the queue of its conversation
+type QueueTicket struct {
+	service        *ConversationLockService
+	conversationID string
+	id             string
+}
+
+// ConversationLock is held while a question of the conversation is answered; its lease is renewed until Release
+type ConversationLock struct {
+	service        *ConversationLockService
+	conversationID string
+	id             string
+	stop           chan struct{}
+	once           sync.Once
+}
+
+// Enqueue puts a question at the end of the queue of the conversation
+func (s *ConversationLockService) Enqueue(conversationID string) (*QueueTicket, error) {
+	ticket := &QueueTicket{service: s, conversationID: conversationID, id: uuid.New().String()}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	added, err := s.Repo.Enqueue(ctx, conversationID, ticket.id, config.AppConfig.ConversationQueueMax, queueTicketTTL)
+	if err != nil {
+		return nil, err
+	}
+	if !added {
+		return nil, ErrQueueFull
+	}
+	return ticket, nil
+}
+
+// TryAcquire returns the lock if the question is next and the conversation is free. Otherwise it
+// returns how many questions are ahead, the one being answered included.
+func (t *QueueTicket) TryAcquire() (*ConversationLock, int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	lease := config.AppConfig.ConversationLockLease
+	acquired, position, err := t.service.Repo.TryAcquire(ctx, t.conversationID, t.id, lease, queueTicketTTL)
+	if err != nil {
+		return nil, 0, err
+	}
+	if position < 0 {
+		return nil, 0, ErrQueueTicketLost
+	}
+	if !acquired {
+		return nil, position, nil
+	}
+
+	lock := &ConversationLock{service: t.service, conversationID: t.conversationID, id: t.id, stop: make(chan struct{})}
+	go lock.renew(lease)
+	return lock, 0, nil
+}
+
+// Wait blocks until the question is next and takes the lock. onPosition is called whenever the number
+// of questions ahead changes; cancelled is checked on every poll and withdraws the question.
+func (t *QueueTicket) Wait(position int, onPosition func(int), cancelled func() bool) (*ConversationLock, error) {
+	for {
+		time.Sleep(queuePollInterval)
+		if cancelled() {
+			t.Leave()
+			return nil, ErrQueueCancelled
+		}
+		lock, ahead, err := t.TryAcquire()
+		if err != nil {
+			t.Leave()
+			return nil, err
+		}
+		if lock != nil {
+			return lock, nil
+		}
+		if ahead != position {
+			position = ahead
+			onPosition(position)
+		}
+	}
+}
+
+// Leave removes a question that will not be answered from the queue
+func (t *QueueTicket) Leave() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := t.service.Repo.LeaveQueue(ctx, t.conversationID, t.id); err != nil {
+		utils.Logger.Error("Failed to leave the queue of conversation %s: %v", t.conversationID, err)
+	}
+}
+
+// renew extends the lease until the lock is released or lost
+func (l *ConversationLock) renew(lease time.Duration) {
+	ticker := time.NewTicker(lease / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			renewed, err := l.service.Repo.RenewLock(ctx, l.conversationID, l.id, lease)
+			cancel()
+			if err != nil {
+				utils.Logger.Error("Failed to renew the lock of conversation %s: %v", l.conversationID, err)
+				continue
+			}
+			if !renewed {
+				utils.Logger.Warn("Lost the lock of conversation %s", l.conversationID)
+				return
+			}
+		}
+	}
+}
+
+// Release lets the next question of the conversation be answered
+func (l *ConversationLock) Release() {
+	l.once.Do(func() {
+		close(l.stop)
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := l.service.Repo.ReleaseLock(ctx, l.conversationID, l.id); err != nil {
+			utils.Logger.Error("Failed to release the lock of conversation %s: %v", l.conversationID, err)
+		}
+	})
+}
@@ -0,0 +1,188 @@
+package services
+
+import (
+	"chat-ai-backend/config"
+	"chat-ai-backend/internal/models"
+	"chat-ai-backend/internal/repositories"
+	"chat-ai-backend/utils"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrEmptyDocument is returned when no text could be extracted from an upload
+var ErrEmptyDocument = errors.New("document contains no text")
+
+// Inputs sent per embeddings request
+const embeddingBatchSize = 64
+
+// DocumentExcerpt is a retrieved chunk that is added to the prompt
+type DocumentExcerpt struct {
+	DocumentID string  `json:"document_id"`
+	Filename   string  `json:"filename"`
+	Text       string  `json:"text"`
+	Score      float32 `json:"score"`
+}
+
+// DocumentService ingests user documents into the vector store and retrieves the relevant chunks for a question
+type DocumentService struct {
+	Repo     *repositories.DocumentRepository
+	Store    repositories.VectorStore
+	Provider LLMProvider
+}
+
+// Constructor
+func NewDocumentService(repo *repositories.DocumentRepository, store repositories.VectorStore, provider LLMProvider) *DocumentService {
+	return &DocumentService{
+		Repo:     repo,
+		Store:    store,
+		Provider: provider,
+	}
+}
+
+// IngestDocument extracts, chunks and embeds an upload, then stores the chunks and the document metadata
+func (s *DocumentService) IngestDocument(ctx context.Context, userID, filename, contentType string, data []byte) (*models.Document, error) {
+	text, err := ExtractText(contentType, data)
+	if err != nil {
+		return nil, err
+	}
+	if text == "" {
+		return nil, ErrEmptyDocument
+	}
+
+	chunks := ChunkText(text, config.AppConfig.RAGChunkTokens, config.AppConfig.RAGChunkOverlapTokens)
+	vectors, err := s.embed(ctx, chunks)
+	if err != nil {
+		return nil, err
+	}
+
+	doc := models.Document{
+		UserID:      userID,
+		Filename:    filename,
+		ContentType: contentType,
+		Size:        int64(len(data)),
+		Chunks:      len(chunks),
+		Tokens:      EstimateTokens(text),
+		CreatedAt:   time.Now(),
+	}
+	doc.ID, err = s.Repo.SaveDocument(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	vectorChunks := make([]repositories.VectorChunk, 0, len(chunks))
+	for i, chunk := range chunks {
+		vectorChunks = append(vectorChunks, repositories.VectorChunk{
+			ID:         uuid.New().String(),
+			DocumentID: doc.ID,
+			Index:      i,
+			Text:       chunk,
+			Vector:     vectors[i],
+		})
+	}
+	if err := s.Store.Upsert(ctx, userID, vectorChunks); err != nil {
+		// Without its chunks the document would be listed but never found
+		if delErr := s.Repo.DeleteDocument(userID, doc.ID); delErr != nil {
+			utils.Logger.Error("Failed to roll back document %s: %v", doc.ID, delErr)
+		}
+		return nil, err
+	}
+
+	utils.Logger.Info("Ingested document %s (%s) for user %s in %d chunks", doc.ID, filename, userID, len(chunks))
+	return &doc, nil
+}
+
+// embed computes the embeddings of the texts in batches
+func (s *DocumentService) embed(ctx context.Context, texts []string) ([][]float32, error) {
+	vectors := make([][]float32, 0, len(texts))
+	for start := 0; start < len(texts); start += embeddingBatchSize {
+		end := start + embeddingBatchSize
+		if end > len(texts) {
+			end = len(texts)
+		}
+		batch, err := s.Provider.Embeddings(ctx, config.AppConfig.LLMEmbeddingModel, texts[start:end])
+		if err != nil {
+			return nil, err
+		}
+		if len(batch) != end-start {
+			return nil, fmt.Errorf("embeddings returned %d vectors for %d inputs", len(batch), end-start)
+		}
+		vectors = append(vectors, batch...)
+	}
+	return vectors, nil
+}
+
+// ListDocuments returns the user's documents, newest first
+func (s *DocumentService) ListDocuments(userID string) ([]models.Document, error) {
+	return s.Repo.ListDocuments(userID)
+}
+
+// DeleteDocument removes a document and its chunks
+func (s *DocumentService) DeleteDocument(ctx context.Context, userID, documentID string) error {
+	if _, err := s.Repo.GetDocument(userID, documentID); err != nil {
+		return err
+	}
+	if err := s.Store.DeleteDocument(ctx, userID, documentID); err != nil {
+		return err
+	}
+	if err := s.Repo.DeleteDocument(userID, documentID); err != nil {
+		return err
+	}
+	utils.Logger.Info("Deleted document %s of user %s", documentID, userID)
+	return nil
+}
+
+// RetrieveExcerpts returns the chunks of the user's documents most similar to the question.
+// Users without documents cost no embeddings request.
+func (s *DocumentService) RetrieveExcerpts(ctx context.Context, userID, question string) ([]DocumentExcerpt, error) {
+	topK := config.AppConfig.RAGTopK
+	if topK <= 0 || question == "" {
+		return nil, nil
+	}
+	has, err := s.Repo.HasDocuments(userID)
+	if err != nil || !has {
+		return nil, err
+	}
+
+	vectors, err := s.Provider.Embeddings(ctx, config.AppConfig.LLMEmbeddingModel, []string{question})
+	if err != nil {
+		return nil, err
+	}
+	if len(vectors) != 1 {
+		return nil, fmt.Errorf("embeddings returned %d vectors for 1 input", len(vectors))
+	}
+
+	matches, err := s.Store.Search(ctx, userID, vectors[0], topK)
+	if err != nil {
+		return nil, err
+	}
+
+	filenames := map[string]string{}
+	var excerpts []DocumentExcerpt
+	for _, match := range matches {
+		if float64(match.Score) < config.AppConfig.RAGMinScore {
+			continue
+		}
+		filename, ok := filenames[match.Chunk.DocumentID]
+		if !ok {
+			// Chunks of a deleted document may linger in the store until its deletion went through
+			if doc, err := s.Repo.GetDocument(userID, match.Chunk.DocumentID); err == nil {
+				filename = doc.Filename
+			}
+			filenames[match.Chunk.DocumentID] = filename
+		}
+		if filename == "" {
+			continue
+		}
+		excerpts = append(excerpts, DocumentExcerpt{
+			DocumentID: match.Chunk.DocumentID,
+			Filename:   filename,
+			Text:       match.Chunk.Text,
+			Score:      match.Score,
+		})
+	}
+	return excerpts, nil
+}
@@ -0,0 +1,212 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"mime"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+)
+
+// Supported document content types
+const (
+	ContentTypeText     = "text/plain"
+	ContentTypeMarkdown = "text/markdown"
+	ContentTypeHTML     = "text/html"
+)
+
+// ErrUnsupportedDocument is returned for files that are not text, Markdown or HTML
+var ErrUnsupportedDocument = errors.New("unsupported document type, upload text, Markdown or HTML")
+
+var blankLines = regexp.MustCompile(`\n{3,}`)
+
+// DetectContentType resolves the document type from the declared content type, falling back to the file extension
+func DetectContentType(filename, declared string) (string, error) {
+	if mediaType, _, err := mime.ParseMediaType(declared); err == nil {
+		switch mediaType {
+		case ContentTypeText, ContentTypeMarkdown, ContentTypeHTML:
+			return mediaType, nil
+		case "text/x-markdown":
+			return ContentTypeMarkdown, nil
+		}
+	}
+
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".txt", ".text":
+		return ContentTypeText, nil
+	case ".md", ".markdown":
+		return ContentTypeMarkdown, nil
+	case ".html", ".htm":
+		return ContentTypeHTML, nil
+	}
+	return "", ErrUnsupportedDocument
+}
+
+// ExtractText returns the readable text of a document. Markdown is kept as is, since models read it well.
+func ExtractText(contentType string, data []byte) (string, error) {
+	if !utf8.Valid(data) {
+		return "", errors.New("document is not valid UTF-8 text")
+	}
+
+	var text string
+	switch contentType {
+	case ContentTypeText, ContentTypeMarkdown:
+		text = string(data)
+	case ContentTypeHTML:
+		var err error
+		if text, err = htmlToText(data); err != nil {
+			return "", err
+		}
+	default:
+		return "", ErrUnsupportedDocument
+	}
+
+	lines := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " \t")
+	}
+	text = blankLines.ReplaceAllString(strings.Join(lines, "\n"), "\n\n")
+	return strings.TrimSpace(text), nil
+}
+
+// Elements whose end starts a new paragraph in the extracted text
+var htmlBlockElements = map[string]bool{
+	"p": true, "div": true, "br": true, "li": true, "tr": true, "section": true, "article": true,
+	"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true, "pre": true, "blockquote": true,
+}
+
+// htmlToText drops markup, scripts and styles and keeps paragraph breaks
+func htmlToText(data []byte) (string, error) {
+	tokenizer := html.NewTokenizer(bytes.NewReader(data))
+	var out strings.Builder
+	skip := 0 // Depth inside script, style and similar elements
+
+	for {
+		switch tokenizer.Next() {
+		case html.ErrorToken:
+			if err := tokenizer.Err(); err != io.EOF {
+				return "", err
+			}
+			return out.String(), nil
+		case html.StartTagToken:
+			name, _ := tokenizer.TagName()
+			switch string(name) {
+			case "script", "style", "noscript", "template", "head":
+				skip++
+			case "br":
+				out.WriteString("\n")
+			}
+		case html.EndTagToken:
+			name, _ := tokenizer.TagName()
+			tag := string(name)
+			switch {
+			case tag == "script" || tag == "style" || tag == "noscript" || tag == "template" || tag == "head":
+				if skip > 0 {
+					skip--
+				}
+			case htmlBlockElements[tag]:
+				out.WriteString("\n\n")
+			}
+		case html.TextToken:
+			if skip > 0 {
+				continue
+			}
+			text := strings.Join(strings.Fields(string(tokenizer.Text())), " ")
+			if text != "" {
+				out.WriteString(text)
+				out.WriteString(" ")
+			}
+		}
+	}
+}
+
+// ChunkText splits text into pieces of about maxTokens, preferring paragraph boundaries. Each chunk
+// starts with the last overlapTokens of the previous one so facts split across a boundary stay findable.
+func ChunkText(text string, maxTokens, overlapTokens int) []string {
+	if maxTokens <= 0 {
+		maxTokens = 300
+	}
+	if overlapTokens < 0 || overlapTokens >= maxTokens {
+		overlapTokens = 0
+	}
+
+	// Paragraphs longer than a chunk are cut into word windows that leave room for the overlap
+	var units []string
+	for _, paragraph := range strings.Split(text, "\n\n") {
+		paragraph = strings.TrimSpace(paragraph)
+		if paragraph == "" {
+			continue
+		}
+		if EstimateTokens(paragraph) <= maxTokens {
+			units = append(units, paragraph)
+			continue
+		}
+		units = append(units, splitWords(paragraph, maxTokens-overlapTokens)...)
+	}
+
+	var chunks []string
+	var current []string
+	currentTokens := 0
+	for _, unit := range units {
+		unitTokens := EstimateTokens(unit)
+		if currentTokens > 0 && currentTokens+unitTokens > maxTokens {
+			chunk := strings.Join(current, "\n\n")
+			chunks = append(chunks, chunk)
+			current, currentTokens = nil, 0
+			if overlap := tailWords(chunk, overlapTokens); overlap != "" && EstimateTokens(overlap)+unitTokens <= maxTokens {
+				current = append(current, overlap)
+				currentTokens = EstimateTokens(overlap)
+			}
+		}
+		current = append(current, unit)
+		currentTokens += unitTokens
+	}
+	if len(current) > 0 {
+		chunks = append(chunks, strings.Join(current, "\n\n"))
+	}
+	return chunks
+}
+
+// splitWords cuts a long paragraph into windows of at most maxTokens
+func splitWords(paragraph string, maxTokens int) []string {
+	var parts []string
+	var current strings.Builder
+	for _, word := range strings.Fields(paragraph) {
+		if current.Len() > 0 && EstimateTokens(current.String())+EstimateTokens(word)+1 > maxTokens {
+			parts = append(parts, current.String())
+			current.Reset()
+		}
+		if current.Len() > 0 {
+			current.WriteString(" ")
+		}
+		current.WriteString(word)
+	}
+	if current.Len() > 0 {
+		parts = append(parts, current.String())
+	}
+	return parts
+}
+
+// tailWords returns the last words of text adding up to about tokens
+func tailWords(text string, tokens int) string {
+	if tokens <= 0 {
+		return ""
+	}
+	words := strings.Fields(text)
+	start := len(words)
+	used := 0
+	for start > 0 {
+		cost := EstimateTokens(words[start-1]) + 1
+		if used+cost > tokens {
+			break
+		}
+		used += cost
+		start--
+	}
+	return strings.Join(words[start:], " ")
+}
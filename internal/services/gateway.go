@@ -0,0 +1,158 @@
+package services
+
+import (
+	"chat-ai-backend/config"
+	"chat-ai-backend/internal/models"
+	"chat-ai-backend/utils"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// GatewayService serves the OpenAI-compatible API: requests go to the configured provider under the
+// caller's quota, are logged per API key and can be recorded into one of the caller's conversations.
+type GatewayService struct {
+	LLMService          *LLMService
+	UsageService        *UsageService
+	ConversationService *ConversationService
+	RedisMessageService *RedisMessageService
+	LockService         *ConversationLockService
+	ModerationService   *ModerationService // nil disables moderation
+}
+
+// Constructor
+func NewGatewayService(llmSvc *LLMService, usageSvc *UsageService, convoSvc *ConversationService, redisMsgSvc *RedisMessageService, lockSvc *ConversationLockService, moderationSvc *ModerationService) *GatewayService {
+	return &GatewayService{
+		LLMService:          llmSvc,
+		UsageService:        usageSvc,
+		ConversationService: convoSvc,
+		RedisMessageService: redisMsgSvc,
+		LockService:         lockSvc,
+		ModerationService:   moderationSvc,
+	}
+}
+
+// ListModels returns the models of the configured provider
+func (s *GatewayService) ListModels(ctx context.Context) ([]string, error) {
+	return s.LLMService.Provider.ListModels(ctx)
+}
+
+// ProviderName returns the name of the configured provider
+func (s *GatewayService) ProviderName() string {
+	return s.LLMService.Provider.Name()
+}
+
+// Complete runs a chat completion for the user, writing deltas to out as they arrive. It returns
+// ErrQuotaExceeded without calling the provider when the user is over quota, and an error wrapping
+// ErrContentFlagged when moderation flags the request or, if enabled, the answer. Streamed answers
+// can only be checked once complete.
+func (s *GatewayService) Complete(ctx context.Context, userID, apiKeyID string, req ChatRequest, out chan<- StreamEvent) (string, *models.Usage, error) {
+	if err := s.UsageService.CheckQuota(userID); err != nil {
+		utils.Logger.Warn("Gateway request of user %s (key %s) refused: %v", userID, apiKeyID, err)
+		return "", nil, err
+	}
+	if s.ModerationService != nil {
+		texts := make([]string, 0, len(req.Messages))
+		for _, msg := range req.Messages {
+			texts = append(texts, msg.Content)
+		}
+		if err := s.ModerationService.Check(ctx, texts); err != nil {
+			if errors.Is(err, ErrContentFlagged) {
+				err = fmt.Errorf("request %w", err)
+			}
+			utils.Logger.Warn("Gateway request of user %s (key %s) refused by moderation: %v", userID, apiKeyID, err)
+			return "", nil, err
+		}
+	}
+
+	started := time.Now()
+	content, usage, err := s.LLMService.Forward(ctx, req, out)
+	if err == nil && s.ModerationService != nil && config.AppConfig.ModerationOutput {
+		if err = s.ModerationService.Check(ctx, []string{content}); errors.Is(err, ErrContentFlagged) {
+			err = fmt.Errorf("answer %w", err)
+		}
+	}
+
+	// Partial and failed answers are billed too
+	s.UsageService.RecordUsage(userID, usage)
+	prompt, completion := 0, 0
+	if usage != nil {
+		prompt, completion = usage.PromptTokens, usage.CompletionTokens
+	}
+	if err != nil {
+		utils.Logger.Error("Gateway request of user %s (key %s, model %s) failed after %v: %v", userID, apiKeyID, req.Model, time.Since(started), err)
+	} else {
+		utils.Logger.Info("Gateway request of user %s (key %s, model %s): %d prompt and %d completion tokens in %v", userID, apiKeyID, req.Model, prompt, completion, time.Since(started))
+	}
+	return content, usage, err
+}
+
+// RecordAsync appends an exchange to the user's newest conversation with the given title, creating it
+// if needed. It waits for answers in progress in that conversation so the branch stays in order.
+func (s *GatewayService) RecordAsync(userID, title, question, answer string, usage *models.Usage) {
+	go func() {
+		if err := s.record(userID, title, question, answer, usage); err != nil {
+			utils.Logger.Error("Failed to record gateway exchange of user %s in conversation %q: %v", userID, title, err)
+		}
+	}()
+}
+
+func (s *GatewayService) record(userID, title, question, answer string, usage *models.Usage) error {
+	convo, err := s.ConversationService.FindOrCreateConversation(userID, title)
+	if err != nil {
+		return err
+	}
+
+	ticket, err := s.LockService.Enqueue(convo.ID)
+	if err != nil {
+		return err
+	}
+	lock, position, err := ticket.TryAcquire()
+	if err != nil {
+		ticket.Leave()
+		return err
+	}
+	if lock == nil {
+		lock, err = ticket.Wait(position, func(int) {}, func() bool { return false })
+		if err != nil {
+			return err
+		}
+	}
+	defer lock.Release()
+
+	// The active branch may have moved while waiting
+	convo, err = s.ConversationService.GetConversation(userID, convo.ID)
+	if err != nil {
+		return err
+	}
+	tree, err := s.RedisMessageService.LoadMessageTree(convo.ID, convo.ActiveMessageID)
+	if err != nil {
+		return err
+	}
+	parentID := tree.ActiveLeaf(convo.ActiveMessageID)
+
+	msg := models.Message{
+		MessageID:       uuid.New().String(),
+		UserID:          userID,
+		ConversationID:  convo.ID,
+		ParentMessageID: &parentID,
+		VersionIndex:    s.RedisMessageService.VersionIndex(tree, convo.ID, parentID),
+		Question:        question,
+		Answer:          answer,
+		Status:          models.MessageStatusCompleted,
+		Usage:           usage,
+		Metadata:        map[string]string{"source": "openai_api"},
+	}
+	if err := s.RedisMessageService.StoreOneMsgInRedis(msg); err != nil {
+		return err
+	}
+	if err := s.ConversationService.SetActiveMessage(convo.ID, msg.MessageID); err != nil {
+		utils.Logger.Error("Failed to set active message of conversation %s: %v", convo.ID, err)
+	}
+
+	// No connection keeps the conversation in Redis, so it goes back to MongoDB with the exchange
+	return s.RedisMessageService.MoveConversationToMongo(convo.ID)
+}
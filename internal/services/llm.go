@@ -0,0 +1,286 @@
+package services
+
+import (
+	"chat-ai-backend/config"
+	"chat-ai-backend/internal/models"
+	"chat-ai-backend/utils"
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// LLMService builds prompts and streams answers from the configured LLMProvider
+type LLMService struct {
+	Provider LLMProvider
+	Model    string
+	Tools    *ToolRegistry // nil disables tool calling
+}
+
+// Constructor
+func NewLLMService(provider LLMProvider, model string, tools *ToolRegistry) *LLMService {
+	return &LLMService{
+		Provider: provider,
+		Model:    model,
+		Tools:    tools,
+	}
+}
+
+// GenerateRequest is one question to answer
+type GenerateRequest struct {
+	UserID         string
+	ConversationID string
+	Message        string
+	History        []models.Message
+	Settings       *models.ConversationSettings // Non-empty fields override the server defaults
+	Summary        string                       // Summary of the turns before History, if any
+	Documents      []DocumentExcerpt            // Chunks of the user's documents relevant to the question
+	Attachments    []AttachmentContent          // Files sent with the question
+}
+
+// GenerateAIResponse sends a message together with the conversation history to the LLM and streams the response.
+// Tool calls requested by the model are run and their results sent back until the model answers.
+// Cancelling ctx aborts the upstream request. Failures are sent as a final StreamEventError, never as content.
+func (s *LLMService) GenerateAIResponse(ctx context.Context, genReq GenerateRequest, responseChan chan<- StreamEvent) {
+	defer close(responseChan)
+
+	req := s.newChatRequest(genReq.Settings)
+
+	systemPrompt := config.AppConfig.SystemPrompt
+	if genReq.Settings != nil && genReq.Settings.SystemPrompt != "" {
+		systemPrompt = genReq.Settings.SystemPrompt
+	}
+	if genReq.Summary != "" {
+		systemPrompt += "\n\nSummary of the earlier conversation:\n" + genReq.Summary
+	}
+	if len(genReq.Documents) > 0 {
+		systemPrompt += "\n\n" + documentsPrompt(genReq.Documents)
+	}
+
+	// Attachments are sent with the question, so they take their share of the budget before history does
+	question := genReq.Message
+	var images []ChatImage
+	if len(genReq.Attachments) > 0 {
+		var text string
+		text, images = attachmentPrompt(genReq.Attachments, config.AppConfig.SupportsVision(req.Model))
+		question += text
+	}
+	budget := config.AppConfig.ContextBudgetFor(req.Model, req.MaxTokens) - len(images)*imageTokenEstimate
+
+	// Build the prompt from history, trimmed to the model's token budget
+	window := BuildContextWindow(systemPrompt, genReq.History, question, budget)
+	if len(window.DroppedMessageIDs) > 0 {
+		utils.Logger.Warn("Dropped %d older turns of conversation %s to fit context budget: %v",
+			len(window.DroppedMessageIDs), genReq.ConversationID, window.DroppedMessageIDs)
+		responseChan <- StreamEvent{Type: StreamEventContext, Dropped: window.DroppedMessageIDs}
+	}
+	req.Messages = window.Messages
+
+	// The question is always the last message
+	req.Messages[len(req.Messages)-1].Images = images
+
+	if s.Tools != nil {
+		req.Tools = s.Tools.Definitions()
+	}
+	toolCtx := ToolContext{UserID: genReq.UserID, ConversationID: genReq.ConversationID}
+
+	var usage *models.Usage
+	var err error
+	for round := 0; ; round++ {
+		// On the last allowed round the model has to answer without tools
+		lastRound := round >= config.AppConfig.LLMMaxToolRounds
+		if lastRound {
+			req.Tools = nil
+		}
+
+		var calls []ToolCall
+		var content string
+		var roundUsage *models.Usage
+		calls, content, roundUsage, err = s.streamRound(ctx, req, responseChan)
+		usage = addUsage(usage, roundUsage)
+		if err != nil || len(calls) == 0 {
+			break
+		}
+		// A model that keeps calling tools it was no longer offered would loop forever
+		if lastRound {
+			err = &LLMError{Code: LLMErrorStream, Message: "LLM service kept calling tools after the last tool round",
+				Err: fmt.Errorf("%d tool rounds exceeded", config.AppConfig.LLMMaxToolRounds)}
+			break
+		}
+
+		// Replay the tool calls and their results so the model can continue
+		req.Messages = append(req.Messages, ChatMessage{Role: "assistant", Content: content, ToolCalls: calls})
+		for _, call := range calls {
+			invocation := s.runTool(ctx, toolCtx, call)
+			responseChan <- StreamEvent{Type: StreamEventToolInvocation, Invocation: &invocation}
+			req.Messages = append(req.Messages, ChatMessage{Role: "tool", ToolCallID: call.ID, Content: invocation.Result})
+		}
+	}
+
+	if usage != nil {
+		responseChan <- StreamEvent{Type: StreamEventUsage, Usage: usage}
+	}
+
+	if err != nil {
+		if ctx.Err() != nil {
+			utils.Logger.Info("Generation for conversation %s cancelled: %v", genReq.ConversationID, ctx.Err())
+			return
+		}
+		utils.Logger.Error("%s request failed for conversation %s: %v\n", s.Provider.Name(), genReq.ConversationID, err)
+		responseChan <- StreamEvent{Type: StreamEventError, Err: AsLLMError(err)}
+	}
+}
+
+// Complete sends a one-off request without history or tools and returns the whole answer
+func (s *LLMService) Complete(ctx context.Context, model string, messages []ChatMessage, maxTokens int) (string, *models.Usage, error) {
+	req := ChatRequest{Model: s.Model, Messages: messages, MaxTokens: maxTokens}
+	if model != "" {
+		req.Model = model
+	}
+
+	deltas := make(chan StreamEvent)
+	go func() {
+		for range deltas {
+			// Deltas are collected by streamRound already
+		}
+	}()
+	_, content, usage, err := s.streamRound(ctx, req, deltas)
+	close(deltas)
+	return content, usage, err
+}
+
+// Forward sends a request built by the client as is, without system prompt, history or tools, writing
+// deltas to responseChan. Usage is estimated when the provider does not report it.
+func (s *LLMService) Forward(ctx context.Context, req ChatRequest, responseChan chan<- StreamEvent) (string, *models.Usage, error) {
+	if req.Model == "" {
+		req.Model = s.Model
+	}
+	_, content, usage, err := s.streamRound(ctx, req, responseChan)
+	return content, usage, err
+}
+
+// streamRound runs one provider request, forwarding deltas and collecting tool calls and usage
+func (s *LLMService) streamRound(ctx context.Context, req ChatRequest, responseChan chan<- StreamEvent) ([]ToolCall, string, *models.Usage, error) {
+	// Run the provider on its own channel so usage can be filled in when the provider does not report it
+	events := make(chan StreamEvent)
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- s.Provider.StreamChat(ctx, req, events)
+		close(events)
+	}()
+
+	var usage *models.Usage
+	var calls []ToolCall
+	var completion strings.Builder
+	for event := range events {
+		switch event.Type {
+		case StreamEventUsage:
+			usage = event.Usage
+		case StreamEventToolCalls:
+			calls = append(calls, event.ToolCalls...)
+		case StreamEventDelta:
+			completion.WriteString(event.Content)
+			responseChan <- event
+		default:
+			responseChan <- event
+		}
+	}
+	err := <-errChan
+
+	// Tokens are billed for partial and cancelled answers too, and for streams that broke before any content
+	if usage == nil && (completion.Len() > 0 || len(calls) > 0 || failedMidStream(ctx, err)) {
+		completionTokens := EstimateTokens(completion.String())
+		for _, call := range calls {
+			completionTokens += EstimateTokens(call.Function.Name) + EstimateTokens(call.Function.Arguments)
+		}
+		usage = &models.Usage{
+			Model:            req.Model,
+			PromptTokens:     estimatePromptTokens(req.Messages),
+			CompletionTokens: completionTokens,
+			Estimated:        true,
+		}
+		usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+	}
+
+	return calls, completion.String(), usage, err
+}
+
+// failedMidStream reports whether the provider accepted the request and the stream broke afterwards,
+// in which case the prompt was processed even if no content arrived
+func failedMidStream(ctx context.Context, err error) bool {
+	return err != nil && ctx.Err() == nil && AsLLMError(err).Code == LLMErrorStream
+}
+
+// runTool executes one tool call; failures are reported back to the model as the result
+func (s *LLMService) runTool(ctx context.Context, toolCtx ToolContext, call ToolCall) models.ToolInvocation {
+	invocation := models.ToolInvocation{
+		CallID:    call.ID,
+		Name:      call.Function.Name,
+		Arguments: call.Function.Arguments,
+		CreatedAt: time.Now(),
+	}
+
+	result, err := s.Tools.Call(ctx, toolCtx, call.Function.Name, call.Function.Arguments)
+	if err != nil {
+		utils.Logger.Warn("Tool %s failed for conversation %s: %v", call.Function.Name, toolCtx.ConversationID, err)
+		invocation.Error = err.Error()
+		result = "Error: " + err.Error()
+	}
+	invocation.Result = result
+	return invocation
+}
+
+// newChatRequest applies the conversation settings on top of the service defaults
+func (s *LLMService) newChatRequest(settings *models.ConversationSettings) ChatRequest {
+	req := ChatRequest{Model: s.Model}
+	if settings == nil {
+		return req
+	}
+
+	if settings.Model != "" {
+		req.Model = settings.Model
+	}
+	req.Temperature = settings.Temperature
+	req.TopP = settings.TopP
+	req.MaxTokens = settings.MaxTokens
+	req.Stop = settings.Stop
+	return req
+}
+
+// documentsPrompt lists the retrieved excerpts for the system prompt
+func documentsPrompt(excerpts []DocumentExcerpt) string {
+	var b strings.Builder
+	b.WriteString("Excerpts from the user's documents that may help with the question. " +
+		"Use them when relevant and name the file you took information from:")
+	for i, excerpt := range excerpts {
+		fmt.Fprintf(&b, "\n\n[%d] %s\n%s", i+1, excerpt.Filename, excerpt.Text)
+	}
+	return b.String()
+}
+
+func estimatePromptTokens(messages []ChatMessage) int {
+	tokens := 0
+	for _, msg := range messages {
+		tokens += estimateMessageTokens(msg.Content) + len(msg.Images)*imageTokenEstimate
+		for _, call := range msg.ToolCalls {
+			tokens += EstimateTokens(call.Function.Name) + EstimateTokens(call.Function.Arguments)
+		}
+	}
+	return tokens
+}
+
+// addUsage sums the usage of several requests made for one answer
+func addUsage(total, usage *models.Usage) *models.Usage {
+	if usage == nil {
+		return total
+	}
+	if total == nil {
+		copied := *usage
+		return &copied
+	}
+	total.PromptTokens += usage.PromptTokens
+	total.CompletionTokens += usage.CompletionTokens
+	total.TotalTokens += usage.TotalTokens
+	total.Estimated = total.Estimated || usage.Estimated
+	return total
+}
@@ -0,0 +1,307 @@
+package services
+
+import (
+	"chat-ai-backend/utils"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// LLM error codes sent to clients in error frames
+const (
+	LLMErrorUnavailable = "upstream_unavailable" // could not connect or 5xx after all retries
+	LLMErrorRateLimited = "rate_limited"         // 429 after all retries
+	LLMErrorCircuitOpen = "circuit_open"         // breaker is open, request not attempted
+	LLMErrorRejected    = "upstream_rejected"    // non-retryable 4xx
+	LLMErrorStream      = "stream_error"         // the stream failed after it started
+)
+
+// LLMError is a typed upstream failure. It is sent to clients as an error frame and never stored as an answer.
+type LLMError struct {
+	Code       string
+	Message    string
+	StatusCode int
+	Retryable  bool
+	RetryAfter time.Duration
+	Err        error
+}
+
+func (e *LLMError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s: %s: %v", e.Code, e.Message, e.Err)
+	}
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+func (e *LLMError) Unwrap() error {
+	return e.Err
+}
+
+// AsLLMError converts any error into an *LLMError, defaulting to a stream error
+func AsLLMError(err error) *LLMError {
+	var llmErr *LLMError
+	if errors.As(err, &llmErr) {
+		return llmErr
+	}
+	return &LLMError{Code: LLMErrorStream, Message: "LLM service returned an error", Err: err}
+}
+
+// Circuit breaker states
+const (
+	breakerClosed = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+// CircuitBreaker stops calling the upstream after repeated failures and probes it again after a cool-down
+type CircuitBreaker struct {
+	mu               sync.Mutex
+	state            int
+	failures         int
+	openedAt         time.Time
+	failureThreshold int
+	openDuration     time.Duration
+}
+
+// Constructor
+func NewCircuitBreaker(failureThreshold int, openDuration time.Duration) *CircuitBreaker {
+	if failureThreshold <= 0 {
+		failureThreshold = 5
+	}
+	return &CircuitBreaker{
+		failureThreshold: failureThreshold,
+		openDuration:     openDuration,
+	}
+}
+
+// Allow reports whether a request may be sent; after the cool-down a single probe is let through
+func (b *CircuitBreaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case breakerOpen, breakerHalfOpen:
+		// Half-open waits for the in-flight probe, but lets another one through if it never reported back
+		if time.Since(b.openedAt) < b.openDuration {
+			return false
+		}
+		b.state = breakerHalfOpen
+		b.openedAt = time.Now()
+		return true
+	default:
+		return true
+	}
+}
+
+// Success closes the breaker
+func (b *CircuitBreaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.state = breakerClosed
+	b.failures = 0
+}
+
+// Failure records a failed attempt and opens the breaker once the threshold is reached
+func (b *CircuitBreaker) Failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+	if b.state == breakerHalfOpen || b.failures >= b.failureThreshold {
+		if b.state != breakerOpen {
+			utils.Logger.Warn("LLM circuit breaker opened after %d failures", b.failures)
+		}
+		b.state = breakerOpen
+		b.openedAt = time.Now()
+	}
+}
+
+// RetryAfter returns how long until the breaker lets a probe through
+func (b *CircuitBreaker) RetryAfter() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state != breakerOpen {
+		return 0
+	}
+	if wait := b.openDuration - time.Since(b.openedAt); wait > 0 {
+		return wait
+	}
+	return 0
+}
+
+// ResilientClient sends upstream requests with retries, jittered backoff and a circuit breaker
+type ResilientClient struct {
+	Client     *http.Client
+	Breaker    *CircuitBreaker
+	MaxRetries int
+	BaseDelay  time.Duration
+	MaxDelay   time.Duration
+}
+
+// Constructor
+func NewResilientClient(breaker *CircuitBreaker, maxRetries int, baseDelay, maxDelay time.Duration) *ResilientClient {
+	return &ResilientClient{
+		Client:     &http.Client{Timeout: 0}, // no timeout for streaming
+		Breaker:    breaker,
+		MaxRetries: maxRetries,
+		BaseDelay:  baseDelay,
+		MaxDelay:   maxDelay,
+	}
+}
+
+// Do sends the request and returns the response only for a 200 status.
+// Connect failures, 429 and 5xx are retried; every other failure is returned as an *LLMError.
+// Only connect failures and 5xx count towards opening the breaker.
+func (c *ResilientClient) Do(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+
+	var lastErr *LLMError
+	for attempt := 0; attempt <= c.MaxRetries; attempt++ {
+		if attempt > 0 {
+			wait := c.backoff(attempt)
+			if lastErr != nil && lastErr.RetryAfter > wait {
+				// Waiting longer than MaxDelay would stall the client, report the error instead
+				if lastErr.RetryAfter > c.MaxDelay {
+					return nil, lastErr
+				}
+				wait = lastErr.RetryAfter
+			}
+			utils.Logger.Warn("Retrying LLM request in %v (attempt %d/%d): %v", wait, attempt, c.MaxRetries, lastErr)
+			if err := sleepContext(ctx, wait); err != nil {
+				return nil, err
+			}
+		}
+
+		if c.Breaker != nil && !c.Breaker.Allow() {
+			return nil, &LLMError{
+				Code:       LLMErrorCircuitOpen,
+				Message:    "LLM service is temporarily unavailable",
+				Retryable:  true,
+				RetryAfter: c.Breaker.RetryAfter(),
+			}
+		}
+
+		attemptReq := req
+		if attempt > 0 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, fmt.Errorf("failed to rewind request body: %w", err)
+			}
+			attemptReq = req.Clone(ctx)
+			attemptReq.Body = body
+		}
+
+		resp, err := c.Client.Do(attemptReq)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			c.recordFailure()
+			lastErr = &LLMError{Code: LLMErrorUnavailable, Message: "Error connecting to LLM service", Retryable: true, Err: err}
+			continue
+		}
+
+		if resp.StatusCode == http.StatusOK {
+			c.recordSuccess()
+			return resp, nil
+		}
+
+		llmErr := errorFromResponse(resp)
+		resp.Body.Close()
+		if !llmErr.Retryable {
+			// The upstream is healthy, it just refused this request
+			c.recordSuccess()
+			return nil, llmErr
+		}
+		// A rate limit is retried after Retry-After but is no outage: the breaker is shared by every
+		// user, so one user hitting the limit must not cut off the others
+		if llmErr.Code != LLMErrorRateLimited {
+			c.recordFailure()
+		}
+		lastErr = llmErr
+	}
+
+	return nil, lastErr
+}
+
+func (c *ResilientClient) recordSuccess() {
+	if c.Breaker != nil {
+		c.Breaker.Success()
+	}
+}
+
+func (c *ResilientClient) recordFailure() {
+	if c.Breaker != nil {
+		c.Breaker.Failure()
+	}
+}
+
+// backoff returns the exponential delay for an attempt with full jitter
+func (c *ResilientClient) backoff(attempt int) time.Duration {
+	delay := c.BaseDelay << (attempt - 1)
+	if delay <= 0 || delay > c.MaxDelay {
+		delay = c.MaxDelay
+	}
+	if delay <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(delay)) + 1)
+}
+
+// errorFromResponse builds a typed error from a non-OK upstream response
+func errorFromResponse(resp *http.Response) *LLMError {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	llmErr := &LLMError{
+		StatusCode: resp.StatusCode,
+		Message:    fmt.Sprintf("LLM service returned %s", resp.Status),
+		RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+	}
+	if len(body) > 0 {
+		llmErr.Err = errors.New(string(body))
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests:
+		llmErr.Code = LLMErrorRateLimited
+		llmErr.Retryable = true
+	case resp.StatusCode >= 500:
+		llmErr.Code = LLMErrorUnavailable
+		llmErr.Retryable = true
+	default:
+		llmErr.Code = LLMErrorRejected
+	}
+	return llmErr
+}
+
+// parseRetryAfter accepts both the delay-seconds and the HTTP-date form of Retry-After
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		if wait := time.Until(at); wait > 0 {
+			return wait
+		}
+	}
+	return 0
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
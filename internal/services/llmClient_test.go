@@ -0,0 +1,55 @@
+package services
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestResilientClientRateLimitDoesNotOpenBreaker(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Retry-After", "1")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	breaker := NewCircuitBreaker(1, time.Minute)
+	client := NewResilientClient(breaker, 1, time.Millisecond, 2*time.Second)
+	req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+
+	start := time.Now()
+	_, err := client.Do(req)
+	if code := AsLLMError(err).Code; code != LLMErrorRateLimited {
+		t.Fatalf("error code = %q, want %q", code, LLMErrorRateLimited)
+	}
+	if calls != 2 {
+		t.Fatalf("upstream called %d times, want 2", calls)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Fatalf("retried after %v, want at least the Retry-After of 1s", elapsed)
+	}
+	if !breaker.Allow() {
+		t.Fatal("breaker opened on a rate limit")
+	}
+}
+
+func TestResilientClientServerErrorsOpenBreaker(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	breaker := NewCircuitBreaker(1, time.Minute)
+	client := NewResilientClient(breaker, 0, time.Millisecond, time.Millisecond)
+	req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+
+	if _, err := client.Do(req); AsLLMError(err).Code != LLMErrorUnavailable {
+		t.Fatalf("error = %v, want %q", err, LLMErrorUnavailable)
+	}
+	if breaker.Allow() {
+		t.Fatal("breaker still closed after a 5xx")
+	}
+}
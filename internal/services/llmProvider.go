@@ -0,0 +1,132 @@
+package services
+
+import (
+	"bufio"
+	"chat-ai-backend/config"
+	"chat-ai-backend/internal/models"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ErrEmbeddingsNotSupported is returned by providers that have no embeddings endpoint
+var ErrEmbeddingsNotSupported = errors.New("embeddings are not supported by this LLM provider")
+
+// ErrModerationNotSupported is returned by providers that have no moderation endpoint
+var ErrModerationNotSupported = errors.New("moderation is not supported by this LLM provider")
+
+// ChatRequest is the provider independent description of one chat completion
+type ChatRequest struct {
+	Model       string
+	Messages    []ChatMessage
+	MaxTokens   int
+	Temperature *float64
+	TopP        *float64
+	Stop        []string
+	Tools       []ToolDefinition
+}
+
+// maxToolCalls caps the tool calls one model turn may request
+const maxToolCalls = 32
+
+// Stream event types
+const (
+	StreamEventDelta          = "delta"
+	StreamEventUsage          = "usage"
+	StreamEventToolCalls      = "tool_calls"      // sent by providers: the model wants tools to run
+	StreamEventToolInvocation = "tool_invocation" // sent by LLMService: a tool ran
+	StreamEventError          = "error"
+	StreamEventContext        = "context" // sent by LLMService: history was cut to fit the context budget
+)
+
+// StreamEvent is one item of a streamed answer: a content delta, the token usage, tool activity or a typed error
+type StreamEvent struct {
+	Type       string
+	Content    string
+	Usage      *models.Usage
+	ToolCalls  []ToolCall
+	Invocation *models.ToolInvocation
+	Err        *LLMError
+	Dropped    []string // IDs of the history messages left out of the prompt
+}
+
+// LLMProvider is implemented by every LLM backend the server can talk to
+type LLMProvider interface {
+	// Name returns the provider identifier used in the configuration
+	Name() string
+	// StreamChat sends the request and writes every content fragment to out as it arrives.
+	// It does not close out and reports failures through its return value.
+	StreamChat(ctx context.Context, req ChatRequest, out chan<- StreamEvent) error
+	// ListModels returns the model names available on the backend
+	ListModels(ctx context.Context) ([]string, error)
+	// Embeddings returns one vector per input text
+	Embeddings(ctx context.Context, model string, inputs []string) ([][]float32, error)
+	// Moderate returns one verdict per input text
+	Moderate(ctx context.Context, model string, inputs []string) ([]ModerationResult, error)
+}
+
+// NewLLMProvider creates the provider selected by cfg.LLMProvider.
+// All requests go through one ResilientClient, so the circuit breaker is shared by every connection.
+func NewLLMProvider(cfg *config.Config) (LLMProvider, error) {
+	breaker := NewCircuitBreaker(cfg.LLMBreakerFailures, cfg.LLMBreakerOpenDuration)
+	client := NewResilientClient(breaker, cfg.LLMMaxRetries, cfg.LLMRetryBaseDelay, cfg.LLMRetryMaxDelay)
+
+	switch strings.ToLower(cfg.LLMProvider) {
+	case "", "openai":
+		return NewOpenAIService(cfg.OpenAIUrl, cfg.OpenAIKey, client), nil
+	case "ollama":
+		return NewOllamaService(cfg.OllamaUrl, client), nil
+	case "anthropic":
+		return NewAnthropicService(cfg.AnthropicUrl, cfg.AnthropicKey, client), nil
+	default:
+		return nil, fmt.Errorf("unknown LLM provider %q", cfg.LLMProvider)
+	}
+}
+
+// readSSE reads a Server-Sent Events stream and calls handle for every event.
+// Returning io.EOF from handle stops reading without an error.
+func readSSE(body io.Reader, handle func(event, data string) error) error {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	event := ""
+	var data []string
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		// An empty line terminates the current event
+		if line == "" {
+			if len(data) > 0 {
+				if err := handle(event, strings.Join(data, "\n")); err != nil {
+					if err == io.EOF {
+						return nil
+					}
+					return err
+				}
+			}
+			event, data = "", nil
+			continue
+		}
+
+		switch {
+		case strings.HasPrefix(line, "event:"):
+			event = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case strings.HasPrefix(line, "data:"):
+			data = append(data, strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	// Flush the last event if the stream did not end with an empty line
+	if len(data) > 0 {
+		if err := handle(event, strings.Join(data, "\n")); err != nil && err != io.EOF {
+			return err
+		}
+	}
+	return nil
+}
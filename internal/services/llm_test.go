@@ -0,0 +1,195 @@
+package services
+
+import (
+	"chat-ai-backend/config"
+	"chat-ai-backend/internal/models"
+	"chat-ai-backend/pkg/fakellm"
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newFakeLLMService runs the fake OpenAI server from the test fixture and returns a service talking to it
+func newFakeLLMService(t *testing.T, tools *ToolRegistry) *LLMService {
+	t.Helper()
+
+	config.AppConfig = &config.Config{
+		SystemPrompt:       "You are a helpful assistant.",
+		ContextTokenBudget: 4096,
+		CompletionReserve:  512,
+		LLMMaxToolRounds:   3,
+	}
+
+	fixture, err := fakellm.LoadFixture("testdata/fakellm.json")
+	if err != nil {
+		t.Fatalf("load fixture: %v", err)
+	}
+	server := httptest.NewServer(fakellm.NewServer(fixture))
+	t.Cleanup(server.Close)
+
+	client := NewResilientClient(NewCircuitBreaker(5, time.Second), 0, time.Millisecond, time.Millisecond)
+	provider := NewOpenAIService(server.URL+"/v1/chat/completions", "", client)
+	return NewLLMService(provider, "fake-gpt", tools)
+}
+
+// generate runs one question and collects every event the service sends
+func generate(t *testing.T, svc *LLMService, question string) []StreamEvent {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	events := make(chan StreamEvent)
+	go svc.GenerateAIResponse(ctx, GenerateRequest{UserID: "user-1", ConversationID: "conv-1", Message: question}, events)
+
+	var collected []StreamEvent
+	for event := range events {
+		collected = append(collected, event)
+	}
+	return collected
+}
+
+func eventsOfType(events []StreamEvent, eventType string) []StreamEvent {
+	var matching []StreamEvent
+	for _, event := range events {
+		if event.Type == eventType {
+			matching = append(matching, event)
+		}
+	}
+	return matching
+}
+
+func TestGenerateAIResponseStreamsDeltasAndUsage(t *testing.T) {
+	svc := newFakeLLMService(t, nil)
+	events := generate(t, svc, "hello")
+
+	var deltas []string
+	for _, event := range eventsOfType(events, StreamEventDelta) {
+		deltas = append(deltas, event.Content)
+	}
+	if want := []string{"Hello ", "there, ", "how can I help?"}; strings.Join(deltas, "|") != strings.Join(want, "|") {
+		t.Fatalf("deltas = %q, want %q", deltas, want)
+	}
+
+	usage := eventsOfType(events, StreamEventUsage)
+	if len(usage) != 1 {
+		t.Fatalf("got %d usage events, want 1", len(usage))
+	}
+	want := models.Usage{Model: "fake-gpt", PromptTokens: 12, CompletionTokens: 6, TotalTokens: 18}
+	if *usage[0].Usage != want {
+		t.Fatalf("usage = %+v, want %+v", *usage[0].Usage, want)
+	}
+	if errs := eventsOfType(events, StreamEventError); len(errs) != 0 {
+		t.Fatalf("unexpected error event: %v", errs[0].Err)
+	}
+}
+
+func TestGenerateAIResponseRunsToolCalls(t *testing.T) {
+	tools := NewToolRegistry()
+	var gotArgs struct {
+		City string `json:"city"`
+	}
+	err := tools.Register(Tool{
+		Name:        "get_weather",
+		Description: "Current weather of a city",
+		Func: func(ctx context.Context, tc ToolContext, args json.RawMessage) (string, error) {
+			if err := json.Unmarshal(args, &gotArgs); err != nil {
+				return "", err
+			}
+			return "sunny, 28°C", nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("register tool: %v", err)
+	}
+
+	svc := newFakeLLMService(t, tools)
+	events := generate(t, svc, "What is the weather in Taipei?")
+
+	invocations := eventsOfType(events, StreamEventToolInvocation)
+	if len(invocations) != 1 {
+		t.Fatalf("got %d tool invocations, want 1", len(invocations))
+	}
+	invocation := invocations[0].Invocation
+	if invocation.CallID != "call_weather" || invocation.Name != "get_weather" || invocation.Result != "sunny, 28°C" {
+		t.Fatalf("invocation = %+v", invocation)
+	}
+	// The arguments arrive in two fragments and have to be put back together
+	if gotArgs.City != "Taipei" || invocation.Error != "" {
+		t.Fatalf("tool got city %q, error %q", gotArgs.City, invocation.Error)
+	}
+
+	var answer strings.Builder
+	for _, event := range eventsOfType(events, StreamEventDelta) {
+		answer.WriteString(event.Content)
+	}
+	if answer.String() != "It is sunny in Taipei." {
+		t.Fatalf("answer = %q", answer.String())
+	}
+
+	// Both rounds are billed
+	usage := eventsOfType(events, StreamEventUsage)
+	if len(usage) != 1 {
+		t.Fatalf("got %d usage events, want 1", len(usage))
+	}
+	if got := usage[0].Usage; got.PromptTokens != 50 || got.CompletionTokens != 9 || got.TotalTokens != 59 {
+		t.Fatalf("usage = %+v, want 50 prompt and 9 completion tokens", *got)
+	}
+}
+
+func TestGenerateAIResponseReportsMidStreamDisconnect(t *testing.T) {
+	svc := newFakeLLMService(t, nil)
+	events := generate(t, svc, "please disconnect")
+
+	var answer strings.Builder
+	for _, event := range eventsOfType(events, StreamEventDelta) {
+		answer.WriteString(event.Content)
+	}
+	if answer.String() != "This answer is cut " {
+		t.Fatalf("answer = %q, want the chunks sent before the disconnect", answer.String())
+	}
+
+	// The partial answer is billed with an estimate since the usage chunk never arrived
+	usage := eventsOfType(events, StreamEventUsage)
+	if len(usage) != 1 || !usage[0].Usage.Estimated || usage[0].Usage.CompletionTokens == 0 {
+		t.Fatalf("usage events = %+v, want one estimated usage", usage)
+	}
+
+	if last := events[len(events)-1]; last.Type != StreamEventError {
+		t.Fatalf("last event = %s, want %s", last.Type, StreamEventError)
+	} else if last.Err.Code != LLMErrorStream {
+		t.Fatalf("error code = %s, want %s", last.Err.Code, LLMErrorStream)
+	}
+}
+
+func TestGenerateAIResponseReportsDroppedTurns(t *testing.T) {
+	svc := newFakeLLMService(t, nil)
+
+	history := []models.Message{
+		{MessageID: "old", Question: "Tell me everything", Answer: strings.Repeat("word ", 20000)},
+		{MessageID: "recent", Question: "And briefly?", Answer: "Briefly, yes."},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	events := make(chan StreamEvent)
+	go svc.GenerateAIResponse(ctx, GenerateRequest{UserID: "user-1", ConversationID: "conv-1", Message: "hello", History: history}, events)
+
+	var collected []StreamEvent
+	for event := range events {
+		collected = append(collected, event)
+	}
+
+	dropped := eventsOfType(collected, StreamEventContext)
+	if len(dropped) != 1 {
+		t.Fatalf("got %d context events, want 1", len(dropped))
+	}
+	if want := []string{"old"}; strings.Join(dropped[0].Dropped, ",") != strings.Join(want, ",") {
+		t.Fatalf("dropped = %q, want %q", dropped[0].Dropped, want)
+	}
+	if len(eventsOfType(collected, StreamEventDelta)) == 0 {
+		t.Fatal("no answer was streamed")
+	}
+}
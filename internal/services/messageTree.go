@@ -0,0 +1,138 @@
+package services
+
+import (
+	"chat-ai-backend/internal/models"
+	"sort"
+)
+
+// MessageNode is a message with its alternatives and replies
+type MessageNode struct {
+	Message    models.Message `json:"message"`
+	ParentID   string         `json:"parent_id"`
+	SiblingIDs []string       `json:"sibling_ids"` // All versions sharing the parent, this one included, oldest first
+	Children   []*MessageNode `json:"children"`
+}
+
+// MessageTree indexes the messages of a conversation by their parent links
+type MessageTree struct {
+	Roots []*MessageNode
+	Nodes map[string]*MessageNode
+}
+
+// BuildMessageTree links messages into a tree. Messages stored before branching existed have no
+// parent link and are chained in creation order.
+func BuildMessageTree(messages []models.Message) *MessageTree {
+	sorted := make([]models.Message, len(messages))
+	copy(sorted, messages)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].CreatedAt.Before(sorted[j].CreatedAt) })
+
+	tree := &MessageTree{Nodes: make(map[string]*MessageNode, len(sorted))}
+	previousLegacy := ""
+	for _, msg := range sorted {
+		node := &MessageNode{Message: msg, Children: []*MessageNode{}}
+		if msg.ParentMessageID != nil {
+			node.ParentID = *msg.ParentMessageID
+		} else {
+			node.ParentID = previousLegacy
+			previousLegacy = msg.MessageID
+		}
+		tree.Nodes[msg.MessageID] = node
+	}
+
+	for _, msg := range sorted {
+		node := tree.Nodes[msg.MessageID]
+		if parent, ok := tree.Nodes[node.ParentID]; ok && node.ParentID != "" {
+			parent.Children = append(parent.Children, node)
+		} else {
+			tree.Roots = append(tree.Roots, node)
+		}
+	}
+
+	setSiblings(tree.Roots)
+	return tree
+}
+
+func setSiblings(nodes []*MessageNode) {
+	ids := make([]string, 0, len(nodes))
+	for _, node := range nodes {
+		ids = append(ids, node.Message.MessageID)
+	}
+	for _, node := range nodes {
+		node.SiblingIDs = ids
+		setSiblings(node.Children)
+	}
+}
+
+// LatestLeaf follows the newest reply from the given message down to the end of its branch
+func (t *MessageTree) LatestLeaf(messageID string) string {
+	node, ok := t.Nodes[messageID]
+	if !ok {
+		return ""
+	}
+	for len(node.Children) > 0 {
+		node = node.Children[len(node.Children)-1]
+	}
+	return node.Message.MessageID
+}
+
+// DefaultLeaf is the end of the newest branch, used when a conversation has no active message yet
+func (t *MessageTree) DefaultLeaf() string {
+	if len(t.Roots) == 0 {
+		return ""
+	}
+	return t.LatestLeaf(t.Roots[len(t.Roots)-1].Message.MessageID)
+}
+
+// PathTo returns the messages from the first question down to messageID
+func (t *MessageTree) PathTo(messageID string) []models.Message {
+	var path []models.Message
+	for node, ok := t.Nodes[messageID]; ok; node, ok = t.Nodes[node.ParentID] {
+		path = append(path, node.Message)
+	}
+	for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+		path[i], path[j] = path[j], path[i]
+	}
+	return path
+}
+
+// PathNodes is PathTo with the sibling information needed for "< 2/3 >" navigation
+func (t *MessageTree) PathNodes(messageID string) []*MessageNode {
+	path := t.PathTo(messageID)
+	nodes := make([]*MessageNode, 0, len(path))
+	for _, msg := range path {
+		node := *t.Nodes[msg.MessageID]
+		node.Children = nil
+		nodes = append(nodes, &node)
+	}
+	return nodes
+}
+
+// ActiveLeaf resolves the leaf to continue from, falling back to the newest branch
+func (t *MessageTree) ActiveLeaf(activeMessageID string) string {
+	if _, ok := t.Nodes[activeMessageID]; ok && activeMessageID != "" {
+		return activeMessageID
+	}
+	return t.DefaultLeaf()
+}
+
+// ChildCount returns how many messages have parentID as parent ("" counts the first messages)
+func (t *MessageTree) ChildCount(parentID string) int {
+	if parentID == "" {
+		return len(t.Roots)
+	}
+	if node, ok := t.Nodes[parentID]; ok {
+		return len(node.Children)
+	}
+	return 0
+}
+
+// Truncated reports whether the tree was built from only the newest messages, so some of its roots
+// reply to messages it does not hold
+func (t *MessageTree) Truncated() bool {
+	for _, root := range t.Roots {
+		if root.ParentID != "" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,32 @@
+package services
+
+import (
+	"chat-ai-backend/internal/models"
+	"testing"
+	"time"
+)
+
+func TestMessageTreeTruncated(t *testing.T) {
+	root, first, second := "", "q1", "q2"
+	start := time.Now()
+	messages := []models.Message{
+		{MessageID: "q1", ParentMessageID: &root, CreatedAt: start},
+		{MessageID: "q2", ParentMessageID: &first, CreatedAt: start.Add(time.Second)},
+		{MessageID: "q2b", ParentMessageID: &first, CreatedAt: start.Add(2 * time.Second)},
+		{MessageID: "q3", ParentMessageID: &second, CreatedAt: start.Add(3 * time.Second)},
+	}
+
+	full := BuildMessageTree(messages)
+	if full.Truncated() {
+		t.Fatal("tree of the whole conversation reported as truncated")
+	}
+	if got := full.ChildCount("q1"); got != 2 {
+		t.Fatalf("ChildCount(q1) = %d, want 2", got)
+	}
+
+	// The cached window lost the first question, the parent of both versions of q2
+	window := BuildMessageTree(messages[1:])
+	if !window.Truncated() {
+		t.Fatal("tree of the newest messages not reported as truncated")
+	}
+}
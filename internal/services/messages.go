@@ -22,16 +22,6 @@ func NewMessageService(repo *repositories.MessageRepository) *MessageService {
 	return &MessageService{Repo: repo}
 }
 
-// ValidateConversationOwnership checks if the conversationID belongs to the userID
-func (s *MessageService) ValidateConversationOwnership(userID, conversationID string) (bool, error) {
-	conversation, err := s.Repo.GetConversationByID(conversationID)
-	if err != nil {
-		utils.Logger.Error("Error retrieving conversation: %v\n", err)
-		return false, err
-	}
-	return conversation.UserID == userID, nil
-}
-
 // WriteClientMessage sends a message to the WebSocket client
 func (s *MessageService) WriteClientMessage(conn *websocket.Conn, messageType int, message string) error {
 	err := conn.WriteMessage(messageType, []byte(message))
@@ -63,3 +53,13 @@ func (s *MessageService) StoreMessage(userID, conversationID, question, answer s
 		utils.Logger.Info("Message saved successfully for conversation %s", conversationID)
 	}
 }
+
+// SearchMessages returns the user's most recent messages containing the query
+func (s *MessageService) SearchMessages(userID, query string, limit int) ([]models.Message, error) {
+	messages, err := s.Repo.SearchUserMessages(userID, query, limit)
+	if err != nil {
+		utils.Logger.Error("Failed to search messages for user %s: %v\n", userID, err)
+		return nil, err
+	}
+	return messages, nil
+}
@@ -0,0 +1,90 @@
+package services
+
+import (
+	"chat-ai-backend/utils"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// ErrContentFlagged is returned when moderation flags a request or an answer
+var ErrContentFlagged = errors.New("flagged by moderation")
+
+// ModerationResult is the verdict on one text
+type ModerationResult struct {
+	Flagged    bool
+	Categories []string // Categories the text was flagged for
+}
+
+// ModerationService screens texts with the provider's moderation endpoint and a local blocklist
+type ModerationService struct {
+	Provider  LLMProvider
+	Model     string
+	Blocklist []string // Phrases that flag a text without asking the provider, matched case-insensitively
+}
+
+// Constructor
+func NewModerationService(provider LLMProvider, model string, blocklist []string) *ModerationService {
+	lowered := make([]string, 0, len(blocklist))
+	for _, phrase := range blocklist {
+		lowered = append(lowered, strings.ToLower(phrase))
+	}
+	return &ModerationService{Provider: provider, Model: model, Blocklist: lowered}
+}
+
+// Check returns an error wrapping ErrContentFlagged when any of the texts is flagged. With providers
+// that have no moderation endpoint, or when it fails, only the blocklist is applied: an outage of the
+// endpoint must not refuse every request.
+func (s *ModerationService) Check(ctx context.Context, texts []string) error {
+	var inputs []string
+	for _, text := range texts {
+		if strings.TrimSpace(text) != "" {
+			inputs = append(inputs, text)
+		}
+	}
+	if len(inputs) == 0 {
+		return nil
+	}
+
+	for _, input := range inputs {
+		lowered := strings.ToLower(input)
+		for _, phrase := range s.Blocklist {
+			if strings.Contains(lowered, phrase) {
+				return fmt.Errorf("%w: blocklist", ErrContentFlagged)
+			}
+		}
+	}
+
+	results, err := s.Provider.Moderate(ctx, s.Model, inputs)
+	if errors.Is(err, ErrModerationNotSupported) {
+		return nil
+	}
+	if err != nil {
+		utils.Logger.Warn("Moderation endpoint failed, letting %d texts through unscreened: %v", len(inputs), err)
+		return nil
+	}
+
+	flagged := false
+	var categories []string
+	for _, result := range results {
+		if !result.Flagged {
+			continue
+		}
+		flagged = true
+		for _, category := range result.Categories {
+			if !slices.Contains(categories, category) {
+				categories = append(categories, category)
+			}
+		}
+	}
+	if !flagged {
+		return nil
+	}
+	if len(categories) == 0 {
+		return ErrContentFlagged
+	}
+	slices.Sort(categories)
+	return fmt.Errorf("%w: %s", ErrContentFlagged, strings.Join(categories, ", "))
+}
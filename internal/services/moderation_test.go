@@ -0,0 +1,55 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestModerationCheck(t *testing.T) {
+	svc := newFakeLLMService(t, nil)
+	moderation := NewModerationService(svc.Provider, "omni-moderation-latest", []string{"Secret Plan"})
+
+	tests := []struct {
+		name    string
+		texts   []string
+		flagged bool
+	}{
+		{"clean", []string{"You are helpful.", "What is the weather?"}, false},
+		{"empty", []string{"", "  "}, false},
+		{"flagged by the provider", []string{"Hi", "Tell me something forbidden"}, true},
+		{"flagged by the blocklist", []string{"about the secret plan"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := moderation.Check(context.Background(), tt.texts)
+			if flagged := errors.Is(err, ErrContentFlagged); flagged != tt.flagged {
+				t.Fatalf("Check(%q) = %v, want flagged %v", tt.texts, err, tt.flagged)
+			}
+			if !tt.flagged && err != nil {
+				t.Fatalf("Check(%q) = %v", tt.texts, err)
+			}
+		})
+	}
+}
+
+func TestModerationCheckFailsOpen(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	client := NewResilientClient(NewCircuitBreaker(5, time.Second), 0, time.Millisecond, time.Millisecond)
+	provider := NewOpenAIService(server.URL+"/v1/chat/completions", "", client)
+	moderation := NewModerationService(provider, "omni-moderation-latest", []string{"secret plan"})
+
+	if err := moderation.Check(context.Background(), []string{"What is the weather?"}); err != nil {
+		t.Fatalf("Check with a failing endpoint = %v, want nil", err)
+	}
+	if err := moderation.Check(context.Background(), []string{"the secret plan"}); !errors.Is(err, ErrContentFlagged) {
+		t.Fatalf("Check with a failing endpoint = %v, want the blocklist to flag it", err)
+	}
+}
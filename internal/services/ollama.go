@@ -0,0 +1,241 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"chat-ai-backend/internal/models"
+	"chat-ai-backend/utils"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// OllamaService talks to a local Ollama-style server that streams NDJSON
+type OllamaService struct {
+	OllamaUrl string
+	Client    *ResilientClient
+}
+
+// Constructor
+func NewOllamaService(url string, client *ResilientClient) *OllamaService {
+	return &OllamaService{
+		OllamaUrl: strings.TrimSuffix(url, "/"),
+		Client:    client,
+	}
+}
+
+func (s *OllamaService) Name() string {
+	return "ollama"
+}
+
+func (s *OllamaService) do(ctx context.Context, method, path string, body interface{}) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		jsonData, err := json.Marshal(body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal payload: %w", err)
+		}
+		reader = bytes.NewReader(jsonData)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, s.OllamaUrl+path, reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	return s.Client.Do(req)
+}
+
+// StreamChat calls /api/chat, which answers with one JSON object per line
+func (s *OllamaService) StreamChat(ctx context.Context, chatReq ChatRequest, out chan<- StreamEvent) error {
+	payload := map[string]interface{}{
+		"model":    chatReq.Model,
+		"messages": ollamaMessages(chatReq.Messages),
+		"stream":   true,
+	}
+	if len(chatReq.Tools) > 0 {
+		payload["tools"] = chatReq.Tools
+	}
+
+	// Sampling parameters go into the "options" object
+	options := map[string]interface{}{}
+	if chatReq.MaxTokens > 0 {
+		options["num_predict"] = chatReq.MaxTokens
+	}
+	if chatReq.Temperature != nil {
+		options["temperature"] = *chatReq.Temperature
+	}
+	if chatReq.TopP != nil {
+		options["top_p"] = *chatReq.TopP
+	}
+	if len(chatReq.Stop) > 0 {
+		options["stop"] = chatReq.Stop
+	}
+	if len(options) > 0 {
+		payload["options"] = options
+	}
+
+	resp, err := s.do(ctx, http.MethodPost, "/api/chat", payload)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var toolCalls []ToolCall
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		var chunk struct {
+			Message struct {
+				Content   string `json:"content"`
+				ToolCalls []struct {
+					Function struct {
+						Name      string          `json:"name"`
+						Arguments json.RawMessage `json:"arguments"`
+					} `json:"function"`
+				} `json:"tool_calls"`
+			} `json:"message"`
+			Done            bool   `json:"done"`
+			Error           string `json:"error"`
+			PromptEvalCount int    `json:"prompt_eval_count"`
+			EvalCount       int    `json:"eval_count"`
+		}
+		if err := json.Unmarshal([]byte(line), &chunk); err != nil {
+			utils.Logger.Error("Failed to unmarshal chunk: %v\n", err)
+			continue
+		}
+
+		if chunk.Error != "" {
+			return &LLMError{Code: LLMErrorStream, Message: chunk.Error}
+		}
+		if chunk.Message.Content != "" {
+			out <- StreamEvent{Type: StreamEventDelta, Content: chunk.Message.Content}
+		}
+		// Tool calls come complete, with arguments as an object and without IDs
+		for _, call := range chunk.Message.ToolCalls {
+			toolCalls = append(toolCalls, ToolCall{
+				ID:   fmt.Sprintf("call_%d", len(toolCalls)),
+				Type: "function",
+				Function: ToolCallFunction{
+					Name:      call.Function.Name,
+					Arguments: string(call.Function.Arguments),
+				},
+			})
+		}
+		if chunk.Done {
+			if len(toolCalls) > 0 {
+				out <- StreamEvent{Type: StreamEventToolCalls, ToolCalls: toolCalls}
+			}
+			// The final object carries the token counts
+			if chunk.PromptEvalCount > 0 || chunk.EvalCount > 0 {
+				out <- StreamEvent{Type: StreamEventUsage, Usage: &models.Usage{
+					Model:            chatReq.Model,
+					PromptTokens:     chunk.PromptEvalCount,
+					CompletionTokens: chunk.EvalCount,
+					TotalTokens:      chunk.PromptEvalCount + chunk.EvalCount,
+				}}
+			}
+			return nil
+		}
+	}
+
+	return scanner.Err()
+}
+
+// ollamaMessages converts chat messages to Ollama's format, which expects tool call arguments as objects
+func ollamaMessages(messages []ChatMessage) []map[string]interface{} {
+	converted := make([]map[string]interface{}, 0, len(messages))
+	for _, msg := range messages {
+		entry := map[string]interface{}{
+			"role":    msg.Role,
+			"content": msg.Content,
+		}
+		if len(msg.Images) > 0 {
+			// Ollama takes raw base64 images next to the text
+			images := make([]string, 0, len(msg.Images))
+			for _, image := range msg.Images {
+				images = append(images, base64.StdEncoding.EncodeToString(image.Data))
+			}
+			entry["images"] = images
+		}
+		if len(msg.ToolCalls) > 0 {
+			calls := make([]map[string]interface{}, 0, len(msg.ToolCalls))
+			for _, call := range msg.ToolCalls {
+				args := json.RawMessage(call.Function.Arguments)
+				if !json.Valid(args) {
+					args = json.RawMessage("{}")
+				}
+				calls = append(calls, map[string]interface{}{
+					"function": map[string]interface{}{
+						"name":      call.Function.Name,
+						"arguments": args,
+					},
+				})
+			}
+			entry["tool_calls"] = calls
+		}
+		converted = append(converted, entry)
+	}
+	return converted
+}
+
+// ListModels returns the locally available models from /api/tags
+func (s *OllamaService) ListModels(ctx context.Context) ([]string, error) {
+	resp, err := s.do(ctx, http.MethodGet, "/api/tags", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Models []struct {
+			Name string `json:"name"`
+		} `json:"models"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode models: %w", err)
+	}
+
+	models := make([]string, 0, len(result.Models))
+	for _, m := range result.Models {
+		models = append(models, m.Name)
+	}
+	return models, nil
+}
+
+// Embeddings calls /api/embed with all inputs in one batch
+func (s *OllamaService) Embeddings(ctx context.Context, model string, inputs []string) ([][]float32, error) {
+	payload := map[string]interface{}{
+		"model": model,
+		"input": inputs,
+	}
+
+	resp, err := s.do(ctx, http.MethodPost, "/api/embed", payload)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Embeddings [][]float32 `json:"embeddings"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode embeddings: %w", err)
+	}
+	return result.Embeddings, nil
+}
+
+// Moderate is not offered by Ollama
+func (s *OllamaService) Moderate(ctx context.Context, model string, inputs []string) ([]ModerationResult, error) {
+	return nil, ErrModerationNotSupported
+}
@@ -1,52 +1,56 @@
 package services
 
 import (
-	"bufio"
+	"bytes"
+	"chat-ai-backend/internal/models"
 	"chat-ai-backend/utils"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
 	"strings"
 )
 
+// OpenAIService talks to any OpenAI-compatible chat completions endpoint
 type OpenAIService struct {
 	OpenAIKey string
 	OpenAIUrl string
-	Client    *http.Client
+	Client    *ResilientClient
 }
 
 // Constructor
-func NewOpenAIService(url string, openaiKey string) *OpenAIService {
+func NewOpenAIService(url string, openaiKey string, client *ResilientClient) *OpenAIService {
 	return &OpenAIService{
 		OpenAIKey: openaiKey,
 		OpenAIUrl: url,
-		Client:    &http.Client{Timeout: 0}, // no timeout for streaming
+		Client:    client,
 	}
 }
 
-// GenerateAIResponse sends a message to the LLM and streams the response
-func (s *OpenAIService) GenerateAIResponse(message, conversationID string, responseChan chan<- string) {
-	defer close(responseChan)
+func (s *OpenAIService) Name() string {
+	return "openai"
+}
 
-	payload := map[string]interface{}{
-		"model": "gpt-4",
-		"messages": []map[string]string{
-			{"role": "user", "content": message},
-		},
-		"stream": true,
-	}
+// baseURL derives the API root (".../v1") from the configured chat completions URL
+func (s *OpenAIService) baseURL() string {
+	return strings.TrimSuffix(strings.TrimSuffix(s.OpenAIUrl, "/"), "/chat/completions")
+}
 
-	jsonData, err := json.Marshal(payload)
-	if err != nil {
-		utils.Logger.Error("Failed to marshal payload: %v\n", err)
-		responseChan <- "Error encoding request"
-		return
+func (s *OpenAIService) newRequest(ctx context.Context, method, url string, body interface{}) (*http.Request, error) {
+	var reader io.Reader
+	if body != nil {
+		jsonData, err := json.Marshal(body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal payload: %w", err)
+		}
+		reader = bytes.NewReader(jsonData)
 	}
 
-	req, err := http.NewRequest("POST", s.OpenAIUrl, strings.NewReader(string(jsonData)))
+	req, err := http.NewRequestWithContext(ctx, method, url, reader)
 	if err != nil {
-		utils.Logger.Error("Failed to create request: %v\n", err)
-		responseChan <- "Error creating request"
-		return
+		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
 	req.Header.Set("Content-Type", "application/json")
 
@@ -54,55 +58,238 @@ func (s *OpenAIService) GenerateAIResponse(message, conversationID string, respo
 	if s.OpenAIKey != "" {
 		req.Header.Set("Authorization", "Bearer "+s.OpenAIKey)
 	}
+	return req, nil
+}
 
-	resp, err := s.Client.Do(req)
-	if err != nil {
-		utils.Logger.Error("HTTP request failed: %v\n", err)
-		responseChan <- "Error connecting to LLM service"
-		return
+// StreamChat sends a chat completion request and streams the SSE content deltas
+func (s *OpenAIService) StreamChat(ctx context.Context, chatReq ChatRequest, out chan<- StreamEvent) error {
+	payload := map[string]interface{}{
+		"model":    chatReq.Model,
+		"messages": chatReq.Messages,
+		"stream":   true,
+		// Ask for a final chunk carrying the token usage
+		"stream_options": map[string]interface{}{"include_usage": true},
+	}
+	if chatReq.MaxTokens > 0 {
+		payload["max_tokens"] = chatReq.MaxTokens
+	}
+	if chatReq.Temperature != nil {
+		payload["temperature"] = *chatReq.Temperature
+	}
+	if chatReq.TopP != nil {
+		payload["top_p"] = *chatReq.TopP
+	}
+	if len(chatReq.Stop) > 0 {
+		payload["stop"] = chatReq.Stop
+	}
+	if len(chatReq.Tools) > 0 {
+		payload["tools"] = chatReq.Tools
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		utils.Logger.Info("Non-OK HTTP status: %v\n", resp.Status)
-		responseChan <- "Error: LLM service returned an error"
-		return
+	req, err := s.newRequest(ctx, http.MethodPost, s.OpenAIUrl, payload)
+	if err != nil {
+		return err
 	}
 
-	scanner := bufio.NewScanner(resp.Body)
-	for scanner.Scan() {
-		line := scanner.Text()
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
 
-		// Skip empty lines and [DONE]
-		if line == "" || line == "data: [DONE]" {
-			continue
+	// Tool calls arrive in fragments keyed by index and are assembled until the stream ends
+	var toolCalls []ToolCall
+	err = readSSE(resp.Body, func(_, data string) error {
+		if data == "[DONE]" {
+			return io.EOF
 		}
 
-		// Trim "data: " prefix unconditionally
-		line = strings.TrimPrefix(line, "data: ")
-
 		// Parse JSON and extract content
 		var chunk struct {
 			Choices []struct {
 				Delta struct {
-					Content string `json:"content"`
+					Content   string `json:"content"`
+					ToolCalls []struct {
+						Index    int    `json:"index"`
+						ID       string `json:"id"`
+						Type     string `json:"type"`
+						Function struct {
+							Name      string `json:"name"`
+							Arguments string `json:"arguments"`
+						} `json:"function"`
+					} `json:"tool_calls"`
 				} `json:"delta"`
 			} `json:"choices"`
+			Usage *struct {
+				PromptTokens     int `json:"prompt_tokens"`
+				CompletionTokens int `json:"completion_tokens"`
+			} `json:"usage"`
+			Error *struct {
+				Message string `json:"message"`
+			} `json:"error"`
 		}
-		if err := json.Unmarshal([]byte(line), &chunk); err != nil {
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
 			utils.Logger.Error("Failed to unmarshal chunk: %v\n", err)
-			continue
+			return nil
+		}
+
+		// The upstream gave up after the stream started
+		if chunk.Error != nil {
+			return &LLMError{Code: LLMErrorStream, Message: "LLM service failed while streaming", Err: errors.New(chunk.Error.Message)}
+		}
+
+		if chunk.Usage != nil {
+			out <- StreamEvent{Type: StreamEventUsage, Usage: &models.Usage{
+				Model:            chatReq.Model,
+				PromptTokens:     chunk.Usage.PromptTokens,
+				CompletionTokens: chunk.Usage.CompletionTokens,
+				TotalTokens:      chunk.Usage.PromptTokens + chunk.Usage.CompletionTokens,
+			}}
 		}
 
 		if len(chunk.Choices) > 0 {
 			content := chunk.Choices[0].Delta.Content
 			if content != "" {
-				responseChan <- content
+				out <- StreamEvent{Type: StreamEventDelta, Content: content}
+			}
+
+			for _, fragment := range chunk.Choices[0].Delta.ToolCalls {
+				// The index sizes the slice, so a bogus one must not be trusted
+				if fragment.Index < 0 || fragment.Index >= maxToolCalls {
+					return &LLMError{Code: LLMErrorStream, Message: "LLM service sent an invalid tool call",
+						Err: fmt.Errorf("tool call index %d out of range", fragment.Index)}
+				}
+				for len(toolCalls) <= fragment.Index {
+					toolCalls = append(toolCalls, ToolCall{Type: "function"})
+				}
+				call := &toolCalls[fragment.Index]
+				if fragment.ID != "" {
+					call.ID = fragment.ID
+				}
+				call.Function.Name += fragment.Function.Name
+				call.Function.Arguments += fragment.Function.Arguments
 			}
 		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(toolCalls) > 0 {
+		out <- StreamEvent{Type: StreamEventToolCalls, ToolCalls: toolCalls}
 	}
+	return nil
+}
+
+// ListModels returns the model IDs exposed by the /models endpoint
+func (s *OpenAIService) ListModels(ctx context.Context) ([]string, error) {
+	req, err := s.newRequest(ctx, http.MethodGet, s.baseURL()+"/models", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
 
-	if err := scanner.Err(); err != nil {
-		utils.Logger.Error("Error reading response body: %v\n", err)
+	var result struct {
+		Data []struct {
+			ID string `json:"id"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode models: %w", err)
+	}
+
+	models := make([]string, 0, len(result.Data))
+	for _, m := range result.Data {
+		models = append(models, m.ID)
+	}
+	return models, nil
+}
+
+// Embeddings calls the /embeddings endpoint
+func (s *OpenAIService) Embeddings(ctx context.Context, model string, inputs []string) ([][]float32, error) {
+	payload := map[string]interface{}{
+		"model": model,
+		"input": inputs,
+	}
+
+	req, err := s.newRequest(ctx, http.MethodPost, s.baseURL()+"/embeddings", payload)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Data []struct {
+			Index     int       `json:"index"`
+			Embedding []float32 `json:"embedding"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode embeddings: %w", err)
+	}
+
+	vectors := make([][]float32, len(inputs))
+	for _, d := range result.Data {
+		if d.Index >= 0 && d.Index < len(vectors) {
+			vectors[d.Index] = d.Embedding
+		}
+	}
+	return vectors, nil
+}
+
+// Moderate calls /moderations with all inputs in one batch. OpenAI-compatible servers without the
+// endpoint answer 404, which is reported as ErrModerationNotSupported.
+func (s *OpenAIService) Moderate(ctx context.Context, model string, inputs []string) ([]ModerationResult, error) {
+	payload := map[string]interface{}{
+		"model": model,
+		"input": inputs,
+	}
+
+	req, err := s.newRequest(ctx, http.MethodPost, s.baseURL()+"/moderations", payload)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		var llmErr *LLMError
+		if errors.As(err, &llmErr) && llmErr.StatusCode == http.StatusNotFound {
+			return nil, ErrModerationNotSupported
+		}
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Results []struct {
+			Flagged    bool            `json:"flagged"`
+			Categories map[string]bool `json:"categories"`
+		} `json:"results"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode moderation results: %w", err)
+	}
+
+	results := make([]ModerationResult, 0, len(result.Results))
+	for _, r := range result.Results {
+		verdict := ModerationResult{Flagged: r.Flagged}
+		for category, hit := range r.Categories {
+			if hit {
+				verdict.Categories = append(verdict.Categories, category)
+			}
+		}
+		results = append(results, verdict)
 	}
+	return results, nil
 }
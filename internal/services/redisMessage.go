@@ -1,24 +1,41 @@
 package services
 
 import (
+	"chat-ai-backend/config"
 	"chat-ai-backend/internal/models"
 	"chat-ai-backend/internal/repositories"
 	"chat-ai-backend/utils"
+	"encoding/base64"
+	"errors"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 )
 
+var ErrInvalidCursor = errors.New("invalid history cursor")
+
+// HistoryPage is a slice of a conversation's messages. NextCursor loads the messages that follow in
+// the same order and is empty once HasMore is false.
+type HistoryPage struct {
+	Messages   []models.Message
+	NextCursor string
+	HasMore    bool
+}
+
 type RedisMessageService struct {
-	Repo *repositories.RedisMessageRepository
+	Repo        *repositories.RedisMessageRepository
+	SearchIndex *SearchIndexService // nil disables indexing of flushed conversations
 }
 
 // Constructor
-func NewRedisMessageService(repo *repositories.RedisMessageRepository) *RedisMessageService {
-	return &RedisMessageService{Repo: repo}
+func NewRedisMessageService(repo *repositories.RedisMessageRepository, searchIndex *SearchIndexService) *RedisMessageService {
+	return &RedisMessageService{Repo: repo, SearchIndex: searchIndex}
 }
 
 // LoadMessagesIntoRedis ensures messages for a conversation are loaded into Redis from MongoDB
 func (s *RedisMessageService) LoadMsgIntoRedis(conversationID string) ([]models.Message, error) {
-	messages, err := s.Repo.LoadMessagesIntoRedis(conversationID)
+	messages, err := s.Repo.LoadMessagesIntoRedis(conversationID, config.AppConfig.RedisMessageWindow)
 	if err != nil {
 		utils.Logger.Error("Error loading messages into Redis: %v", err)
 		return nil, err
@@ -26,9 +43,142 @@ func (s *RedisMessageService) LoadMsgIntoRedis(conversationID string) ([]models.
 	return messages, nil
 }
 
-// StoreOneMessageInRedis saves a single message to Redis
-func (s *RedisMessageService) StoreOneMsgInRedis(userID, conversationID, question, answer string) error {
-	return s.Repo.StoreOneMessageInRedis(userID, conversationID, question, answer)
+// GetHistoryPage returns up to limit messages before the cursor, or the newest ones for an empty cursor,
+// oldest first as a chat shows them
+func (s *RedisMessageService) GetHistoryPage(conversationID, cursor string, limit int) (*HistoryPage, error) {
+	page, err := s.ListMessages(conversationID, cursor, false, limit)
+	if err != nil {
+		return nil, err
+	}
+	slices.Reverse(page.Messages)
+	return page, nil
+}
+
+// GetFullHistory returns every message of the conversation, oldest first
+func (s *RedisMessageService) GetFullHistory(conversationID string) ([]models.Message, error) {
+	var messages []models.Message
+	cursor := ""
+	for {
+		page, err := s.ListMessages(conversationID, cursor, true, config.MaxHistoryPageSize)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, page.Messages...)
+		if !page.HasMore {
+			return messages, nil
+		}
+		cursor = page.NextCursor
+	}
+}
+
+// ListMessages returns up to limit messages after the cursor in the given order, starting at the
+// newest, or the oldest when ascending, for an empty cursor. Messages not yet flushed from Redis are
+// included and nothing is loaded into it.
+func (s *RedisMessageService) ListMessages(conversationID, cursor string, ascending bool, limit int) (*HistoryPage, error) {
+	var after *repositories.MessageCursor
+	if cursor != "" {
+		decoded, err := decodeCursor(cursor)
+		if err != nil {
+			return nil, err
+		}
+		after = decoded
+	}
+	if limit <= 0 {
+		limit = config.AppConfig.HistoryPageSize
+	}
+	limit = min(limit, config.MaxHistoryPageSize)
+
+	messages, hasMore, err := s.Repo.ListMessagesPage(conversationID, after, ascending, limit)
+	if err != nil {
+		utils.Logger.Error("Error reading history of conversation %s: %v", conversationID, err)
+		return nil, err
+	}
+	page := &HistoryPage{Messages: messages, HasMore: hasMore}
+	if hasMore && len(messages) > 0 {
+		page.NextCursor = encodeCursor(messages[len(messages)-1])
+	}
+	return page, nil
+}
+
+// encodeCursor makes the opaque cursor of the messages after msg
+func encodeCursor(msg models.Message) string {
+	raw := strconv.FormatInt(msg.CreatedAt.UnixMilli(), 10) + "|" + msg.MessageID
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeCursor(cursor string) (*repositories.MessageCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	millis, messageID, found := strings.Cut(string(raw), "|")
+	if !found || messageID == "" {
+		return nil, ErrInvalidCursor
+	}
+	at, err := strconv.ParseInt(millis, 10, 64)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	return &repositories.MessageCursor{CreatedAt: time.UnixMilli(at), MessageID: messageID}, nil
+}
+
+// LoadMessageTree builds the tree of the messages cached in Redis. Redis only keeps the newest messages, so the
+// whole conversation is read when one of the given messages is older than that.
+func (s *RedisMessageService) LoadMessageTree(conversationID string, messageIDs ...string) (*MessageTree, error) {
+	messages, err := s.LoadMsgIntoRedis(conversationID)
+	if err != nil {
+		return nil, err
+	}
+	tree := BuildMessageTree(messages)
+	for _, id := range messageIDs {
+		if _, ok := tree.Nodes[id]; id != "" && !ok {
+			return s.LoadFullMessageTree(conversationID)
+		}
+	}
+	return tree, nil
+}
+
+// VersionIndex numbers a new message among the replies to parentID. A tree of the cached window that does
+// not reach back to the parent may miss older versions, so then the whole conversation is counted.
+func (s *RedisMessageService) VersionIndex(tree *MessageTree, conversationID, parentID string) int {
+	if _, ok := tree.Nodes[parentID]; ok || !tree.Truncated() {
+		return tree.ChildCount(parentID)
+	}
+	full, err := s.LoadFullMessageTree(conversationID)
+	if err != nil {
+		utils.Logger.Error("Failed to count versions in conversation %s: %v", conversationID, err)
+		return tree.ChildCount(parentID)
+	}
+	return full.ChildCount(parentID)
+}
+
+// LoadFullMessageTree builds the tree of every message of a conversation
+func (s *RedisMessageService) LoadFullMessageTree(conversationID string) (*MessageTree, error) {
+	messages, err := s.GetConversationMessages(conversationID)
+	if err != nil {
+		return nil, err
+	}
+	return BuildMessageTree(messages), nil
+}
+
+// GetConversationMessages reads a conversation's messages without side effects
+func (s *RedisMessageService) GetConversationMessages(conversationID string) ([]models.Message, error) {
+	messages, err := s.Repo.GetConversationMessages(conversationID)
+	if err != nil {
+		utils.Logger.Error("Error reading messages of conversation %s: %v", conversationID, err)
+		return nil, err
+	}
+	return messages, nil
+}
+
+// StoreOneMessageInRedis saves a single message to Redis. Older messages it pushes out of the
+// window are written to MongoDB and queued for indexing like a flushed conversation.
+func (s *RedisMessageService) StoreOneMsgInRedis(msg models.Message) error {
+	moved, err := s.Repo.StoreOneMessageInRedis(msg, config.AppConfig.RedisMessageWindow)
+	if moved > 0 && s.SearchIndex != nil {
+		s.SearchIndex.EnqueueConversation(msg.ConversationID)
+	}
+	return err
 }
 
 // MoveConversationToMongo migrates messages from Redis to MongoDB after 30 minutes
@@ -38,6 +188,9 @@ func (s *RedisMessageService) MoveConversationToMongo(conversationID string) err
 		utils.Logger.Error("Error moving conversation %s to MongoDB: %v", conversationID, err)
 		return err
 	}
+	if s.SearchIndex != nil {
+		s.SearchIndex.EnqueueConversation(conversationID)
+	}
 	return nil
 }
 
@@ -0,0 +1,221 @@
+package services
+
+import (
+	"chat-ai-backend/config"
+	"chat-ai-backend/internal/models"
+	"chat-ai-backend/internal/repositories"
+	"chat-ai-backend/utils"
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// Messages embedded per batch while indexing
+const searchIndexBatchSize = 64
+
+// Longest text embedded for one message, in runes
+const searchIndexMaxRunes = 6000
+
+// indexJob asks the worker to index one conversation, or every message for an empty conversation ID
+type indexJob struct {
+	ConversationID string
+	Reindex        bool // Also index messages that were indexed before
+}
+
+// SearchResult is one message found by a semantic search
+type SearchResult struct {
+	MessageID      string    `json:"message_id"`
+	ConversationID string    `json:"conversation_id"`
+	Question       string    `json:"question"`
+	Snippet        string    `json:"snippet"`
+	Score          float32   `json:"score"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// SearchIndexService embeds stored messages into a per-user vector index and searches it.
+// Indexing runs in a single background worker fed by conversation flushes and backfills.
+type SearchIndexService struct {
+	MessageRepo *repositories.MessageRepository
+	Store       repositories.VectorStore
+	Provider    LLMProvider
+	jobs        chan indexJob
+	disabled    atomic.Bool // Set when the provider cannot compute embeddings
+}
+
+// Constructor
+func NewSearchIndexService(messageRepo *repositories.MessageRepository, store repositories.VectorStore, provider LLMProvider) *SearchIndexService {
+	return &SearchIndexService{
+		MessageRepo: messageRepo,
+		Store:       store,
+		Provider:    provider,
+		jobs:        make(chan indexJob, 256),
+	}
+}
+
+// Start runs the indexing worker until ctx is cancelled
+func (s *SearchIndexService) Start(ctx context.Context) {
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case job := <-s.jobs:
+				if s.disabled.Load() {
+					continue
+				}
+				if err := s.indexMessages(ctx, job); err != nil {
+					if errors.Is(err, ErrEmbeddingsNotSupported) {
+						utils.Logger.Warn("Search indexing disabled: %s cannot compute embeddings", s.Provider.Name())
+						s.disabled.Store(true)
+						continue
+					}
+					utils.Logger.Error("Search indexing of %q failed: %v", job.ConversationID, err)
+				}
+			}
+		}
+	}()
+}
+
+// EnqueueConversation indexes the new messages of a conversation that was flushed to MongoDB
+func (s *SearchIndexService) EnqueueConversation(conversationID string) {
+	s.enqueue(indexJob{ConversationID: conversationID})
+}
+
+// Backfill indexes every message not indexed yet, or every message at all with reindex
+func (s *SearchIndexService) Backfill(reindex bool) {
+	s.enqueue(indexJob{Reindex: reindex})
+}
+
+func (s *SearchIndexService) enqueue(job indexJob) {
+	select {
+	case s.jobs <- job:
+	default:
+		// The next backfill picks up whatever is dropped here
+		utils.Logger.Warn("Search index queue full, dropping job for %q", job.ConversationID)
+	}
+}
+
+// indexMessages embeds the messages of a job page by page and marks them as indexed
+func (s *SearchIndexService) indexMessages(ctx context.Context, job indexJob) error {
+	afterID := ""
+	indexed := 0
+	for {
+		messages, err := s.MessageRepo.FindMessagesToIndex(job.ConversationID, afterID, !job.Reindex, searchIndexBatchSize)
+		if err != nil {
+			return err
+		}
+		if len(messages) == 0 {
+			break
+		}
+		afterID = messages[len(messages)-1].ID
+
+		var texts []string
+		var toEmbed []models.Message
+		messageIDs := make([]string, 0, len(messages))
+		for _, msg := range messages {
+			messageIDs = append(messageIDs, msg.MessageID)
+			if msg.Status == models.MessageStatusFailed || msg.Question == "" {
+				continue // Marked as indexed so it is not fetched again
+			}
+			toEmbed = append(toEmbed, msg)
+			texts = append(texts, truncateRunes(messageSearchText(msg), searchIndexMaxRunes))
+		}
+
+		if len(texts) > 0 {
+			embedCtx, cancel := context.WithTimeout(ctx, time.Minute)
+			vectors, err := s.Provider.Embeddings(embedCtx, config.AppConfig.LLMEmbeddingModel, texts)
+			cancel()
+			if err != nil {
+				return err
+			}
+			if len(vectors) != len(texts) {
+				return fmt.Errorf("embeddings returned %d vectors for %d inputs", len(vectors), len(texts))
+			}
+
+			// Group by user, every user has a collection of their own
+			byUser := map[string][]repositories.VectorChunk{}
+			for i, msg := range toEmbed {
+				byUser[msg.UserID] = append(byUser[msg.UserID], repositories.VectorChunk{
+					ID:         msg.MessageID,
+					DocumentID: msg.ConversationID,
+					Text:       texts[i],
+					Vector:     vectors[i],
+				})
+			}
+			for userID, chunks := range byUser {
+				if err := s.Store.Upsert(ctx, userID, chunks); err != nil {
+					return err
+				}
+			}
+		}
+
+		if err := s.MessageRepo.MarkMessagesIndexed(messageIDs, time.Now()); err != nil {
+			return err
+		}
+		indexed += len(toEmbed)
+	}
+
+	if indexed > 0 {
+		utils.Logger.Info("Indexed %d messages for search (conversation %q)", indexed, job.ConversationID)
+	}
+	return nil
+}
+
+// messageSearchText is the text embedded for a message
+func messageSearchText(msg models.Message) string {
+	return "Question: " + msg.Question + "\nAnswer: " + msg.Answer
+}
+
+// Search returns the user's messages closest in meaning to the query, best first
+func (s *SearchIndexService) Search(ctx context.Context, userID, query string, limit int) ([]SearchResult, error) {
+	vectors, err := s.Provider.Embeddings(ctx, config.AppConfig.LLMEmbeddingModel, []string{query})
+	if err != nil {
+		return nil, err
+	}
+	if len(vectors) != 1 {
+		return nil, fmt.Errorf("embeddings returned %d vectors for 1 input", len(vectors))
+	}
+
+	// Ask for extra matches, messages of deleted conversations are dropped below
+	matches, err := s.Store.Search(ctx, userID, vectors[0], limit*2)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(matches))
+	for _, match := range matches {
+		ids = append(ids, match.Chunk.ID)
+	}
+	messages, err := s.MessageRepo.GetUserMessagesByIDs(userID, ids)
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[string]models.Message, len(messages))
+	for _, msg := range messages {
+		byID[msg.MessageID] = msg
+	}
+
+	results := []SearchResult{}
+	for _, match := range matches {
+		msg, ok := byID[match.Chunk.ID]
+		if !ok {
+			continue
+		}
+		results = append(results, SearchResult{
+			MessageID:      msg.MessageID,
+			ConversationID: msg.ConversationID,
+			Question:       msg.Question,
+			Snippet:        truncateRunes(msg.Answer, 200),
+			Score:          match.Score,
+			CreatedAt:      msg.CreatedAt,
+		})
+	}
+	sort.SliceStable(results, func(i, j int) bool { return results[i].Score > results[j].Score })
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	return results, nil
+}
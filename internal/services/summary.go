@@ -0,0 +1,138 @@
+package services
+
+import (
+	"chat-ai-backend/config"
+	"chat-ai-backend/internal/models"
+	"chat-ai-backend/utils"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+const summaryPrompt = "You maintain a running summary of a conversation between a user and an assistant. " +
+	"Merge the new turns into the existing summary. Keep facts, decisions, names, numbers and open questions; " +
+	"drop small talk. Write plain prose, no headings, and reply with the updated summary only."
+
+// SummaryService condenses the older turns of long conversations so the prompt stays small
+type SummaryService struct {
+	LLMService          *LLMService
+	ConversationService *ConversationService
+	UsageService        *UsageService
+	inFlight            sync.Map // Conversation IDs currently being summarized
+}
+
+// Constructor
+func NewSummaryService(llmSvc *LLMService, convoSvc *ConversationService, usageSvc *UsageService) *SummaryService {
+	return &SummaryService{
+		LLMService:          llmSvc,
+		ConversationService: convoSvc,
+		UsageService:        usageSvc,
+	}
+}
+
+// ApplySummary splits a branch into the summary of its beginning and the turns after it.
+// A summary of another branch does not apply and the whole history is returned.
+func ApplySummary(summary *models.ConversationSummary, history []models.Message) (string, []models.Message) {
+	if summary == nil || summary.Content == "" {
+		return "", history
+	}
+	for i, msg := range history {
+		if msg.MessageID == summary.CoveredUntilID {
+			return summary.Content, history[i+1:]
+		}
+	}
+	return "", history
+}
+
+// SummarizeAsync extends the summary in the background; only one run per conversation at a time
+func (s *SummaryService) SummarizeAsync(userID, conversationID string, summary *models.ConversationSummary, history []models.Message) {
+	if _, busy := s.inFlight.LoadOrStore(conversationID, true); busy {
+		return
+	}
+	go func() {
+		defer s.inFlight.Delete(conversationID)
+		if _, err := s.Summarize(userID, conversationID, summary, history); err != nil {
+			utils.Logger.Warn("Failed to summarize conversation %s: %v", conversationID, err)
+		}
+	}()
+}
+
+// Summarize folds the turns that are neither summarized yet nor among the most recent ones into the
+// summary, once the unsummarized history exceeds the configured threshold. The previous summary is
+// extended rather than rebuilt. It returns nil when nothing had to be done.
+func (s *SummaryService) Summarize(userID, conversationID string, summary *models.ConversationSummary, history []models.Message) (*models.ConversationSummary, error) {
+	trigger := config.AppConfig.SummaryTriggerTokens
+	if trigger <= 0 {
+		return nil, nil
+	}
+
+	previous, rest := ApplySummary(summary, history)
+	keep := config.AppConfig.SummaryKeepTurns
+	if keep < 0 {
+		keep = 0
+	}
+	if len(rest) <= keep {
+		return nil, nil
+	}
+
+	tokens := EstimateTokens(previous)
+	for _, turn := range rest {
+		tokens += estimateTurnTokens(turn)
+	}
+	if tokens < trigger {
+		return nil, nil
+	}
+
+	fold := rest[:len(rest)-keep]
+	var transcript strings.Builder
+	for _, turn := range fold {
+		if turn.Status == models.MessageStatusFailed {
+			continue
+		}
+		fmt.Fprintf(&transcript, "User: %s\nAssistant: %s\n\n", turn.Question, turn.Answer)
+	}
+
+	existing := previous
+	if existing == "" {
+		existing = "(none yet)"
+	}
+	messages := []ChatMessage{
+		{Role: "system", Content: summaryPrompt},
+		{Role: "user", Content: fmt.Sprintf("Existing summary:\n%s\n\nNew turns:\n%s", existing, transcript.String())},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	content, usage, err := s.LLMService.Complete(ctx, config.AppConfig.SummaryModel, messages, config.AppConfig.SummaryMaxTokens)
+	s.UsageService.RecordUsage(userID, usage)
+	if err != nil {
+		return nil, err
+	}
+	content = strings.TrimSpace(content)
+	if content == "" {
+		return nil, errors.New("LLM returned an empty summary")
+	}
+
+	covered := len(fold)
+	if previous != "" {
+		covered += summary.CoveredMessages
+	}
+	updated := models.ConversationSummary{
+		Content:         content,
+		CoveredUntilID:  fold[len(fold)-1].MessageID,
+		CoveredMessages: covered,
+		Tokens:          EstimateTokens(content),
+		UpdatedAt:       time.Now(),
+	}
+	if err := s.ConversationService.Repo.UpdateConversationSummary(conversationID, updated); err != nil {
+		return nil, err
+	}
+
+	utils.Logger.Info("Summarized %d turns of conversation %s (%d tokens of history into %d)",
+		len(fold), conversationID, tokens, updated.Tokens)
+	return &updated, nil
+}
@@ -0,0 +1,110 @@
+package services
+
+import (
+	"chat-ai-backend/config"
+	"chat-ai-backend/internal/repositories"
+	"chat-ai-backend/utils"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const titlePrompt = "Write a short title of at most six words for the conversation below. " +
+	"Reply with the title only, without quotes or a trailing period."
+
+// Longest title that is stored, in runes
+const maxTitleLength = 60
+
+// TitleService names conversations from their first exchange
+type TitleService struct {
+	LLMService          *LLMService
+	ConversationService *ConversationService
+	UserService         *UserService
+	UsageService        *UsageService
+}
+
+// Constructor
+func NewTitleService(llmSvc *LLMService, convoSvc *ConversationService, userSvc *UserService, usageSvc *UsageService) *TitleService {
+	return &TitleService{
+		LLMService:          llmSvc,
+		ConversationService: convoSvc,
+		UserService:         userSvc,
+		UsageService:        usageSvc,
+	}
+}
+
+// GenerateTitleAsync titles the conversation in the background and calls onTitle with the stored title.
+// Nothing happens if the user turned automatic titles off or already chose a title.
+func (s *TitleService) GenerateTitleAsync(userID, conversationID, question, answer string, onTitle func(title string)) {
+	go func() {
+		title, err := s.GenerateTitle(userID, conversationID, question, answer)
+		if err != nil {
+			if !errors.Is(err, repositories.ErrCustomTitle) {
+				utils.Logger.Warn("Failed to title conversation %s: %v", conversationID, err)
+			}
+			return
+		}
+		if title != "" && onTitle != nil {
+			onTitle(title)
+		}
+	}()
+}
+
+// GenerateTitle asks the LLM for a title and stores it. It returns "" when titling is switched off.
+func (s *TitleService) GenerateTitle(userID, conversationID, question, answer string) (string, error) {
+	if !s.UserService.AutoTitleEnabled(userID) {
+		return "", nil
+	}
+
+	convo, err := s.ConversationService.GetConversation(userID, conversationID)
+	if err != nil {
+		return "", err
+	}
+	if convo.CustomTitle {
+		return "", repositories.ErrCustomTitle
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	messages := []ChatMessage{
+		{Role: "system", Content: titlePrompt},
+		{Role: "user", Content: fmt.Sprintf("User: %s\n\nAssistant: %s", truncateRunes(question, 1000), truncateRunes(answer, 1000))},
+	}
+	content, usage, err := s.LLMService.Complete(ctx, config.AppConfig.TitleModel, messages, 20)
+	s.UsageService.RecordUsage(userID, usage)
+	if err != nil {
+		return "", err
+	}
+
+	title := cleanTitle(content)
+	if title == "" {
+		return "", errors.New("LLM returned an empty title")
+	}
+
+	// The repository refuses to overwrite a title the user set while this one was generated
+	if err := s.ConversationService.Repo.UpdateConversationTitle(conversationID, title, false); err != nil {
+		return "", err
+	}
+	utils.Logger.Info("Generated title for conversation %s: %s", conversationID, title)
+	return title, nil
+}
+
+// cleanTitle keeps the first line of the model output without quotes, markdown or a trailing period
+func cleanTitle(content string) string {
+	title := strings.TrimSpace(content)
+	if idx := strings.IndexByte(title, '\n'); idx >= 0 {
+		title = title[:idx]
+	}
+	title = strings.TrimPrefix(title, "Title:")
+	title = strings.Trim(title, " \t\"'`*#.")
+	title = strings.TrimSpace(title)
+
+	runes := []rune(title)
+	if len(runes) > maxTitleLength {
+		title = strings.TrimSpace(string(runes[:maxTitleLength]))
+	}
+	return title
+}
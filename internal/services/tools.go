@@ -0,0 +1,208 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrUnknownTool is returned when the model calls a tool that is not registered
+var ErrUnknownTool = errors.New("unknown tool")
+
+// ToolCall is a tool invocation requested by the model, in the OpenAI wire format
+type ToolCall struct {
+	ID       string           `json:"id"`
+	Type     string           `json:"type"`
+	Function ToolCallFunction `json:"function"`
+}
+
+type ToolCallFunction struct {
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"` // JSON encoded arguments
+}
+
+// ToolDefinition describes a tool to the model, in the OpenAI wire format
+type ToolDefinition struct {
+	Type     string             `json:"type"`
+	Function ToolFunctionSchema `json:"function"`
+}
+
+type ToolFunctionSchema struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description"`
+	Parameters  json.RawMessage `json:"parameters"` // JSON schema of the arguments
+}
+
+// ToolContext identifies who the tool is running for
+type ToolContext struct {
+	UserID         string
+	ConversationID string
+}
+
+// ToolFunc runs a tool with the JSON arguments chosen by the model and returns the result text
+type ToolFunc func(ctx context.Context, tc ToolContext, args json.RawMessage) (string, error)
+
+// Tool is a Go function the model may call
+type Tool struct {
+	Name        string
+	Description string
+	Parameters  json.RawMessage
+	Func        ToolFunc
+}
+
+// ToolRegistry holds the tools offered to the model
+type ToolRegistry struct {
+	mu    sync.RWMutex
+	tools map[string]Tool
+}
+
+// Constructor
+func NewToolRegistry() *ToolRegistry {
+	return &ToolRegistry{tools: make(map[string]Tool)}
+}
+
+// Register adds a tool; names must be unique and the schema must be valid JSON
+func (r *ToolRegistry) Register(tool Tool) error {
+	if tool.Name == "" || tool.Func == nil {
+		return errors.New("tool needs a name and a function")
+	}
+	if len(tool.Parameters) == 0 {
+		tool.Parameters = json.RawMessage(`{"type":"object","properties":{}}`)
+	}
+	if !json.Valid(tool.Parameters) {
+		return fmt.Errorf("tool %s has an invalid JSON schema", tool.Name)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, exists := r.tools[tool.Name]; exists {
+		return fmt.Errorf("tool %s is already registered", tool.Name)
+	}
+	r.tools[tool.Name] = tool
+	return nil
+}
+
+// Definitions returns the schema of every registered tool, sorted by name
+func (r *ToolRegistry) Definitions() []ToolDefinition {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	defs := make([]ToolDefinition, 0, len(r.tools))
+	for _, tool := range r.tools {
+		defs = append(defs, ToolDefinition{
+			Type: "function",
+			Function: ToolFunctionSchema{
+				Name:        tool.Name,
+				Description: tool.Description,
+				Parameters:  tool.Parameters,
+			},
+		})
+	}
+	sort.Slice(defs, func(i, j int) bool { return defs[i].Function.Name < defs[j].Function.Name })
+	return defs
+}
+
+// Call runs the named tool
+func (r *ToolRegistry) Call(ctx context.Context, tc ToolContext, name string, args string) (string, error) {
+	r.mu.RLock()
+	tool, ok := r.tools[name]
+	r.mu.RUnlock()
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrUnknownTool, name)
+	}
+
+	if strings.TrimSpace(args) == "" {
+		args = "{}"
+	}
+	if !json.Valid([]byte(args)) {
+		return "", fmt.Errorf("invalid JSON arguments for tool %s", name)
+	}
+	return tool.Func(ctx, tc, json.RawMessage(args))
+}
+
+// RegisterBuiltinTools adds the tools every deployment offers
+func RegisterBuiltinTools(registry *ToolRegistry, messageService *MessageService) error {
+	if err := registry.Register(Tool{
+		Name:        "get_current_time",
+		Description: "Get the current date and time, optionally in an IANA time zone such as Asia/Taipei.",
+		Parameters:  json.RawMessage(`{"type":"object","properties":{"timezone":{"type":"string","description":"IANA time zone name, defaults to UTC"}}}`),
+		Func:        currentTimeTool,
+	}); err != nil {
+		return err
+	}
+
+	return registry.Register(Tool{
+		Name:        "search_conversations",
+		Description: "Search the user's own past questions and answers for a text.",
+		Parameters:  json.RawMessage(`{"type":"object","properties":{"query":{"type":"string","description":"Text to look for"},"limit":{"type":"integer","description":"Maximum number of results, at most 10"}},"required":["query"]}`),
+		Func: func(ctx context.Context, tc ToolContext, args json.RawMessage) (string, error) {
+			var input struct {
+				Query string `json:"query"`
+				Limit int    `json:"limit"`
+			}
+			if err := json.Unmarshal(args, &input); err != nil {
+				return "", err
+			}
+			if strings.TrimSpace(input.Query) == "" {
+				return "", errors.New("query is required")
+			}
+			if input.Limit <= 0 || input.Limit > 10 {
+				input.Limit = 5
+			}
+
+			messages, err := messageService.SearchMessages(tc.UserID, input.Query, input.Limit)
+			if err != nil {
+				return "", err
+			}
+
+			type result struct {
+				ConversationID string `json:"conversation_id"`
+				Question       string `json:"question"`
+				Answer         string `json:"answer"`
+				CreatedAt      string `json:"created_at"`
+			}
+			results := make([]result, 0, len(messages))
+			for _, msg := range messages {
+				results = append(results, result{
+					ConversationID: msg.ConversationID,
+					Question:       msg.Question,
+					Answer:         truncateRunes(msg.Answer, 500),
+					CreatedAt:      msg.CreatedAt.Format(time.RFC3339),
+				})
+			}
+			out, err := json.Marshal(results)
+			return string(out), err
+		},
+	})
+}
+
+func currentTimeTool(ctx context.Context, tc ToolContext, args json.RawMessage) (string, error) {
+	var input struct {
+		Timezone string `json:"timezone"`
+	}
+	if err := json.Unmarshal(args, &input); err != nil {
+		return "", err
+	}
+
+	loc := time.UTC
+	if input.Timezone != "" {
+		var err error
+		if loc, err = time.LoadLocation(input.Timezone); err != nil {
+			return "", fmt.Errorf("unknown time zone %q", input.Timezone)
+		}
+	}
+	return time.Now().In(loc).Format(time.RFC1123Z), nil
+}
+
+func truncateRunes(text string, max int) string {
+	runes := []rune(text)
+	if len(runes) <= max {
+		return text
+	}
+	return string(runes[:max]) + "…"
+}
@@ -6,18 +6,36 @@ import (
 )
 
 type UpdateMessageService struct {
-	Repo *repositories.MessageUpdateRepository
+	Repo                 *repositories.MessageUpdateRepository
+	AuthorizationService *AuthorizationService
+	EventService         *ConversationEventService
 }
 
-func NewUpdateMessageService(repo *repositories.MessageUpdateRepository) *UpdateMessageService {
-	return &UpdateMessageService{Repo: repo}
+func NewUpdateMessageService(repo *repositories.MessageUpdateRepository, authorizationSvc *AuthorizationService, eventSvc *ConversationEventService) *UpdateMessageService {
+	return &UpdateMessageService{Repo: repo, AuthorizationService: authorizationSvc, EventService: eventSvc}
 }
 
-func (s *UpdateMessageService) UpdateMessage(messageID, feedback string, thumbUp int) error {
-	err := s.Repo.UpdateMessageByMessageID(messageID, feedback, thumbUp)
+// UpdateMessage stores the feedback on a message of the user; messages of other users are
+// repositories.ErrMessageNotFound
+func (s *UpdateMessageService) UpdateMessage(userID, messageID, feedback string, thumbUp int) error {
+	if _, err := s.AuthorizationService.AuthorizeMessage(userID, messageID); err != nil {
+		return err
+	}
+
+	conversationID, err := s.Repo.UpdateMessageByMessageID(messageID, feedback, thumbUp)
 	if err != nil {
 		utils.Logger.Error("Service failed to update message: %v\n", err)
 		return err
 	}
+
+	// Show the feedback on every device the conversation is open on
+	if conversationID != "" {
+		s.EventService.Publish(ConversationEvent{
+			Type:           ConversationEventFeedbackUpdated,
+			ConversationID: conversationID,
+			MessageID:      messageID,
+			Data:           map[string]interface{}{"message_id": messageID, "feedback": feedback, "thumb_up": thumbUp},
+		})
+	}
 	return nil
 }
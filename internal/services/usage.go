@@ -0,0 +1,75 @@
+package services
+
+import (
+	"chat-ai-backend/config"
+	"chat-ai-backend/internal/models"
+	"chat-ai-backend/internal/repositories"
+	"chat-ai-backend/utils"
+	"errors"
+	"time"
+)
+
+// ErrQuotaExceeded is returned when a user has used up the daily quota
+var ErrQuotaExceeded = errors.New("daily usage quota exceeded")
+
+// Dates are bucketed in UTC
+const usageDateLayout = "2006-01-02"
+
+type UsageService struct {
+	Repo *repositories.UsageRepository
+}
+
+func NewUsageService(repo *repositories.UsageRepository) *UsageService {
+	return &UsageService{Repo: repo}
+}
+
+// RecordUsage adds the usage of one answer to the user's daily totals
+func (s *UsageService) RecordUsage(userID string, usage *models.Usage) error {
+	if usage == nil {
+		return nil
+	}
+	today := time.Now().UTC().Format(usageDateLayout)
+	if err := s.Repo.IncrementUsage(userID, today, *usage); err != nil {
+		utils.Logger.Error("Service failed to record usage: %v\n", err)
+		return err
+	}
+	return nil
+}
+
+// CheckQuota returns ErrQuotaExceeded when the user may not start another request today
+func (s *UsageService) CheckQuota(userID string) error {
+	tokenQuota := config.AppConfig.DailyTokenQuota
+	requestQuota := config.AppConfig.DailyRequestQuota
+	if tokenQuota <= 0 && requestQuota <= 0 {
+		return nil // quotas disabled
+	}
+
+	today, err := s.Repo.GetDailyUsage(userID, time.Now().UTC().Format(usageDateLayout))
+	if err != nil {
+		// Do not lock users out because Redis had a hiccup
+		utils.Logger.Error("Failed to check quota for user %s: %v", userID, err)
+		return nil
+	}
+
+	if tokenQuota > 0 && today.TotalTokens >= tokenQuota {
+		return ErrQuotaExceeded
+	}
+	if requestQuota > 0 && today.Requests >= requestQuota {
+		return ErrQuotaExceeded
+	}
+	return nil
+}
+
+// GetUsage returns the user's usage of the last days (today included), newest first
+func (s *UsageService) GetUsage(userID string, days int) ([]*repositories.DailyUsage, error) {
+	now := time.Now().UTC()
+	usage := make([]*repositories.DailyUsage, 0, days)
+	for i := 0; i < days; i++ {
+		day, err := s.Repo.GetDailyUsage(userID, now.AddDate(0, 0, -i).Format(usageDateLayout))
+		if err != nil {
+			return nil, err
+		}
+		usage = append(usage, day)
+	}
+	return usage, nil
+}
@@ -0,0 +1,57 @@
+package services
+
+import (
+	"chat-ai-backend/config"
+	"chat-ai-backend/internal/models"
+	"chat-ai-backend/internal/repositories"
+	"chat-ai-backend/utils"
+	"context"
+	"time"
+)
+
+type UserService struct {
+	Repo *repositories.UserRepository
+}
+
+func NewUserService(repo *repositories.UserRepository) *UserService {
+	return &UserService{Repo: repo}
+}
+
+// GetPreferences returns the user's preferences with the server defaults filled in
+func (s *UserService) GetPreferences(userID string) (*models.UserPreferences, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	prefs, err := s.Repo.GetPreferences(ctx, userID)
+	if err != nil {
+		utils.Logger.Error("Failed to load preferences for user %s: %v", userID, err)
+		return nil, err
+	}
+	if prefs.AutoTitle == nil {
+		autoTitle := config.AppConfig.AutoTitleEnabled
+		prefs.AutoTitle = &autoTitle
+	}
+	return prefs, nil
+}
+
+// UpdatePreferences stores the user's preferences
+func (s *UserService) UpdatePreferences(userID string, prefs models.UserPreferences) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := s.Repo.UpdatePreferences(ctx, userID, prefs); err != nil {
+		utils.Logger.Error("Failed to update preferences for user %s: %v", userID, err)
+		return err
+	}
+	utils.Logger.Info("Updated preferences for user %s", userID)
+	return nil
+}
+
+// AutoTitleEnabled reports whether conversations of the user get generated titles
+func (s *UserService) AutoTitleEnabled(userID string) bool {
+	prefs, err := s.GetPreferences(userID)
+	if err != nil {
+		return false
+	}
+	return *prefs.AutoTitle
+}
@@ -0,0 +1,62 @@
+// middleware/apiKey.go
+
+package middleware
+
+import (
+	"chat-ai-backend/internal/services"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+type APIKeyMiddleware struct {
+	APIKeyService *services.APIKeyService
+}
+
+// Constructor
+func NewAPIKeyMiddleware(apiKeyService *services.APIKeyService) *APIKeyMiddleware {
+	return &APIKeyMiddleware{APIKeyService: apiKeyService}
+}
+
+// APIKeyMiddleware authenticates "Authorization: Bearer <key>" requests of the OpenAI-compatible API.
+// Failures are reported in the OpenAI error format, which those clients expect.
+func (m *APIKeyMiddleware) APIKeyMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		plain, found := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !found || plain == "" {
+			abortOpenAI(c, http.StatusUnauthorized, "missing_api_key", "Missing API key, send it as 'Authorization: Bearer <key>'")
+			return
+		}
+
+		key, err := m.APIKeyService.Authenticate(strings.TrimSpace(plain))
+		if err != nil {
+			if errors.Is(err, services.ErrInvalidAPIKey) {
+				abortOpenAI(c, http.StatusUnauthorized, "invalid_api_key", "Invalid API key")
+				return
+			}
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": gin.H{
+				"message": "Failed to check API key",
+				"type":    "api_error",
+				"param":   nil,
+				"code":    nil,
+			}})
+			return
+		}
+
+		// Set userID in context before continuing
+		c.Set("userID", key.UserID)
+		c.Set("apiKeyID", key.ID)
+		c.Next()
+	}
+}
+
+func abortOpenAI(c *gin.Context, status int, code, message string) {
+	c.AbortWithStatusJSON(status, gin.H{"error": gin.H{
+		"message": message,
+		"type":    "invalid_request_error",
+		"param":   nil,
+		"code":    code,
+	}})
+}
@@ -0,0 +1,70 @@
+// middleware/authorization.go
+
+package middleware
+
+import (
+	"chat-ai-backend/internal/repositories"
+	"chat-ai-backend/internal/services"
+	"chat-ai-backend/utils"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type AuthorizationMiddleware struct {
+	AuthorizationService *services.AuthorizationService
+}
+
+// Constructor
+func NewAuthorizationMiddleware(authorizationService *services.AuthorizationService) *AuthorizationMiddleware {
+	return &AuthorizationMiddleware{AuthorizationService: authorizationService}
+}
+
+// ConversationAccess lets a request through only if the user may access the conversation in the :id
+// parameter. Conversations of other users get 404, like missing ones.
+func (m *AuthorizationMiddleware) ConversationAccess() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// Extract userID from context
+		userID, ok := utils.GetUserIDFromContext(c)
+		if !ok {
+			c.Abort()
+			return // Response already written in util
+		}
+
+		if _, err := m.AuthorizationService.AuthorizeConversation(userID, c.Param("id")); err != nil {
+			if errors.Is(err, repositories.ErrConversationNotFound) {
+				utils.Logger.Warn("User %s denied access to conversation %s", userID, c.Param("id"))
+				c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "Conversation not found"})
+				return
+			}
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to check access to conversation"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// MessageAccess lets a request through only if the user may access the conversation of the message in
+// the :id parameter. Messages of other users get 404, like missing ones.
+func (m *AuthorizationMiddleware) MessageAccess() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// Extract userID from context
+		userID, ok := utils.GetUserIDFromContext(c)
+		if !ok {
+			c.Abort()
+			return // Response already written in util
+		}
+
+		if _, err := m.AuthorizationService.AuthorizeMessage(userID, c.Param("id")); err != nil {
+			if errors.Is(err, repositories.ErrMessageNotFound) {
+				utils.Logger.Warn("User %s denied access to message %s", userID, c.Param("id"))
+				c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "Message not found"})
+				return
+			}
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to check access to message"})
+			return
+		}
+		c.Next()
+	}
+}
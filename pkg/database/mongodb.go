@@ -17,6 +17,9 @@ var (
 	UserCollection         *mongo.Collection
 	ConversationCollection *mongo.Collection
 	MessageCollection      *mongo.Collection
+	DocumentCollection     *mongo.Collection
+	AttachmentCollection   *mongo.Collection
+	APIKeyCollection       *mongo.Collection
 )
 
 // createIndexes creates indexes for the provided collection
@@ -68,6 +71,9 @@ func InitMongo(mongoURI string) {
 	UserCollection = db.Collection("users")
 	ConversationCollection = db.Collection("conversations")
 	MessageCollection = db.Collection("messages")
+	DocumentCollection = db.Collection("documents")
+	AttachmentCollection = db.Collection("attachments")
+	APIKeyCollection = db.Collection("api_keys")
 
 	// Create indexes for collections
 	log.Println("Creating indexes for collections...")
@@ -81,11 +87,44 @@ func InitMongo(mongoURI string) {
 		{
 			Keys: bson.D{{Key: "user_id", Value: 1}},
 		},
+		{
+			// Conversation list in each of its sort orders
+			Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "updated_at", Value: -1}, {Key: "_id", Value: -1}},
+		},
+		{
+			Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "last_message_at", Value: -1}, {Key: "_id", Value: -1}},
+		},
+		{
+			Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: -1}, {Key: "_id", Value: -1}},
+		},
 	})
 	createIndexes(MessageCollection, []mongo.IndexModel{
 		{
 			Keys: bson.D{{Key: "message_id", Value: 1}}, // Index on "conversation_id" for quick lookup
 		},
+		{
+			// History pages, newest first
+			Keys: bson.D{{Key: "conversation_id", Value: 1}, {Key: "created_at", Value: -1}, {Key: "message_id", Value: -1}},
+		},
+	})
+	createIndexes(DocumentCollection, []mongo.IndexModel{
+		{
+			Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: -1}},
+		},
+	})
+	createIndexes(AttachmentCollection, []mongo.IndexModel{
+		{
+			Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: -1}},
+		},
+	})
+	createIndexes(APIKeyCollection, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "hash", Value: 1}}, // Keys are looked up by hash on every request
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: -1}},
+		},
 	})
 	log.Println("Collections and indexes initialized successfully!")
 }
@@ -0,0 +1,122 @@
+// Package fakellm is an offline stand-in for the OpenAI API. It streams scripted chat completions
+// so the backend can be run and tested without an API key.
+package fakellm
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Fixture scripts the answers of the fake server
+type Fixture struct {
+	Models              []string   `json:"models"`               // Returned by GET /models
+	EmbeddingDimensions int        `json:"embedding_dimensions"` // Size of the vectors from /embeddings
+	FlaggedWords        []string   `json:"flagged_words"`        // /moderations flags inputs containing one of these
+	Default             *Response  `json:"default"`              // Used when no scenario matches, echoes the question if nil
+	Scenarios           []Scenario `json:"scenarios"`            // Checked in order, the first match answers
+}
+
+// Scenario is a scripted answer and the requests it applies to
+type Scenario struct {
+	Name     string   `json:"name"` // Can be forced with the X-Fake-Scenario request header
+	Match    Match    `json:"match"`
+	Response Response `json:"response"`
+	Times    int      `json:"times"` // How often the scenario may answer, 0 = unlimited
+}
+
+// Match selects requests; empty fields match everything
+type Match struct {
+	Model     string `json:"model"`      // Exact model name
+	Contains  string `json:"contains"`   // Case-insensitive substring of the last user message
+	AfterTool *bool  `json:"after_tool"` // Whether the last message is a tool result
+}
+
+// Response describes what the server sends back
+type Response struct {
+	Content         string     `json:"content"`          // Streamed word by word unless Chunks is set
+	Chunks          []string   `json:"chunks"`           // Exact content deltas
+	ToolCalls       []ToolCall `json:"tool_calls"`       // Tool calls sent after the content
+	Usage           *Usage     `json:"usage"`            // Reported usage, estimated from the text if nil
+	OmitUsage       bool       `json:"omit_usage"`       // Send no usage chunk even if the client asks for one
+	Status          int        `json:"status"`           // Non-200 status returned instead of a stream
+	Error           string     `json:"error"`            // Error message for Status or StreamError
+	RetryAfter      int        `json:"retry_after"`      // Retry-After header in seconds, sent with Status
+	InitialDelayMs  int        `json:"initial_delay_ms"` // Wait before the response headers
+	ChunkDelayMs    int        `json:"chunk_delay_ms"`   // Wait between content chunks
+	DisconnectAfter int        `json:"disconnect_after"` // Drop the connection after this many chunks, 0 = never
+	StreamError     bool       `json:"stream_error"`     // Send an error event after the chunks instead of finishing
+}
+
+// ToolCall is a scripted function call
+type ToolCall struct {
+	ID        string          `json:"id"` // Generated if empty
+	Name      string          `json:"name"`
+	Arguments json.RawMessage `json:"arguments"` // JSON object, or a string holding one
+}
+
+// Usage is the scripted token count
+type Usage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+}
+
+// LoadFixture reads a fixture from a JSON file
+func LoadFixture(path string) (*Fixture, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var fixture Fixture
+	if err := json.Unmarshal(data, &fixture); err != nil {
+		return nil, fmt.Errorf("invalid fixture %s: %w", path, err)
+	}
+	return &fixture, nil
+}
+
+// matches reports whether the scenario applies to the request
+func (m Match) matches(req *chatRequest) bool {
+	if m.Model != "" && m.Model != req.Model {
+		return false
+	}
+	if m.Contains != "" && !strings.Contains(strings.ToLower(req.lastUserMessage()), strings.ToLower(m.Contains)) {
+		return false
+	}
+	if m.AfterTool != nil && *m.AfterTool != req.afterTool() {
+		return false
+	}
+	return true
+}
+
+// arguments returns the tool arguments as the JSON string OpenAI sends
+func (t ToolCall) arguments() string {
+	if len(t.Arguments) == 0 {
+		return "{}"
+	}
+	var text string
+	if err := json.Unmarshal(t.Arguments, &text); err == nil {
+		return text
+	}
+	return string(t.Arguments)
+}
+
+// chunks returns the content deltas, splitting Content after each word
+func (r *Response) chunks() []string {
+	if len(r.Chunks) > 0 {
+		return r.Chunks
+	}
+	var chunks []string
+	start := 0
+	for i, c := range r.Content {
+		if c == ' ' || c == '\n' {
+			chunks = append(chunks, r.Content[start:i+1])
+			start = i + 1
+		}
+	}
+	if start < len(r.Content) {
+		chunks = append(chunks, r.Content[start:])
+	}
+	return chunks
+}
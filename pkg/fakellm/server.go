@@ -0,0 +1,518 @@
+package fakellm
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// ScenarioHeader forces a scenario by name
+const ScenarioHeader = "X-Fake-Scenario"
+
+// Requests kept for GET /_fake/requests
+const maxRecordedRequests = 100
+
+// Server answers OpenAI chat completions, models and embeddings requests from a fixture
+type Server struct {
+	fixture  *Fixture
+	mu       sync.Mutex
+	used     map[int]int              // Answers given per scenario index
+	requests []map[string]interface{} // Recent chat requests, newest last
+	nextID   int
+}
+
+// Constructor
+func NewServer(fixture *Fixture) *Server {
+	if fixture == nil {
+		fixture = &Fixture{}
+	}
+	if len(fixture.Models) == 0 {
+		fixture.Models = []string{"fake-gpt"}
+	}
+	if fixture.EmbeddingDimensions <= 0 {
+		fixture.EmbeddingDimensions = 64
+	}
+	return &Server{fixture: fixture, used: map[int]int{}}
+}
+
+// ServeHTTP routes both the bare and the /v1 prefixed OpenAI paths; POST / is a chat completion too
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(strings.TrimSuffix(r.URL.Path, "/"), "/v1")
+	switch {
+	case path == "/_fake/reset" && r.Method == http.MethodPost:
+		s.reset(w)
+	case path == "/_fake/requests" && r.Method == http.MethodGet:
+		s.recorded(w)
+	case (path == "" || path == "/chat/completions") && r.Method == http.MethodPost:
+		s.chatCompletions(w, r)
+	case path == "/models" && r.Method == http.MethodGet:
+		s.models(w)
+	case path == "/embeddings" && r.Method == http.MethodPost:
+		s.embeddings(w, r)
+	case path == "/moderations" && r.Method == http.MethodPost:
+		s.moderations(w, r)
+	default:
+		writeError(w, http.StatusNotFound, "not_found", "Unknown endpoint "+r.Method+" "+r.URL.Path)
+	}
+}
+
+type chatMessage struct {
+	Role       string          `json:"role"`
+	Content    json.RawMessage `json:"content"`
+	ToolCallID string          `json:"tool_call_id"`
+}
+
+type chatRequest struct {
+	Model         string        `json:"model"`
+	Messages      []chatMessage `json:"messages"`
+	Stream        bool          `json:"stream"`
+	StreamOptions struct {
+		IncludeUsage bool `json:"include_usage"`
+	} `json:"stream_options"`
+}
+
+// text returns the content whether it was sent as a string or as content parts
+func (m chatMessage) text() string {
+	var text string
+	if err := json.Unmarshal(m.Content, &text); err == nil {
+		return text
+	}
+	var parts []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	}
+	if err := json.Unmarshal(m.Content, &parts); err == nil {
+		var b strings.Builder
+		for _, part := range parts {
+			b.WriteString(part.Text)
+		}
+		return b.String()
+	}
+	return ""
+}
+
+func (r *chatRequest) lastUserMessage() string {
+	for i := len(r.Messages) - 1; i >= 0; i-- {
+		if r.Messages[i].Role == "user" {
+			return r.Messages[i].text()
+		}
+	}
+	return ""
+}
+
+func (r *chatRequest) afterTool() bool {
+	return len(r.Messages) > 0 && r.Messages[len(r.Messages)-1].Role == "tool"
+}
+
+func (r *chatRequest) promptText() string {
+	var b strings.Builder
+	for _, msg := range r.Messages {
+		b.WriteString(msg.text())
+	}
+	return b.String()
+}
+
+// pick returns the response for a request and counts the use of its scenario
+func (s *Server) pick(req *chatRequest, forced string) (*Response, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, scenario := range s.fixture.Scenarios {
+		if forced != "" && scenario.Name != forced {
+			continue
+		}
+		if forced == "" && !scenario.Match.matches(req) {
+			continue
+		}
+		if scenario.Times > 0 && s.used[i] >= scenario.Times {
+			continue
+		}
+		s.used[i]++
+		response := scenario.Response
+		return &response, nil
+	}
+	if forced != "" {
+		return nil, fmt.Errorf("no usable scenario named %q", forced)
+	}
+
+	if s.fixture.Default != nil {
+		response := *s.fixture.Default
+		return &response, nil
+	}
+	return &Response{Content: "You said: " + req.lastUserMessage()}, nil
+}
+
+func (s *Server) chatCompletions(w http.ResponseWriter, r *http.Request) {
+	var raw map[string]interface{}
+	var req chatRequest
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&raw); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", "Invalid JSON body")
+		return
+	}
+	encoded, _ := json.Marshal(raw)
+	if err := json.Unmarshal(encoded, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", err.Error())
+		return
+	}
+	s.record(raw)
+
+	response, err := s.pick(&req, r.Header.Get(ScenarioHeader))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", err.Error())
+		return
+	}
+
+	if !sleep(r, response.InitialDelayMs) {
+		return
+	}
+	if response.Status != 0 && response.Status != http.StatusOK {
+		if response.RetryAfter > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(response.RetryAfter))
+		}
+		message := response.Error
+		if message == "" {
+			message = http.StatusText(response.Status)
+		}
+		writeError(w, response.Status, "fake_error", message)
+		return
+	}
+
+	id := s.completionID()
+	usage := response.Usage
+	if usage == nil {
+		usage = &Usage{
+			PromptTokens:     estimateTokens(req.promptText()),
+			CompletionTokens: estimateTokens(strings.Join(response.chunks(), "")),
+		}
+	}
+
+	if !req.Stream {
+		s.writeCompletion(w, id, &req, response, usage)
+		return
+	}
+	s.streamCompletion(w, r, id, &req, response, usage)
+}
+
+// streamCompletion writes the response as OpenAI chat.completion.chunk server-sent events
+func (s *Server) streamCompletion(w http.ResponseWriter, r *http.Request, id string, req *chatRequest, response *Response, usage *Usage) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "server_error", "Streaming unsupported")
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	created := time.Now().Unix()
+	send := func(payload interface{}) {
+		data, _ := json.Marshal(payload)
+		fmt.Fprintf(w, "data: %s\n\n", data)
+		flusher.Flush()
+	}
+	chunk := func(delta map[string]interface{}, finish interface{}) map[string]interface{} {
+		return map[string]interface{}{
+			"id":      id,
+			"object":  "chat.completion.chunk",
+			"created": created,
+			"model":   req.Model,
+			"choices": []map[string]interface{}{{"index": 0, "delta": delta, "finish_reason": finish}},
+		}
+	}
+
+	sent := 0
+	disconnect := func() {
+		if response.DisconnectAfter > 0 && sent >= response.DisconnectAfter {
+			// Drop the connection without finishing the chunked body
+			panic(http.ErrAbortHandler)
+		}
+	}
+
+	send(chunk(map[string]interface{}{"role": "assistant", "content": ""}, nil))
+	for i, content := range response.chunks() {
+		if i > 0 && !sleep(r, response.ChunkDelayMs) {
+			return
+		}
+		send(chunk(map[string]interface{}{"content": content}, nil))
+		sent++
+		disconnect()
+	}
+
+	// Tool calls are split in two fragments so clients have to assemble them
+	for i, call := range response.ToolCalls {
+		callID := call.ID
+		if callID == "" {
+			callID = fmt.Sprintf("call_fake_%d", i)
+		}
+		args := call.arguments()
+		half := len(args) / 2
+		send(chunk(map[string]interface{}{"tool_calls": []map[string]interface{}{{
+			"index": i, "id": callID, "type": "function",
+			"function": map[string]interface{}{"name": call.Name, "arguments": args[:half]},
+		}}}, nil))
+		send(chunk(map[string]interface{}{"tool_calls": []map[string]interface{}{{
+			"index": i, "function": map[string]interface{}{"arguments": args[half:]},
+		}}}, nil))
+		sent++
+		disconnect()
+	}
+
+	if response.StreamError {
+		message := response.Error
+		if message == "" {
+			message = "The server had an error while processing your request."
+		}
+		send(map[string]interface{}{"error": map[string]interface{}{"message": message, "type": "server_error"}})
+		return
+	}
+
+	finish := "stop"
+	if len(response.ToolCalls) > 0 {
+		finish = "tool_calls"
+	}
+	send(chunk(map[string]interface{}{}, finish))
+
+	if req.StreamOptions.IncludeUsage && !response.OmitUsage {
+		send(map[string]interface{}{
+			"id":      id,
+			"object":  "chat.completion.chunk",
+			"created": created,
+			"model":   req.Model,
+			"choices": []interface{}{},
+			"usage":   usagePayload(usage),
+		})
+	}
+	fmt.Fprint(w, "data: [DONE]\n\n")
+	flusher.Flush()
+}
+
+// writeCompletion answers a request with stream false
+func (s *Server) writeCompletion(w http.ResponseWriter, id string, req *chatRequest, response *Response, usage *Usage) {
+	message := map[string]interface{}{"role": "assistant", "content": strings.Join(response.chunks(), "")}
+	finish := "stop"
+	if len(response.ToolCalls) > 0 {
+		var calls []map[string]interface{}
+		for i, call := range response.ToolCalls {
+			callID := call.ID
+			if callID == "" {
+				callID = fmt.Sprintf("call_fake_%d", i)
+			}
+			calls = append(calls, map[string]interface{}{
+				"id": callID, "type": "function",
+				"function": map[string]interface{}{"name": call.Name, "arguments": call.arguments()},
+			})
+		}
+		message["tool_calls"] = calls
+		finish = "tool_calls"
+	}
+
+	payload := map[string]interface{}{
+		"id":      id,
+		"object":  "chat.completion",
+		"created": time.Now().Unix(),
+		"model":   req.Model,
+		"choices": []map[string]interface{}{{"index": 0, "message": message, "finish_reason": finish}},
+	}
+	if !response.OmitUsage {
+		payload["usage"] = usagePayload(usage)
+	}
+	writeJSON(w, http.StatusOK, payload)
+}
+
+func (s *Server) models(w http.ResponseWriter) {
+	var data []map[string]interface{}
+	for _, model := range s.fixture.Models {
+		data = append(data, map[string]interface{}{"id": model, "object": "model", "owned_by": "fakellm"})
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"object": "list", "data": data})
+}
+
+// embeddings returns hashed bag-of-words vectors, so texts sharing words come out similar
+func (s *Server) embeddings(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Model string          `json:"model"`
+		Input json.RawMessage `json:"input"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", "Invalid JSON body")
+		return
+	}
+	var inputs []string
+	if err := json.Unmarshal(req.Input, &inputs); err != nil {
+		var single string
+		if err := json.Unmarshal(req.Input, &single); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_request_error", "input must be a string or an array of strings")
+			return
+		}
+		inputs = []string{single}
+	}
+
+	var data []map[string]interface{}
+	tokens := 0
+	for i, input := range inputs {
+		data = append(data, map[string]interface{}{
+			"object":    "embedding",
+			"index":     i,
+			"embedding": hashEmbedding(input, s.fixture.EmbeddingDimensions),
+		})
+		tokens += estimateTokens(input)
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"object": "list",
+		"data":   data,
+		"model":  req.Model,
+		"usage":  map[string]int{"prompt_tokens": tokens, "total_tokens": tokens},
+	})
+}
+
+// moderations flags the inputs that contain one of the fixture's flagged words
+func (s *Server) moderations(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Model string          `json:"model"`
+		Input json.RawMessage `json:"input"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", "Invalid JSON body")
+		return
+	}
+	var inputs []string
+	if err := json.Unmarshal(req.Input, &inputs); err != nil {
+		var single string
+		if err := json.Unmarshal(req.Input, &single); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_request_error", "input must be a string or an array of strings")
+			return
+		}
+		inputs = []string{single}
+	}
+
+	var results []map[string]interface{}
+	for _, input := range inputs {
+		flagged := false
+		for _, word := range s.fixture.FlaggedWords {
+			if strings.Contains(strings.ToLower(input), strings.ToLower(word)) {
+				flagged = true
+				break
+			}
+		}
+		results = append(results, map[string]interface{}{
+			"flagged":    flagged,
+			"categories": map[string]bool{"harassment": flagged},
+		})
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"model":   req.Model,
+		"results": results,
+	})
+}
+
+// reset forgets scenario use counts and recorded requests
+func (s *Server) reset(w http.ResponseWriter) {
+	s.mu.Lock()
+	s.used = map[int]int{}
+	s.requests = nil
+	s.mu.Unlock()
+	writeJSON(w, http.StatusOK, map[string]string{"status": "reset"})
+}
+
+// recorded returns the recent chat requests so tests can check what the backend sent
+func (s *Server) recorded(w http.ResponseWriter) {
+	s.mu.Lock()
+	requests := append([]map[string]interface{}{}, s.requests...)
+	s.mu.Unlock()
+	writeJSON(w, http.StatusOK, map[string]interface{}{"requests": requests})
+}
+
+func (s *Server) record(req map[string]interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = append(s.requests, req)
+	if len(s.requests) > maxRecordedRequests {
+		s.requests = s.requests[len(s.requests)-maxRecordedRequests:]
+	}
+}
+
+func (s *Server) completionID() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextID++
+	return fmt.Sprintf("chatcmpl-fake-%d", s.nextID)
+}
+
+func hashEmbedding(text string, dims int) []float32 {
+	vector := make([]float64, dims)
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	for _, word := range words {
+		h := fnv.New64a()
+		h.Write([]byte(word))
+		sum := h.Sum64()
+		sign := 1.0
+		if sum&(1<<63) != 0 {
+			sign = -1
+		}
+		vector[sum%uint64(dims)] += sign
+	}
+
+	var norm float64
+	for _, v := range vector {
+		norm += v * v
+	}
+	norm = math.Sqrt(norm)
+	out := make([]float32, dims)
+	for i, v := range vector {
+		if norm > 0 {
+			out[i] = float32(v / norm)
+		}
+	}
+	return out
+}
+
+func usagePayload(usage *Usage) map[string]int {
+	return map[string]int{
+		"prompt_tokens":     usage.PromptTokens,
+		"completion_tokens": usage.CompletionTokens,
+		"total_tokens":      usage.PromptTokens + usage.CompletionTokens,
+	}
+}
+
+// estimateTokens uses the same four characters per token rule as the backend
+func estimateTokens(text string) int {
+	n := len([]rune(text))
+	return (n + 3) / 4
+}
+
+// sleep waits for ms milliseconds and reports false if the client went away meanwhile
+func sleep(r *http.Request, ms int) bool {
+	if ms <= 0 {
+		return true
+	}
+	timer := time.NewTimer(time.Duration(ms) * time.Millisecond)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-r.Context().Done():
+		return false
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, payload interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(payload)
+}
+
+func writeError(w http.ResponseWriter, status int, errType, message string) {
+	writeJSON(w, status, map[string]interface{}{
+		"error": map[string]interface{}{"message": message, "type": errType, "code": nil},
+	})
+}